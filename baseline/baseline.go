@@ -0,0 +1,223 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package baseline learns the elapsed time distribution of each monitored SQL ID over a
+// warm-up period, so that its executions can be judged against the learned baseline (e.g.
+// its p99 times a factor) rather than against a static threshold.  The baselines are
+// persisted on disk across restarts.
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var Debug bool
+
+// Defaults of the learning.
+const (
+	DefaultWarmUp        = 24 * time.Hour
+	DefaultMinExecutions = 100
+	DefaultFactor        = 2
+	DefaultPercentile    = 99
+)
+
+// saveInterval is how often the baselines still being learned are saved at most.
+const saveInterval = time.Minute
+
+// Elapsed times are learned in buckets of exponentially growing width: bucket i holds the
+// times in [bucketBase*growth^(i-1), bucketBase*growth^i), which keeps the percentiles
+// within 5% of the observed ones.
+const (
+	bucketBase = 0.001 // Milliseconds, i.e. 1 microsecond: the resolution of the trace
+	growth     = 1.05
+)
+
+// Config tunes the learning of the baselines.
+type Config struct {
+	WarmUp        time.Duration // How long a baseline is learned for once the first execution is seen
+	MinExecutions int64         // How many executions a baseline needs at least, even after WarmUp
+	Factor        float64       // Executions over Percentile times Factor deviate from the baseline
+	Percentile    float64       // e.g. 99
+}
+
+// SQL is the elapsed time distribution of a SQL ID, learned (or being learned) since Since.
+type SQL struct {
+	Since      time.Time
+	Executions int64
+	Buckets    map[int]int64 // Executions per bucket of the elapsed time
+	Learned    bool          // The warm-up is over, the baseline is final
+	P50        float64       // Milliseconds, once learned
+	PTarget    float64       // Milliseconds at Config.Percentile, once learned
+}
+
+// Learned is the baseline of a SQL ID an execution is judged against.
+type Learned struct {
+	P50        float64 // Milliseconds
+	PTarget    float64 // Milliseconds at Percentile
+	Percentile float64
+	Factor     float64
+	Limit      float64 // PTarget times Factor, in milliseconds
+	Executions int64   // The baseline was learned from
+}
+
+// Baseline holds the baselines of the SQL IDs.
+// It is safe to use from multiple goroutines simultaneously.
+type Baseline struct {
+	sync.Mutex
+	Config   Config          `json:"-"`
+	FileName string          `json:"-"`
+	SQL      map[string]*SQL // By SQL ID
+	saved    time.Time       // When the baselines were last saved
+}
+
+// Load loads the baselines from disk.
+// If the file doesn't exist yet, all the baselines are to be learned afresh.
+func Load(fileName string, cfg Config) (*Baseline, error) {
+	b := &Baseline{Config: cfg, FileName: fileName, SQL: make(map[string]*SQL)}
+	out, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		if Debug {
+			fmt.Printf("[%v] dbg> baseline.Load: no baseline file %q yet, learning afresh\n", time.Now().Format("2006-01-02 15:04:05"), fileName)
+		}
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("baseline.Load: %v", err)
+	}
+	if err := json.Unmarshal(out, b); err != nil {
+		return nil, fmt.Errorf("baseline.Load: can't parse %q: %v", fileName, err)
+	}
+	if b.SQL == nil {
+		b.SQL = make(map[string]*SQL)
+	}
+	return b, nil
+}
+
+// Learn accounts an execution of a SQL ID that ended at a time with an elapsed time (in
+// milliseconds) to its baseline, as long as the baseline is being learned.  The baseline
+// is final once the warm-up is over and it has had enough executions.
+func (b *Baseline) Learn(sqlID string, at time.Time, ela float64) {
+	b.Lock()
+	defer b.Unlock()
+	s, ok := b.SQL[sqlID]
+	if !ok {
+		s = &SQL{Since: at, Buckets: make(map[int]int64)}
+		b.SQL[sqlID] = s
+	}
+	if s.Learned {
+		return
+	}
+	s.Buckets[bucket(ela)]++
+	s.Executions++
+	learned := at.Sub(s.Since) >= b.Config.WarmUp && s.Executions >= b.Config.MinExecutions
+	if learned {
+		s.Learned = true
+		s.P50 = s.percentile(50)
+		s.PTarget = s.percentile(b.Config.Percentile)
+		fmt.Printf("[%v] info> baseline of SQL_ID=%s learned from %d executions since %v: p50=%.3f [ms] p%g=%.3f [ms]\n", time.Now().Format("2006-01-02 15:04:05"), sqlID, s.Executions, s.Since.Format("2006-01-02 15:04:05"), s.P50, b.Config.Percentile, s.PTarget)
+	}
+	if learned || time.Since(b.saved) >= saveInterval {
+		if err := b.save(); err != nil {
+			fmt.Printf("[%v] error> could not save the baselines: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+	}
+}
+
+// Get returns the learned baseline of a SQL ID, if the SQL ID has one by now.
+func (b *Baseline) Get(sqlID string) (Learned, bool) {
+	b.Lock()
+	defer b.Unlock()
+	s, ok := b.SQL[sqlID]
+	if !ok || !s.Learned {
+		return Learned{}, false
+	}
+	return Learned{
+		P50:        s.P50,
+		PTarget:    s.PTarget,
+		Percentile: b.Config.Percentile,
+		Factor:     b.Config.Factor,
+		Limit:      s.PTarget * b.Config.Factor,
+		Executions: s.Executions,
+	}, true
+}
+
+// Save saves the baselines to disk.  This creates the directory by default.
+func (b *Baseline) Save() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.save()
+}
+
+// save saves the baselines, the caller holds the lock.  The file is replaced as a whole,
+// so that a crash doesn't leave a truncated one behind.
+func (b *Baseline) save() error {
+	out, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.FileName), 0755); err != nil {
+		return err
+	}
+	tmp := b.FileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.FileName); err != nil {
+		return err
+	}
+	b.saved = time.Now()
+	if Debug {
+		fmt.Printf("[%v] dbg> baseline.save: saved the baselines of %d SQL IDs to %q\n", time.Now().Format("2006-01-02 15:04:05"), len(b.SQL), b.FileName)
+	}
+	return nil
+}
+
+// bucket returns the bucket of an elapsed time.
+func bucket(ela float64) int {
+	if ela < bucketBase {
+		return 0
+	}
+	return int(math.Floor(math.Log(ela/bucketBase)/math.Log(growth))) + 1
+}
+
+// upper returns the upper bound of a bucket.
+func upper(i int) float64 {
+	return bucketBase * math.Pow(growth, float64(i))
+}
+
+// percentile returns the elapsed time (the upper bound of its bucket) a percentage of the
+// executions are within.
+func (s *SQL) percentile(p float64) float64 {
+	var buckets []int
+	for i := range s.Buckets {
+		buckets = append(buckets, i)
+	}
+	sort.Ints(buckets)
+	want := int64(math.Ceil(p / 100 * float64(s.Executions)))
+	var n int64
+	for _, i := range buckets {
+		n += s.Buckets[i]
+		if n >= want {
+			return upper(i)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Baseline_test runs unit tests on the learning of the baselines and their persistence.
+package baseline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2017, 1, 30, 16, 0, 0, 0, time.UTC)
+
+func TestLearn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLearn")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "rtta.baseline")
+	cfg := Config{WarmUp: time.Hour, MinExecutions: 100, Factor: 2, Percentile: DefaultPercentile}
+
+	b, err := Load(fileName, cfg)
+	if err != nil {
+		t.Fatalf("Load(%q) of no file failed: %v", fileName, err)
+	}
+	// 1..100 ms, one a minute: the warm-up is over after 61 executions, but 100 are needed.
+	at := start
+	for i := 1; i <= 99; i++ {
+		at = start.Add(time.Duration(i) * time.Minute)
+		b.Learn("acc988uzvjmmt", at, float64(i))
+	}
+	if got, ok := b.Get("acc988uzvjmmt"); ok {
+		t.Fatalf("Get() after 99 executions = %+v, want none yet", got)
+	}
+	b.Learn("acc988uzvjmmt", at.Add(time.Minute), 100)
+	got, ok := b.Get("acc988uzvjmmt")
+	if !ok {
+		t.Fatalf("Get() after 100 executions = none, want a baseline")
+	}
+	// Buckets are 5% wide.
+	if got.P50 < 50 || got.P50 > 52.5 || got.PTarget < 99 || got.PTarget > 104 || got.Limit != got.PTarget*2 || got.Executions != 100 {
+		t.Errorf("Get() = %+v, want p50 of 50 and p99 of 99 (within 5%%) times 2", got)
+	}
+	// The baseline is final: an outlier is not learned.
+	b.Learn("acc988uzvjmmt", at.Add(2*time.Minute), 100000)
+	if again, _ := b.Get("acc988uzvjmmt"); again != got {
+		t.Errorf("Get() after the baseline was learned = %+v, want %+v", again, got)
+	}
+
+	// Learned baselines survive a restart, the ones still being learned go on from where they were.
+	b.Learn("7zt3ax6mmtrbq", at, 10)
+	if err := b.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	b, err = Load(fileName, cfg)
+	if err != nil {
+		t.Fatalf("Load(%q) failed: %v", fileName, err)
+	}
+	if again, ok := b.Get("acc988uzvjmmt"); !ok || again != got {
+		t.Errorf("Get() after a restart = %+v, want %+v", again, got)
+	}
+	if s := b.SQL["7zt3ax6mmtrbq"]; s == nil || s.Learned || s.Executions != 1 || !s.Since.Equal(at) {
+		t.Errorf("baseline of 7zt3ax6mmtrbq after a restart = %+v, want 1 execution since %v", s, at)
+	}
+}
+
+func TestLoadBadFile(t *testing.T) {
+	fh, err := ioutil.TempFile("", "TestLoadBadFile")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile() failed: %v", err)
+	}
+	defer os.Remove(fh.Name())
+	fh.WriteString("{not JSON")
+	fh.Close()
+	if b, err := Load(fh.Name(), Config{}); err == nil {
+		t.Errorf("Load() of a bad file = %+v, want an error", b)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	s := &SQL{Buckets: make(map[int]int64)}
+	for _, ela := range []float64{0.0005, 1, 1, 1, 10} {
+		s.Buckets[bucket(ela)]++
+		s.Executions++
+	}
+	if got := s.percentile(20); got != bucketBase {
+		t.Errorf("percentile(20) = %v, want %v", got, bucketBase)
+	}
+	if got := s.percentile(80); got < 1 || got > 1.05 {
+		t.Errorf("percentile(80) = %v, want 1 (within 5%%)", got)
+	}
+	if got := s.percentile(99); got < 10 || got > 10.5 {
+		t.Errorf("percentile(99) = %v, want 10 (within 5%%)", got)
+	}
+}
@@ -14,25 +14,177 @@ limitations under the License.
 // Package cursor provides facilities to parse Oracle cursors.
 package cursor
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultSession holds the cursors parsed before the first SESSION ID marker of a trace file.
+const defaultSession = ""
+
+// ErrExistingCursor is returned by CompareAndSet if a cursor# is already open.
+var ErrExistingCursor = fmt.Errorf("existing-cursor")
+
+// MaxCursorsPerSession caps the number of cursors a Tracker keeps for a single session.
+// Cursors are normally retired by their CLOSE records, the cap is a safety net for
+// long-lived (e.g. connection pool) sessions: once reached, the least recently used
+// cursor of the session is evicted to make room for a new one.  0 means no cap.
+var MaxCursorsPerSession = 1000
+
+// MaxTransactionIdle is the longest a business transaction in progress may go without a call
+// of its SQL statements before it's discarded, e.g. when it only ran queries and so has no
+// XCTEND to end it.  0 means no limit.
+var MaxTransactionIdle = time.Minute
+
+// maxSQLText caps the SQL text kept per cursor.
+const maxSQLText = 64 * 1024
+
+// Counters of the cursors of all the Trackers of the process.
+type Counters struct {
+	Tracked int64 // Currently tracked
+	Closed  int64 // Retired by a CLOSE record
+	Evicted int64 // Evicted by the MaxCursorsPerSession cap
+}
+
+var counters Counters
+
+// Counts returns a snapshot of the cursor counters.
+func Counts() Counters {
+	return Counters{
+		Tracked: atomic.LoadInt64(&counters.Tracked),
+		Closed:  atomic.LoadInt64(&counters.Closed),
+		Evicted: atomic.LoadInt64(&counters.Evicted),
+	}
+}
+
 // Cursor holds performance stats mined from a trace file.
 type Cursor struct {
 	CursorID       int64
 	SQLID          string
 	BusinessTxName string
-	ELAThreshold   int64
+	ELAThreshold   float64
+	ByContext      bool // Whether the business tx is told by the application context of each call, rather than by the SQL
 	hashValue      string
 	length         int
 	depth          int
 	uID            int
 	lID            int
 	oct            int
+	lastCall       string           // PARSE, EXEC or FETCH: the last call seen for the cursor
+	pendingWaits   map[string]*Wait // Waits seen since the last call (they precede the call they belong to)
+	execWaits      map[string]*Wait // Waits of the execution in progress
+	execution      Execution        // Calls of the execution in progress
+	finished       *Execution       // Execution ended by the latest call, if any
+	finishedWaits  map[string]*Wait // Waits of the execution ended by the latest call
+	finishedBinds  []Bind           // Bind values the execution ended by the latest call ran with
+	binds          []Bind           // Bind values of the latest BINDS block
+	execBinds      []Bind           // Bind values the execution in progress ran with
+	planHash       string           // Plan hash value (plh=) of the latest call
+	planChanged    bool             // Whether planHash has changed since PlanChanged was last called
+	rowSource      []RowSource      // Row source tree of the latest STAT dump
+	rowSourcePlan  string           // Plan hash value in effect when the row source tree was dumped
+	lastUsed       int64            // Tracker's clock value of the latest access to the cursor
+	sqlText        []string         // Lines of the SQL text between PARSING IN CURSOR and END OF STMT
+	sqlTextLen     int              // Length of sqlText, capped by maxSQLText
+}
+
+// ResetSQLText discards the SQL text of the cursor, e.g. before it's read again on a re-parse.
+func (c *Cursor) ResetSQLText() {
+	c.sqlText = nil
+	c.sqlTextLen = 0
+}
+
+// AddSQLText appends a line of the SQL text following PARSING IN CURSOR.
+func (c *Cursor) AddSQLText(line string) {
+	if c.sqlTextLen >= maxSQLText {
+		return
+	}
+	c.sqlText = append(c.sqlText, line)
+	c.sqlTextLen += len(line) + 1
+}
+
+// SQLText returns the SQL text of the cursor.
+func (c *Cursor) SQLText() string {
+	return strings.Join(c.sqlText, "\n")
+}
+
+// RowSource is a row source operation (a STAT line) of an execution plan.
+// Time is the time spent in the operation and its children in microseconds.
+type RowSource struct {
+	ID        int
+	ParentID  int
+	Operation string
+	Rows      int64
+	CR        int64
+	Time      int64
+}
+
+// Execution accumulates the calls of one execution of a cursor: its PARSE, EXEC
+// and all the FETCHes up to the next EXEC (or CLOSE).  Times are in microseconds.
+type Execution struct {
+	Parse   int64
+	Exec    int64
+	Fetch   int64
+	Fetches int64
+	CPU     int64
+	IO
+	Recursive []Statement // Recursive (dep=1) SQL run on behalf of the execution's calls
+	violated  bool
+}
+
+// IO holds the logical and physical I/O stats of PARSE, EXEC and FETCH calls.
+type IO struct {
+	CR        int64 // Consistent reads (cr=)
+	CU        int64 // Current mode reads (cu=)
+	PhysReads int64 // Physical reads (p=)
+	Rows      int64 // Rows processed (r=)
+	Misses    int64 // Library cache misses, i.e. hard parses (mis=)
+}
+
+// Gets returns the number of buffer gets (logical reads).
+func (io IO) Gets() int64 {
+	return io.CR + io.CU
+}
+
+// ELA returns the elapsed time of the execution so far.
+func (e Execution) ELA() int64 {
+	return e.Parse + e.Exec + e.Fetch
+}
+
+// Violated reports whether or not the execution has been marked as a violation.
+func (e Execution) Violated() bool {
+	return e.violated
+}
+
+// MarkViolated flags the execution as one that has crossed its threshold.
+func (e *Execution) MarkViolated() {
+	e.violated = true
+}
+
+// Bind holds a bind variable value captured from a BINDS block of a trace (10046 level 4).
+// Position is zero based, as in Oracle's own Bind#0, Bind#1, ...
+type Bind struct {
+	Position int
+	Value    string
+}
+
+// Wait summarizes the wait events of the same name.
+// ELA is the total time waited in microseconds.
+type Wait struct {
+	Name  string
+	ELA   int64
+	Count int64
 }
 
 // NewCursor opens a new cursor for PARSING IN CURSOR trace record.
 // hashValue represents SQL hash value. length is SQL statement text's length in bytes.
 // depth is recursive call depth. uID is parsing user's identity.
 // lID is parsing schema's identity. oct is Oracle's command type.
-func NewCursor(cursorID int64, SQLID string, businessTxName string, elaThreshold int64, hashValue string, length int, depth int, uID int, lID int, oct int) *Cursor {
+func NewCursor(cursorID int64, SQLID string, businessTxName string, elaThreshold float64, hashValue string, length int, depth int, uID int, lID int, oct int) *Cursor {
 	return &Cursor{
 		CursorID:       cursorID,
 		SQLID:          SQLID,
@@ -46,3 +198,710 @@ func NewCursor(cursorID int64, SQLID string, businessTxName string, elaThreshold
 		oct:            oct,
 	}
 }
+
+// AddWait records a wait event for the cursor.  Oracle writes the WAIT lines before
+// the PARSE, EXEC or FETCH line of the call they belong to, so the wait stays pending
+// until the next call is accounted for with Call.
+func (c *Cursor) AddWait(name string, ela int64) {
+	if c.pendingWaits == nil {
+		c.pendingWaits = make(map[string]*Wait)
+	}
+	w, ok := c.pendingWaits[name]
+	if !ok {
+		w = &Wait{Name: name}
+		c.pendingWaits[name] = w
+	}
+	w.ELA += ela
+	w.Count++
+}
+
+// Call accounts a PARSE, EXEC or FETCH call of the cursor (and the waits pending
+// for it) to the execution in progress.  A PARSE, as well as an EXEC that doesn't
+// follow a PARSE, starts a new execution and so drops the stats of the previous one.
+func (c *Cursor) Call(callType string, cpu, ela int64, io IO) {
+	c.finished, c.finishedWaits, c.finishedBinds = nil, nil, nil
+	if callType == "PARSE" || (callType == "EXEC" && c.lastCall != "PARSE") {
+		if c.lastCall != "" {
+			done := c.execution
+			c.finished, c.finishedWaits, c.finishedBinds = &done, c.execWaits, c.execBinds
+		}
+		c.EndExecution()
+	}
+	// Oracle writes the BINDS block of an execution before its EXEC.
+	if callType == "EXEC" {
+		c.execBinds = c.binds
+	}
+	switch callType {
+	case "PARSE":
+		c.execution.Parse += ela
+	case "EXEC":
+		c.execution.Exec += ela
+	case "FETCH":
+		c.execution.Fetch += ela
+		c.execution.Fetches++
+	}
+	c.execution.CPU += cpu
+	c.execution.CR += io.CR
+	c.execution.CU += io.CU
+	c.execution.PhysReads += io.PhysReads
+	c.execution.Rows += io.Rows
+	c.execution.Misses += io.Misses
+	if c.execWaits == nil {
+		c.execWaits = make(map[string]*Wait)
+	}
+	for name, pw := range c.pendingWaits {
+		w, ok := c.execWaits[name]
+		if !ok {
+			w = &Wait{Name: name}
+			c.execWaits[name] = w
+		}
+		w.ELA += pw.ELA
+		w.Count += pw.Count
+	}
+	c.pendingWaits = nil
+	c.lastCall = callType
+}
+
+// EndExecution closes the execution in progress, e.g. when the cursor is closed.
+func (c *Cursor) EndExecution() {
+	c.execution = Execution{}
+	c.execWaits = nil
+	c.execBinds = nil
+	c.lastCall = ""
+}
+
+// Finished returns the execution ended by the latest call, i.e. the one in progress
+// until a PARSE or EXEC started another execution of the cursor.
+func (c *Cursor) Finished() (Execution, bool) {
+	if c.finished == nil {
+		return Execution{}, false
+	}
+	return *c.finished, true
+}
+
+// LastCall returns the last call (PARSE, EXEC or FETCH) of the execution in progress,
+// if any.
+func (c *Cursor) LastCall() string {
+	return c.lastCall
+}
+
+// Execution returns the stats of the execution in progress.
+func (c *Cursor) Execution() Execution {
+	return c.execution
+}
+
+// MarkViolated flags the execution in progress as one that has crossed its threshold,
+// e.g. with one of its calls.
+func (c *Cursor) MarkViolated() {
+	c.execution.violated = true
+}
+
+// TopWaits returns up to n wait events of the execution in progress, the longest first.
+func (c *Cursor) TopWaits(n int) []Wait {
+	return topWaits(c.execWaits, n)
+}
+
+// FinishedTopWaits returns up to n wait events of the execution ended by the latest call,
+// the longest first.
+func (c *Cursor) FinishedTopWaits(n int) []Wait {
+	return topWaits(c.finishedWaits, n)
+}
+
+// FinishedBinds returns the bind values the execution ended by the latest call ran with.
+func (c *Cursor) FinishedBinds() []Bind {
+	if len(c.finishedBinds) == 0 {
+		return nil
+	}
+	return append([]Bind(nil), c.finishedBinds...)
+}
+
+// topWaits returns up to n wait events, the longest first.
+func topWaits(execWaits map[string]*Wait, n int) []Wait {
+	var waits []Wait
+	for _, w := range execWaits {
+		waits = append(waits, *w)
+	}
+	sort.Slice(waits, func(i, j int) bool {
+		if waits[i].ELA != waits[j].ELA {
+			return waits[i].ELA > waits[j].ELA
+		}
+		return waits[i].Name < waits[j].Name
+	})
+	if len(waits) > n {
+		waits = waits[:n]
+	}
+	return waits
+}
+
+// ResetBinds drops the bind values of the previous execution before a new BINDS block is read.
+func (c *Cursor) ResetBinds() {
+	c.binds = nil
+}
+
+// AddBind records the next bind variable of a BINDS block.  Its value is set with SetBindValue.
+func (c *Cursor) AddBind(position int) {
+	c.binds = append(c.binds, Bind{Position: position})
+}
+
+// SetBindValue sets the value of the bind variable last added with AddBind.
+func (c *Cursor) SetBindValue(value string) {
+	if len(c.binds) == 0 {
+		return
+	}
+	c.binds[len(c.binds)-1].Value = value
+}
+
+// Binds returns the bind values of the latest execution of the cursor.
+func (c *Cursor) Binds() []Bind {
+	if len(c.binds) == 0 {
+		return nil
+	}
+	return append([]Bind(nil), c.binds...)
+}
+
+// SetPlanHash records the plan hash value of the latest call of the cursor.
+func (c *Cursor) SetPlanHash(planHash string) {
+	if planHash == c.planHash {
+		return
+	}
+	c.planHash = planHash
+	c.planChanged = true
+}
+
+// PlanChanged reports whether the plan hash value has changed since PlanChanged was last
+// called, i.e. whether the cursor runs with another plan since it was last checked.
+func (c *Cursor) PlanChanged() bool {
+	changed := c.planChanged
+	c.planChanged = false
+	return changed
+}
+
+// PlanHash returns the plan hash value of the latest call of the cursor.
+func (c *Cursor) PlanHash() string {
+	return c.planHash
+}
+
+// AddRowSource records a STAT line of the cursor.  A row source with the ID of 1 starts
+// a new plan dump and so drops the previously recorded one.
+func (c *Cursor) AddRowSource(rs RowSource) {
+	if rs.ID == 1 {
+		c.rowSource = nil
+		c.rowSourcePlan = c.planHash
+	}
+	c.rowSource = append(c.rowSource, rs)
+}
+
+// RowSource returns the latest row source tree dumped for the cursor's current plan.
+// Oracle dumps the STAT lines after an execution completes, so this is the tree of
+// a previous execution with the same plan hash value, if any.
+func (c *Cursor) RowSource() []RowSource {
+	if len(c.rowSource) == 0 || c.rowSourcePlan != c.planHash {
+		return nil
+	}
+	return append([]RowSource(nil), c.rowSource...)
+}
+
+// Transaction accumulates the monitored SQL statements of a business transaction a session
+// runs from the first one up to the end of the database transaction (XCTEND).
+// Start and End are the trace times (tim) of the beginning of the first call and of the end
+// of the latest one, in microseconds.
+type Transaction struct {
+	BusinessTxName string
+	ELAThreshold   float64
+	Start          int64
+	End            int64
+	Statements     []Statement
+}
+
+// IdleAt reports whether the transaction has gone without a call for longer than
+// MaxTransactionIdle by the trace time at.
+func (tx *Transaction) IdleAt(at int64) bool {
+	return MaxTransactionIdle > 0 && time.Duration(at-tx.End)*time.Microsecond > MaxTransactionIdle
+}
+
+// Statement is the share of a SQL statement in a Transaction.  ELA is the total
+// elapsed time of its calls in microseconds.
+type Statement struct {
+	SQLID string
+	ELA   int64
+	Calls int64
+}
+
+// add accounts a call of a SQL statement to the transaction.
+func (tx *Transaction) add(sqlID string, ela int64) {
+	tx.Statements = addStatement(tx.Statements, Statement{SQLID: sqlID, ELA: ela, Calls: 1})
+}
+
+// addStatement merges the calls of a statement into a list of statements.
+func addStatement(statements []Statement, st Statement) []Statement {
+	for i := range statements {
+		if statements[i].SQLID == st.SQLID {
+			statements[i].ELA += st.ELA
+			statements[i].Calls += st.Calls
+			return statements
+		}
+	}
+	return append(statements, st)
+}
+
+// AddRecursive accounts the recursive SQL run on behalf of the latest call of the cursor
+// to the execution in progress.
+func (c *Cursor) AddRecursive(children []Statement) {
+	for _, st := range children {
+		c.execution.Recursive = addStatement(c.execution.Recursive, st)
+	}
+}
+
+// recursion follows the recursive (dep>0) SQL of a session.
+type recursion struct {
+	cursors  map[int64]*recursiveCursor // Recursive cursors by cursor#
+	children []Statement                // Calls of the dep=1 cursors since the latest top-level call
+}
+
+// recursiveCursor is the SQL ID of a recursive cursor, evicted the same LRU way as the cursors.
+type recursiveCursor struct {
+	sqlID    string
+	lastUsed int64 // Tracker's clock value of the latest access to the cursor
+}
+
+// Tracker is a syncronization mechanism to access the cursors of a single trace file.
+// Cursor numbers are only unique within a database session and so the cursors are
+// kept per session: the one of the latest "*** SESSION ID:" marker seen in the trace.
+// A Tracker is created when a Miner starts on a trace file and discarded when it exits.
+type Tracker struct {
+	sync.RWMutex
+	TraceName string
+	session   string
+	Sessions  map[string]map[int64]*Cursor
+	binds     *Cursor                            // Cursor whose BINDS block is being read, if any
+	txs       map[string]map[string]*Transaction // Business transactions in progress of each session by name
+	clock     int64                              // Incremented on every cursor access, drives the LRU eviction
+	text      *SQLTextBlock                      // SQL text block being read, if any
+	contexts  map[string]*Context                // Application context of each session
+	timeMark  time.Time                          // Wall clock time of the latest "***" timestamp marker, until a tim= follows it
+	timeBase  time.Time                          // Wall clock time of timBase
+	timBase   int64                              // tim= (microseconds) of the first record following a timestamp marker
+	recursion map[string]*recursion              // Recursive SQL of each session
+}
+
+// Names of the trace file markers of the application context attributes.
+const (
+	ContextModule   = "MODULE NAME"
+	ContextAction   = "ACTION NAME"
+	ContextClientID = "CLIENT ID"
+	ContextService  = "SERVICE NAME"
+)
+
+// Context is the application context of a session as set by the "*** MODULE NAME:",
+// "*** ACTION NAME:", "*** CLIENT ID:" and "*** SERVICE NAME:" markers of a trace file.
+type Context struct {
+	Module   string
+	Action   string
+	ClientID string
+	Service  string
+}
+
+// SQLTextBlock is the SQL text between a PARSING IN CURSOR record and its END OF STMT, or
+// the one following a PARSE ERROR record (up to the next record).
+// Cur is nil if the text is of no interest.  A Pending cursor is not tracked yet (or is only
+// tracked by the application context): it is only tracked if its text matches a business transaction.
+type SQLTextBlock struct {
+	Key      int64
+	Cur      *Cursor
+	Pending  bool
+	ParseErr int64 // ORA- error of the PARSE ERROR record the text follows, 0 for PARSING IN CURSOR
+}
+
+// NewTracker returns an empty cursor tracker for a trace file.
+func NewTracker(traceName string) *Tracker {
+	return &Tracker{
+		TraceName: traceName,
+		session:   defaultSession,
+		Sessions:  map[string]map[int64]*Cursor{defaultSession: make(map[int64]*Cursor)},
+		txs:       make(map[string]map[string]*Transaction),
+		contexts:  make(map[string]*Context),
+		recursion: make(map[string]*recursion),
+	}
+}
+
+// SetSession makes sessionID the current session of the trace file, i.e. the one
+// that the subsequent cursor operations are applied to.  The business transactions in
+// progress in the session switched from are discarded: their end can't be told anymore.
+func (t *Tracker) SetSession(sessionID string) {
+	t.Lock()
+	defer t.Unlock()
+	if sessionID != t.session {
+		delete(t.txs, t.session)
+	}
+	t.session = sessionID
+	if _, ok := t.Sessions[sessionID]; !ok {
+		t.Sessions[sessionID] = make(map[int64]*Cursor)
+	}
+}
+
+// SetContext sets an attribute of the application context of the current session, name
+// being the one of its trace file marker, e.g. ContextModule for "*** MODULE NAME:".
+func (t *Tracker) SetContext(name, value string) {
+	t.Lock()
+	defer t.Unlock()
+	ctx, ok := t.contexts[t.session]
+	if !ok {
+		ctx = &Context{}
+		t.contexts[t.session] = ctx
+	}
+	switch name {
+	case ContextModule:
+		ctx.Module = value
+	case ContextAction:
+		ctx.Action = value
+	case ContextClientID:
+		ctx.ClientID = value
+	case ContextService:
+		ctx.Service = value
+	}
+}
+
+// Context returns the application context of the current session.
+func (t *Tracker) Context() Context {
+	t.RLock()
+	defer t.RUnlock()
+	if ctx, ok := t.contexts[t.session]; ok {
+		return *ctx
+	}
+	return Context{}
+}
+
+// Session returns the ID of the current session.
+func (t *Tracker) Session() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.session
+}
+
+// Get performs a protected read of the current session's cursors, marking the cursor
+// as the most recently used one.  It is safe to use from multiple goroutines simultaneously.
+func (t *Tracker) Get(key int64) *Cursor {
+	t.Lock()
+	defer t.Unlock()
+	cur := t.Sessions[t.session][key]
+	if cur != nil {
+		t.clock++
+		cur.lastUsed = t.clock
+	}
+	return cur
+}
+
+// HasValue performs a protected lookup in the current session's cursors.  It is safe
+// to use from multiple goroutines simultaneously.
+func (t *Tracker) HasValue(key int64) bool {
+	t.RLock()
+	_, ok := t.Sessions[t.session][key]
+	t.RUnlock()
+	return ok
+}
+
+// Set performs a protected write.  It is safe to use from multiple goroutines simultaneously.
+func (t *Tracker) Set(key int64, value *Cursor) {
+	t.Lock()
+	t.add(key, value)
+	t.Unlock()
+}
+
+// Delete performs a protected write, and is safe to use from multiple goroutines.
+func (t *Tracker) Delete(key int64) {
+	t.Lock()
+	t.remove(key)
+	t.Unlock()
+}
+
+// Close retires a cursor of the current session on its CLOSE record, reporting
+// whether or not the cursor was tracked.
+func (t *Tracker) Close(key int64) bool {
+	t.Lock()
+	defer t.Unlock()
+	if !t.remove(key) {
+		return false
+	}
+	atomic.AddInt64(&counters.Closed, 1)
+	return true
+}
+
+// Len returns the number of cursors tracked across all the sessions of the trace file.
+func (t *Tracker) Len() int {
+	t.RLock()
+	defer t.RUnlock()
+	var n int
+	for _, cursors := range t.Sessions {
+		n += len(cursors)
+	}
+	return n
+}
+
+// Release discards all the cursors of the trace file, along with what is kept of its
+// sessions (their transactions, application contexts, etc.).  It's called when the Miner
+// of the trace file exits.
+func (t *Tracker) Release() {
+	t.Lock()
+	defer t.Unlock()
+	for session, cursors := range t.Sessions {
+		atomic.AddInt64(&counters.Tracked, -int64(len(cursors)))
+		t.Sessions[session] = make(map[int64]*Cursor)
+	}
+	t.binds = nil
+	t.text = nil
+	t.txs = make(map[string]map[string]*Transaction)
+	t.contexts = make(map[string]*Context)
+	t.recursion = make(map[string]*recursion)
+}
+
+// add records a cursor in the current session, evicting the least recently used one at the cap.  The caller holds the lock.
+func (t *Tracker) add(key int64, cur *Cursor) {
+	cursors := t.Sessions[t.session]
+	if _, ok := cursors[key]; !ok {
+		if MaxCursorsPerSession > 0 && len(cursors) >= MaxCursorsPerSession {
+			t.evict()
+		}
+		atomic.AddInt64(&counters.Tracked, 1)
+	}
+	t.clock++
+	cur.lastUsed = t.clock
+	cursors[key] = cur
+}
+
+// remove drops a cursor of the current session, reporting whether it was tracked.  The caller holds the lock.
+func (t *Tracker) remove(key int64) bool {
+	cur, ok := t.Sessions[t.session][key]
+	if !ok {
+		return false
+	}
+	if t.binds == cur {
+		t.binds = nil
+	}
+	delete(t.Sessions[t.session], key)
+	atomic.AddInt64(&counters.Tracked, -1)
+	return true
+}
+
+// evict drops the least recently used cursor of the current session.  The caller holds the lock.
+func (t *Tracker) evict() {
+	lru := int64(-1)
+	for key, cur := range t.Sessions[t.session] {
+		if lru == -1 || cur.lastUsed < t.Sessions[t.session][lru].lastUsed {
+			lru = key
+		}
+	}
+	if lru != -1 && t.remove(lru) {
+		atomic.AddInt64(&counters.Evicted, 1)
+	}
+}
+
+// CompareAndSet automically checks if a cursor is new or an existing one.
+// It a cursor is new, it then proceeds with "openning it" and "setting" it in the
+// current session's cursor map.
+func (t *Tracker) CompareAndSet(key int64, open func() (*Cursor, error)) (*Cursor, error) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.Sessions[t.session][key]; ok {
+		return nil, ErrExistingCursor
+	}
+	cur, err := open()
+	if err != nil {
+		return nil, fmt.Errorf("CompareAndSet: error from calling open: %v", err)
+	}
+
+	// t.Set(key, cur): this would cause double locking due to lock/unlock in the Set method.
+	t.add(key, cur)
+	return cur, nil
+}
+
+// StartBinds marks the beginning of a BINDS block of a cursor in the current session,
+// reporting false if the cursor is not tracked.
+func (t *Tracker) StartBinds(key int64) bool {
+	t.Lock()
+	defer t.Unlock()
+	cur, ok := t.Sessions[t.session][key]
+	if !ok {
+		t.binds = nil
+		return false
+	}
+	cur.ResetBinds()
+	t.binds = cur
+	return true
+}
+
+// BindsCursor returns the cursor whose BINDS block is being read or nil if none is.
+func (t *Tracker) BindsCursor() *Cursor {
+	t.RLock()
+	defer t.RUnlock()
+	return t.binds
+}
+
+// EndBinds marks the end of a BINDS block.
+func (t *Tracker) EndBinds() {
+	t.Lock()
+	t.binds = nil
+	t.Unlock()
+}
+
+// MarkTime records the wall clock time of a "***" timestamp marker of the trace file.
+// The marker is correlated with the tim= of the record that follows it.
+func (t *Tracker) MarkTime(wall time.Time) {
+	t.Lock()
+	t.timeMark = wall
+	t.Unlock()
+}
+
+// EventTime converts the tim= of a record (a microsecond counter) to the wall clock
+// time, based on the latest timestamp marker.  It returns the zero time if no marker
+// has been seen yet.
+func (t *Tracker) EventTime(tim int64) time.Time {
+	t.Lock()
+	defer t.Unlock()
+	if !t.timeMark.IsZero() {
+		t.timeBase, t.timBase = t.timeMark, tim
+		t.timeMark = time.Time{}
+	}
+	if t.timeBase.IsZero() {
+		return time.Time{}
+	}
+	return t.timeBase.Add(time.Duration(tim-t.timBase) * time.Microsecond)
+}
+
+// StartSQLText marks the beginning of the SQL text block of a PARSING IN CURSOR record.
+func (t *Tracker) StartSQLText(block *SQLTextBlock) {
+	if block.Cur != nil {
+		block.Cur.ResetSQLText()
+	}
+	t.Lock()
+	t.text = block
+	t.Unlock()
+}
+
+// InSQLText reports whether or not a SQL text block is being read.
+func (t *Tracker) InSQLText() bool {
+	t.RLock()
+	defer t.RUnlock()
+	return t.text != nil
+}
+
+// AddSQLText appends a line to the SQL text block being read.
+func (t *Tracker) AddSQLText(line string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.text != nil && t.text.Cur != nil {
+		t.text.Cur.AddSQLText(line)
+	}
+}
+
+// EndSQLText marks the end of the SQL text block, returning it (nil if none is being read).
+func (t *Tracker) EndSQLText() *SQLTextBlock {
+	t.Lock()
+	defer t.Unlock()
+	block := t.text
+	t.text = nil
+	return block
+}
+
+// AddToTransaction accounts a call of a monitored cursor to the transaction of its business
+// transaction in progress in the current session, starting one if there's none (or if the
+// one in progress has been idle for too long).  start is the trace time of the beginning
+// of the call.
+func (t *Tracker) AddToTransaction(cur *Cursor, start, ela int64) {
+	t.Lock()
+	defer t.Unlock()
+	txs, ok := t.txs[t.session]
+	if !ok {
+		txs = make(map[string]*Transaction)
+		t.txs[t.session] = txs
+	}
+	tx, ok := txs[cur.BusinessTxName]
+	if !ok || tx.IdleAt(start) {
+		tx = &Transaction{BusinessTxName: cur.BusinessTxName, ELAThreshold: cur.ELAThreshold, Start: start}
+		txs[cur.BusinessTxName] = tx
+	}
+	tx.add(cur.SQLID, ela)
+	tx.End = start + ela
+}
+
+// EndTransactions removes and returns the business transactions in progress in the
+// current session, the one started first first.
+func (t *Tracker) EndTransactions() []*Transaction {
+	t.Lock()
+	defer t.Unlock()
+	var ended []*Transaction
+	for _, tx := range t.txs[t.session] {
+		ended = append(ended, tx)
+	}
+	delete(t.txs, t.session)
+	sort.Slice(ended, func(i, j int) bool {
+		if ended[i].Start != ended[j].Start {
+			return ended[i].Start < ended[j].Start
+		}
+		return ended[i].BusinessTxName < ended[j].BusinessTxName
+	})
+	return ended
+}
+
+// SetDepth records the depth of a cursor of the current session as told by its PARSING
+// IN CURSOR record.  The SQL IDs of the recursive cursors are remembered (up to
+// MaxCursorsPerSession of them) for their calls to be accounted to their top-level call.
+func (t *Tracker) SetDepth(key int64, sqlID string, depth int) {
+	t.Lock()
+	defer t.Unlock()
+	r, ok := t.recursion[t.session]
+	if !ok {
+		if depth == 0 {
+			return
+		}
+		r = &recursion{cursors: make(map[int64]*recursiveCursor)}
+		t.recursion[t.session] = r
+	}
+	if depth == 0 {
+		delete(r.cursors, key)
+		return
+	}
+	if _, ok := r.cursors[key]; !ok && MaxCursorsPerSession > 0 && len(r.cursors) >= MaxCursorsPerSession {
+		lru := int64(-1)
+		for k, rc := range r.cursors {
+			if lru == -1 || rc.lastUsed < r.cursors[lru].lastUsed {
+				lru = k
+			}
+		}
+		delete(r.cursors, lru)
+	}
+	t.clock++
+	r.cursors[key] = &recursiveCursor{sqlID: sqlID, lastUsed: t.clock}
+}
+
+// AddRecursiveCall accounts a PARSE, EXEC or FETCH call of a dep=1 cursor of the current
+// session to the top-level call that follows it.  Calls of the cursors whose PARSING IN
+// CURSOR hasn't been seen are ignored.
+func (t *Tracker) AddRecursiveCall(key int64, ela int64) {
+	t.Lock()
+	defer t.Unlock()
+	r, ok := t.recursion[t.session]
+	if !ok {
+		return
+	}
+	rc, ok := r.cursors[key]
+	if !ok {
+		return
+	}
+	t.clock++
+	rc.lastUsed = t.clock
+	r.children = addStatement(r.children, Statement{SQLID: rc.sqlID, ELA: ela, Calls: 1})
+}
+
+// EndTopLevelCall returns (and forgets) the recursive calls accounted since the previous
+// top-level call of the current session, i.e. the ones run on behalf of the current one.
+func (t *Tracker) EndTopLevelCall() []Statement {
+	t.Lock()
+	defer t.Unlock()
+	r, ok := t.recursion[t.session]
+	if !ok {
+		return nil
+	}
+	children := r.children
+	r.children = nil
+	return children
+}
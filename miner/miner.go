@@ -17,9 +17,11 @@ package miner
 import (
 	"os"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
+	"github.com/borisdali/rttanalyzer/cursor"
 	"github.com/borisdali/rttanalyzer/rttanalyzer"
 	bqgen "google.golang.org/api/bigquery/v2"
 	"cloud.google.com/go/pubsub"
@@ -27,19 +29,49 @@ import (
 
 var Debug bool
 
+// Counters of all the Miners of the process.
+type Counters struct {
+	Active    int64 // Miners currently running
+	BytesRead int64 // Bytes of the trace records read
+}
+
+var counters Counters
+
+// Counts returns a snapshot of the Miner counters.
+func Counts() Counters {
+	return Counters{
+		Active:    atomic.LoadInt64(&counters.Active),
+		BytesRead: atomic.LoadInt64(&counters.BytesRead),
+	}
+}
+
 // Dumper is used to output the results.
 type Dumper interface {
 	// Dump receives a record mined from a trace file and uses it for further processing.
-	Dump(context.Context, *pubsub.Client, *bqgen.Service, string) error
+	// The cursor tracker holds the cursor state of the trace file the record comes from.
+	// An error stops the Miner, so a record that can't be delivered to an output media that
+	// is down is to be logged rather than returned.
+	Dump(context.Context, *pubsub.Client, *bqgen.Service, *cursor.Tracker, string) error
 }
 
 // Mine opens a requested trace file and starts reading/analyzing it.
 // Values should be sent to the channel when the underlying file is written to.
 // The miner exits when the channel is closed.
+// Cursors are only meaningful within a trace file, so each Miner keeps its own cursor
+// tracker that lives as long as the Miner does.
 func Mine(ctx context.Context, client *pubsub.Client, service *bqgen.Service, notify <-chan struct{}, dumper Dumper, tf *rttanalyzer.TraceFile) error {
 	if Debug { fmt.Printf("[%v] dbg> Miner started with pid %d for trace %v\n", time.Now().Format("2006-01-02 15:04:05"), os.Getpid(), tf.Name)}
 	if Debug { fmt.Printf("[%v] dbg> dumper=%v\n", time.Now().Format("2006-01-02 15:04:05"), dumper)}
 
+	atomic.AddInt64(&counters.Active, 1)
+	defer atomic.AddInt64(&counters.Active, -1)
+
+	curTracker := cursor.NewTracker(tf.Name)
+	defer func() {
+		if Debug { fmt.Printf("[%v] dbg> Miner for trace %v exits, discarding its %d cursors\n", time.Now().Format("2006-01-02 15:04:05"), tf.Name, curTracker.Len())}
+		curTracker.Release()
+	}()
+
 	var reloads int
 
 	for {
@@ -50,8 +82,9 @@ func Mine(ctx context.Context, client *pubsub.Client, service *bqgen.Service, no
 		recordsRead := len(strs)
 
 		for _, v := range strs {
+			atomic.AddInt64(&counters.BytesRead, int64(len(v)))
 			if Debug { fmt.Printf("[%v] dbg> (fileName=%v, recordsRead=%d, len=%d) %v\n", time.Now().Format("2006-01-02 15:04:05"), tf.Name, recordsRead, len(v), v)}
-			if err := dumper.Dump(ctx, client, service, v); err != nil {
+			if err := dumper.Dump(ctx, client, service, curTracker, v); err != nil {
 				return err
 			}
 		}
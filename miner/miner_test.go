@@ -24,6 +24,7 @@ import (
 	"golang.org/x/net/context"
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
 	"github.com/borisdali/rttanalyzer/rttanalyzer"
 	"github.com/kylelemons/godebug/pretty"
 	bqgen "google.golang.org/api/bigquery/v2"
@@ -179,7 +180,7 @@ type testDumper struct {
 	str string
 }
 
-func (d *testDumper) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, s string) error {
+func (d *testDumper) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, s string) error {
 	d.str += s
 	return nil
 }
@@ -0,0 +1,319 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package otlp exports metrics to an OpenTelemetry collector over OTLP/HTTP in the binary
+// protobuf encoding.  It covers the part of the OTLP metrics data model rttanalyzer needs
+// (gauges, cumulative sums and explicit bucket histograms with string attributes) and encodes
+// it by hand, per opentelemetry/proto/collector/metrics/v1/metrics_service.proto.
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var Debug bool
+
+// DefaultEndpoint is the OTLP/HTTP endpoint of a collector running locally.
+const DefaultEndpoint = "http://localhost:4318"
+
+// metricsPath is the path metrics are posted to, relative to the endpoint.
+const metricsPath = "/v1/metrics"
+
+// DefaultTimeout caps an export.
+const DefaultTimeout = 10 * time.Second
+
+// Kinds of the metrics.
+const (
+	KindGauge     = iota // Last value of a NumberPoint
+	KindSum              // Monotonic cumulative sum of a NumberPoint, e.g. a counter
+	KindHistogram        // Cumulative HistogramPoint
+)
+
+// Attribute is a string attribute of a resource or of a data point.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// NumberPoint is a data point of a gauge or of a sum.
+type NumberPoint struct {
+	Attributes []Attribute
+	Start      time.Time // Since when a sum is accumulated
+	Time       time.Time
+	Value      float64
+}
+
+// HistogramPoint is a data point of a histogram with explicit bucket bounds.
+type HistogramPoint struct {
+	Attributes []Attribute
+	Start      time.Time // Since when the histogram is accumulated
+	Time       time.Time
+	Count      uint64
+	Sum        float64
+	Bounds     []float64 // Upper bounds (inclusive) of the buckets
+	Counts     []uint64  // Counts per bucket: len(Bounds)+1, not cumulative
+}
+
+// Metric is a named metric with the data points of its kind.
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	Kind        int
+	Points      []NumberPoint    // Of a KindGauge or KindSum metric
+	Histograms  []HistogramPoint // Of a KindHistogram metric
+}
+
+// Exporter posts metrics to a collector.
+type Exporter struct {
+	Endpoint string       // e.g. DefaultEndpoint, metricsPath is appended
+	Resource []Attribute  // e.g. service.name
+	Scope    string       // Name of the instrumentation scope
+	Client   *http.Client // http.Client with DefaultTimeout if nil
+}
+
+// Export posts metrics to the collector.  Only a 2xx response is a success.
+func (e *Exporter) Export(ctx context.Context, metrics []Metric) error {
+	body := Marshal(e.Resource, e.Scope, metrics)
+	url := strings.TrimSuffix(e.Endpoint, "/") + metricsPath
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("otlp.Export: %v", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp.Export: %v", err)
+	}
+	defer resp.Body.Close()
+	out, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp.Export: %s responded with %s: %q", url, resp.Status, out)
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> otlp.Export: exported %d metrics (%d bytes) to %s\n", time.Now().Format("2006-01-02 15:04:05"), len(metrics), len(body), url)
+	}
+	return nil
+}
+
+// Field numbers of the messages of opentelemetry/proto/metrics/v1/metrics.proto and of the
+// ones it depends on.
+const (
+	requestResourceMetrics = 1 // ExportMetricsServiceRequest
+
+	resourceMetricsResource = 1 // ResourceMetrics
+	resourceMetricsScope    = 2
+
+	resourceAttributes = 1 // Resource
+
+	scopeMetricsScope   = 1 // ScopeMetrics
+	scopeMetricsMetrics = 2
+
+	scopeName = 1 // InstrumentationScope
+
+	keyValueKey   = 1 // KeyValue
+	keyValueValue = 2
+
+	anyValueString = 1 // AnyValue
+
+	metricName        = 1 // Metric
+	metricDescription = 2
+	metricUnit        = 3
+	metricGauge       = 5
+	metricSum         = 7
+	metricHistogram   = 9
+
+	dataPoints             = 1 // Gauge, Sum and Histogram
+	aggregationTemporality = 2 // Sum and Histogram
+	sumIsMonotonic         = 3
+
+	numberAttributes = 7 // NumberDataPoint
+	numberStart      = 2
+	numberTime       = 3
+	numberAsDouble   = 4
+
+	histogramAttributes = 9 // HistogramDataPoint
+	histogramStart      = 2
+	histogramTime       = 3
+	histogramCount      = 4
+	histogramSum        = 5
+	histogramCounts     = 6
+	histogramBounds     = 7
+)
+
+// temporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const temporalityCumulative = 2
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Marshal encodes metrics of a resource and of an instrumentation scope as an
+// ExportMetricsServiceRequest.
+func Marshal(resource []Attribute, scope string, metrics []Metric) []byte {
+	var res, sc, rm, req buffer
+	for _, a := range resource {
+		res.message(resourceAttributes, attribute(a))
+	}
+	sc.message(scopeMetricsScope, new(buffer).str(scopeName, scope))
+	for _, m := range metrics {
+		sc.message(scopeMetricsMetrics, metric(m))
+	}
+	rm.message(resourceMetricsResource, &res)
+	rm.message(resourceMetricsScope, &sc)
+	req.message(requestResourceMetrics, &rm)
+	return req.Bytes()
+}
+
+// metric encodes a Metric.
+func metric(m Metric) *buffer {
+	b := new(buffer).str(metricName, m.Name).str(metricDescription, m.Description).str(metricUnit, m.Unit)
+	data := new(buffer)
+	switch m.Kind {
+	case KindGauge:
+		for _, p := range m.Points {
+			data.message(dataPoints, numberPoint(p))
+		}
+		b.message(metricGauge, data)
+	case KindSum:
+		for _, p := range m.Points {
+			data.message(dataPoints, numberPoint(p))
+		}
+		data.varint(aggregationTemporality, temporalityCumulative).varint(sumIsMonotonic, 1)
+		b.message(metricSum, data)
+	case KindHistogram:
+		for _, p := range m.Histograms {
+			data.message(dataPoints, histogramPoint(p))
+		}
+		data.varint(aggregationTemporality, temporalityCumulative)
+		b.message(metricHistogram, data)
+	}
+	return b
+}
+
+// numberPoint encodes a NumberDataPoint.
+func numberPoint(p NumberPoint) *buffer {
+	b := new(buffer)
+	for _, a := range p.Attributes {
+		b.message(numberAttributes, attribute(a))
+	}
+	return b.fixed64(numberStart, unixNano(p.Start)).fixed64(numberTime, unixNano(p.Time)).fixed64(numberAsDouble, math.Float64bits(p.Value))
+}
+
+// histogramPoint encodes a HistogramDataPoint.
+func histogramPoint(p HistogramPoint) *buffer {
+	b := new(buffer)
+	for _, a := range p.Attributes {
+		b.message(histogramAttributes, attribute(a))
+	}
+	b.fixed64(histogramStart, unixNano(p.Start)).fixed64(histogramTime, unixNano(p.Time))
+	b.fixed64(histogramCount, p.Count).fixed64(histogramSum, math.Float64bits(p.Sum))
+	counts := new(buffer)
+	for _, c := range p.Counts {
+		counts.raw64(c)
+	}
+	bounds := new(buffer)
+	for _, bound := range p.Bounds {
+		bounds.raw64(math.Float64bits(bound))
+	}
+	return b.message(histogramCounts, counts).message(histogramBounds, bounds)
+}
+
+// attribute encodes a KeyValue with a string value.
+func attribute(a Attribute) *buffer {
+	return new(buffer).str(keyValueKey, a.Key).message(keyValueValue, new(buffer).str(anyValueString, a.Value))
+}
+
+// unixNano returns a time in nanoseconds since the epoch, 0 (unknown) for the zero time.
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// buffer accumulates the protobuf encoding of a message.
+type buffer struct {
+	bytes.Buffer
+}
+
+// tag encodes the key of a field.
+func (b *buffer) tag(field, wire int) {
+	b.uvarint(uint64(field<<3 | wire))
+}
+
+// uvarint encodes a base 128 varint.
+func (b *buffer) uvarint(v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+// raw64 encodes a little-endian 64 bit value with no key, e.g. an element of a packed field.
+func (b *buffer) raw64(v uint64) {
+	for i := uint(0); i < 8; i++ {
+		b.WriteByte(byte(v >> (8 * i)))
+	}
+}
+
+// varint encodes a varint field.
+func (b *buffer) varint(field int, v uint64) *buffer {
+	b.tag(field, wireVarint)
+	b.uvarint(v)
+	return b
+}
+
+// fixed64 encodes a fixed64 (or double) field.
+func (b *buffer) fixed64(field int, v uint64) *buffer {
+	b.tag(field, wireFixed64)
+	b.raw64(v)
+	return b
+}
+
+// str encodes a string field, omitting an empty one as proto3 does.
+func (b *buffer) str(field int, s string) *buffer {
+	if s == "" {
+		return b
+	}
+	b.tag(field, wireBytes)
+	b.uvarint(uint64(len(s)))
+	b.WriteString(s)
+	return b
+}
+
+// message encodes an embedded message (or a packed repeated field).
+func (b *buffer) message(field int, m *buffer) *buffer {
+	b.tag(field, wireBytes)
+	b.uvarint(uint64(m.Len()))
+	b.Write(m.Bytes())
+	return b
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Otlp_test runs unit tests on the OTLP export against an in-process receiver.
+package otlp
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	"golang.org/x/net/context"
+)
+
+// field is a decoded protobuf field: a varint, a fixed64 or the bytes of a length-delimited one.
+type field struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+// decode decodes the fields of a protobuf message.
+func decode(t *testing.T, b []byte) []field {
+	var fields []field
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("decode: bad key in %x", b)
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("decode: bad varint in %x", b)
+			}
+			b = b[n:]
+		case wireFixed64:
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || int(l) > len(b)-n {
+				t.Fatalf("decode: bad length in %x", b)
+			}
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("decode: unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// get returns the fields of a message with a number.
+func get(fields []field, num int) []field {
+	var got []field
+	for _, f := range fields {
+		if f.num == num {
+			got = append(got, f)
+		}
+	}
+	return got
+}
+
+// attributes decodes the KeyValue fields with a number.
+func attributes(t *testing.T, fields []field, num int) []Attribute {
+	var attrs []Attribute
+	for _, f := range get(fields, num) {
+		kv := decode(t, f.bytes)
+		value := decode(t, get(kv, keyValueValue)[0].bytes)
+		attrs = append(attrs, Attribute{Key: string(get(kv, keyValueKey)[0].bytes), Value: string(get(value, anyValueString)[0].bytes)})
+	}
+	return attrs
+}
+
+// fixed64s decodes a packed repeated fixed64 field.
+func fixed64s(b []byte) []uint64 {
+	var values []uint64
+	for ; len(b) >= 8; b = b[8:] {
+		values = append(values, binary.LittleEndian.Uint64(b))
+	}
+	return values
+}
+
+func TestExport(t *testing.T) {
+	start := time.Unix(1485794600, 0)
+	now := time.Unix(1485794660, 0)
+	attrs := []Attribute{{Key: "db", Value: "CLOUD2"}, {Key: "business_tx", Value: "Order Entry"}}
+	metrics := []Metric{
+		{Name: "rtta.executions", Description: "Executions.", Unit: "{execution}", Kind: KindSum, Points: []NumberPoint{{Attributes: attrs, Start: start, Time: now, Value: 3}}},
+		{Name: "rtta.elapsed.last", Unit: "s", Kind: KindGauge, Points: []NumberPoint{{Attributes: attrs, Time: now, Value: 0.07}}},
+		{Name: "rtta.elapsed", Unit: "s", Kind: KindHistogram, Histograms: []HistogramPoint{{Attributes: attrs, Start: start, Time: now, Count: 3, Sum: 0.112, Bounds: []float64{0.01, 0.1}, Counts: []uint64{1, 2, 0}}}},
+	}
+
+	var got []byte
+	var contentType, path string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType, path = r.Header.Get("Content-Type"), r.URL.Path
+		got, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer receiver.Close()
+
+	e := &Exporter{Endpoint: receiver.URL + "/", Resource: []Attribute{{Key: "service.name", Value: "rttanalyzer"}}, Scope: "rttanalyzer"}
+	if err := e.Export(context.Background(), metrics); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if contentType != "application/x-protobuf" || path != "/v1/metrics" {
+		t.Errorf("Export() posted %q to %s, want application/x-protobuf to /v1/metrics", contentType, path)
+	}
+
+	rm := decode(t, get(decode(t, got), requestResourceMetrics)[0].bytes)
+	resource := decode(t, get(rm, resourceMetricsResource)[0].bytes)
+	if got, want := attributes(t, resource, resourceAttributes), e.Resource; !reflect.DeepEqual(got, want) {
+		t.Errorf("resource attributes = %v, want %v", got, want)
+	}
+	sm := decode(t, get(rm, resourceMetricsScope)[0].bytes)
+	if got := string(get(decode(t, get(sm, scopeMetricsScope)[0].bytes), scopeName)[0].bytes); got != "rttanalyzer" {
+		t.Errorf("scope = %q, want rttanalyzer", got)
+	}
+	ms := get(sm, scopeMetricsMetrics)
+	if len(ms) != len(metrics) {
+		t.Fatalf("Export() posted %d metrics, want %d", len(ms), len(metrics))
+	}
+
+	// The sum: a cumulative and monotonic one.
+	sum := decode(t, ms[0].bytes)
+	if name, unit := string(get(sum, metricName)[0].bytes), string(get(sum, metricUnit)[0].bytes); name != "rtta.executions" || unit != "{execution}" {
+		t.Errorf("metric %q in %q, want rtta.executions in {execution}", name, unit)
+	}
+	data := decode(t, get(sum, metricSum)[0].bytes)
+	if get(data, aggregationTemporality)[0].value != temporalityCumulative || get(data, sumIsMonotonic)[0].value != 1 {
+		t.Errorf("sum %v is not a cumulative monotonic one", data)
+	}
+	point := decode(t, get(data, dataPoints)[0].bytes)
+	if got := attributes(t, point, numberAttributes); !reflect.DeepEqual(got, attrs) {
+		t.Errorf("attributes = %v, want %v", got, attrs)
+	}
+	if s, n, v := get(point, numberStart)[0].value, get(point, numberTime)[0].value, math.Float64frombits(get(point, numberAsDouble)[0].value); s != uint64(start.UnixNano()) || n != uint64(now.UnixNano()) || v != 3 {
+		t.Errorf("data point of %d at %d since %d, want 3 at %d since %d", int64(v), n, s, now.UnixNano(), start.UnixNano())
+	}
+
+	// The gauge has no start time.
+	data = decode(t, get(decode(t, ms[1].bytes), metricGauge)[0].bytes)
+	point = decode(t, get(data, dataPoints)[0].bytes)
+	if s, v := get(point, numberStart)[0].value, math.Float64frombits(get(point, numberAsDouble)[0].value); s != 0 || v != 0.07 {
+		t.Errorf("gauge of %v since %d, want 0.07 since 0", v, s)
+	}
+
+	// The histogram.
+	data = decode(t, get(decode(t, ms[2].bytes), metricHistogram)[0].bytes)
+	if get(data, aggregationTemporality)[0].value != temporalityCumulative {
+		t.Errorf("histogram %v is not a cumulative one", data)
+	}
+	point = decode(t, get(data, dataPoints)[0].bytes)
+	var bounds []float64
+	for _, b := range fixed64s(get(point, histogramBounds)[0].bytes) {
+		bounds = append(bounds, math.Float64frombits(b))
+	}
+	gotHistogram := HistogramPoint{
+		Attributes: attributes(t, point, histogramAttributes),
+		Start:      time.Unix(0, int64(get(point, histogramStart)[0].value)),
+		Time:       time.Unix(0, int64(get(point, histogramTime)[0].value)),
+		Count:      get(point, histogramCount)[0].value,
+		Sum:        math.Float64frombits(get(point, histogramSum)[0].value),
+		Bounds:     bounds,
+		Counts:     fixed64s(get(point, histogramCounts)[0].bytes),
+	}
+	if want := metrics[2].Histograms[0]; !reflect.DeepEqual(gotHistogram, want) {
+		t.Errorf("histogram: -> diff -got +want\n%s", pretty.Compare(gotHistogram, want))
+	}
+}
+
+func TestExportError(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "collector is overloaded", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	e := &Exporter{Endpoint: receiver.URL}
+	if err := e.Export(context.Background(), nil); err == nil {
+		t.Errorf("Export() to a collector responding with 503 succeeded, want an error")
+	}
+	receiver.Close()
+	if err := e.Export(context.Background(), nil); err == nil {
+		t.Errorf("Export() to a collector that is down succeeded, want an error")
+	}
+}
@@ -37,9 +37,21 @@ const (
 var Debug bool
 
 // PayloadSummary represents a Pub Sub message containing a summary of threshold violations.
+// A message with IsNewPlan set reports a SQL that started running with a plan hash value
+// never seen before; it may or may not be a violation at the same time.
+// A message with IsError set reports an ORA- error (ErrorCode) raised by a SQL during its
+// ErrorPhase call.
+// A message with IsBurnAlert set reports a BurnRate (fast or slow) alert on the error budget
+// of the SLO of a business tx: SLOTarget (e.g. 0.99) of its executions under Threshold over
+// SLOWindow.  GoodCount and BadCount are the executions over SLOWindow.
+// ParseELA, ExecELA and FetchELA (of Fetches FETCH calls) break LastELA down by phase.
 type PayloadSummary struct {
 	DB             string
 	IsViolation    bool
+	IsNewPlan      bool
+	IsError        bool
+	ErrorCode      int64
+	ErrorPhase     string
 	BusinessTxName string
 	Threshold      float64
 	SQLID          string
@@ -47,6 +59,77 @@ type PayloadSummary struct {
 	LastELA        float64
 	NumViolations  int64
 	EnqueueTime    time.Time
+	EventTime      time.Time // When the violation happened according to the trace file
+	ParseELA       float64
+	ExecELA        float64
+	FetchELA       float64
+	Fetches        int64
+	TopWaits       []WaitEvent
+	Binds          []Bind
+	PlanHash       string
+	RowSource      []RowSource
+	Statements     []Statement
+	SQLText        string
+	BufferGets     int64
+	PhysReads      int64
+	Rows           int64
+	Misses         int64
+	Breached       []string // Dimensions over their limits: ela, parse, exec, fetch, gets, cr, cu, p, r or mis
+	Owner          string
+	Severity       string
+	IsBurnAlert    bool
+	BurnRate       string
+	LongBurnRate   float64 // How many times faster than the SLO allows the budget is burnt over the long window
+	ShortBurnRate  float64 // Same over the short window
+	SLOTarget      float64
+	SLOWindow      time.Duration
+	GoodCount      int64
+	BadCount       int64
+	BudgetLeft     float64 // Share of the error budget left over SLOWindow, negative once overspent
+	Executions     int64   // Executions of the business tx so far, the good ones as well as the violations
+	AvgELA         float64
+	ViolationRatio float64 // Share of the Executions that were violations
+
+	// Learned baseline of the SQL ID the violation was judged against (Threshold is BaselinePTarget
+	// times BaselineFactor), all zero for a static threshold.
+	BaselineP50        float64
+	BaselinePTarget    float64 // Elapsed time at BaselinePercentile, e.g. p99
+	BaselinePercentile float64
+	BaselineFactor     float64
+}
+
+// Statement is a SQL statement of a business transaction judged as a whole (up to its commit).
+// ELA is in the same units as LastELA and Share is its percentage of LastELA.
+type Statement struct {
+	SQLID string
+	ELA   float64
+	Calls int64
+	Share float64
+}
+
+// RowSource is a row source operation of the execution plan of a violating SQL.
+// Time is in the same units as LastELA.
+type RowSource struct {
+	ID        int
+	ParentID  int
+	Operation string
+	Rows      int64
+	CR        int64
+	Time      float64
+}
+
+// WaitEvent summarizes the time a violating execution spent waiting on a wait event.
+// TotalELA is in the same units as LastELA.
+type WaitEvent struct {
+	Name     string
+	TotalELA float64
+	Count    int64
+}
+
+// Bind is a bind variable value of a violating execution. Position starts at 1.
+type Bind struct {
+	Position int
+	Value    string
 }
 
 // Enqueue sends a starter message, checking the existence of a topic and a subscription
@@ -154,6 +237,7 @@ func insertBQ(service *bqgen.Service, projectName string, payload PayloadSummary
 		"lastela":        bqgen.JsonValue(payload.LastELA),
 		"worstela":       bqgen.JsonValue(payload.WorstELA),
 		"violations":     bqgen.JsonValue(payload.NumViolations),
+		"event_at":       bqgen.JsonValue(payload.EventTime),
 		"enqueued_at":    bqgen.JsonValue(payload.EnqueueTime),
 		"dequeued_at":    bqgen.JsonValue(time.Now()),
 	}
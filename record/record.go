@@ -0,0 +1,147 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package record tokenizes Oracle SQL trace (10046) records into named fields.
+//
+// The records of interest all come in the form of a kind, an optional cursor# and
+// a list of name=value fields, e.g.
+//
+//	PARSING IN CURSOR #12 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'
+//	EXEC #12:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=2949544139,tim=1409063809188197
+//	WAIT #12: nam='db file sequential read' ela= 1234 file#=4 block#=123 blocks=1 obj#=75 tim=1409063809287250
+//	ERROR #12:err=1555 tim=1409063809287300
+//
+// The fields are separated by commas and/or spaces, their order and number vary across
+// Oracle versions, names may contain spaces (e.g. "driver id" of the SQL*Net waits) and
+// quoted values may contain spaces, commas and equal signs.
+package record
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NoCursor is the cursor# of the records that don't belong to a cursor, e.g. XCTEND.
+const NoCursor = -1
+
+// Record is a tokenized trace record.
+type Record struct {
+	Kind   string            // PARSING IN CURSOR, PARSE, EXEC, FETCH, CLOSE, WAIT, ERROR, STAT, XCTEND, etc.
+	Cursor int64             // Cursor# or NoCursor
+	Fields map[string]string // Values by name, quotes removed
+}
+
+// Parse tokenizes a trace record.
+func Parse(rec string) (*Record, error) {
+	line := strings.TrimRight(rec, "\r\n")
+	r := &Record{Cursor: NoCursor}
+
+	var rest string
+	if hash := strings.Index(line, " #"); hash > 0 {
+		r.Kind = line[:hash]
+		start := hash + len(" #")
+		end := start
+		for end < len(line) && line[end] >= '0' && line[end] <= '9' {
+			end++
+		}
+		cursorInt, err := strconv.ParseInt(line[start:end], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("record.Parse: cursor# doesn't appear to be a number in %q: %v", line, err)
+		}
+		r.Cursor = cursorInt
+		rest = strings.TrimPrefix(line[end:], ":")
+	} else if sp := strings.Index(line, " "); sp > 0 {
+		r.Kind = line[:sp]
+		rest = line[sp+1:]
+	} else {
+		return nil, fmt.Errorf("record.Parse: not a trace record: %q", line)
+	}
+
+	fields, err := tokenize(rest)
+	if err != nil {
+		return nil, fmt.Errorf("record.Parse: %v (rec=%q)", err, line)
+	}
+	r.Fields = fields
+	return r, nil
+}
+
+// tokenize splits a list of name=value fields separated by commas and/or spaces.
+func tokenize(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return fields, nil
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("expected name=value, got %q", s[i:])
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+
+		// Some values are preceded by a space, e.g. "ela= 1234" of WAIT.
+		start := i
+		for start < len(s) && s[start] == ' ' {
+			start++
+		}
+		if start < len(s) && s[start] == '\'' {
+			end := strings.IndexByte(s[start+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in the value of %s", name)
+			}
+			fields[name] = s[start+1 : start+1+end]
+			i = start + end + 2
+			continue
+		}
+		end := start
+		for end < len(s) && s[end] != ' ' && s[end] != ',' {
+			end++
+		}
+		// An empty value followed by the next field, e.g. "a= b=1".
+		if start > i && strings.IndexByte(s[start:end], '=') >= 0 {
+			fields[name] = ""
+			continue
+		}
+		fields[name] = s[start:end]
+		i = end
+	}
+}
+
+// Has reports whether or not the record has a field.
+func (r *Record) Has(name string) bool {
+	_, ok := r.Fields[name]
+	return ok
+}
+
+// Str returns the value of a field or an empty string if the record doesn't have it.
+func (r *Record) Str(name string) string {
+	return r.Fields[name]
+}
+
+// Int returns the value of a numeric field.
+func (r *Record) Int(name string) (int64, error) {
+	v, ok := r.Fields[name]
+	if !ok {
+		return 0, fmt.Errorf("%s record has no %s", r.Kind, name)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s of %s record doesn't appear to be a number: %v", name, r.Kind, err)
+	}
+	return n, nil
+}
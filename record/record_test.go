@@ -0,0 +1,150 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Record_test runs golden tests of the tokenizer on records of several Oracle versions.
+package record
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParse(t *testing.T) {
+	var testCases = []struct {
+		version string
+		rec     string
+		want    *Record
+	}{
+		// 10g: no sqlid and no plh.
+		{version: "10g",
+			rec: "PARSING IN CURSOR #5 len=52 dep=0 uid=57 oct=3 lid=57 tim=1183455478473366 hv=3481474429 ad='2f3f3a4c'\n",
+			want: &Record{Kind: "PARSING IN CURSOR", Cursor: 5, Fields: map[string]string{
+				"len": "52", "dep": "0", "uid": "57", "oct": "3", "lid": "57", "tim": "1183455478473366", "hv": "3481474429", "ad": "2f3f3a4c"}}},
+		{version: "10g",
+			rec: "EXEC #5:c=0,e=94,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,tim=1183455478473599\n",
+			want: &Record{Kind: "EXEC", Cursor: 5, Fields: map[string]string{
+				"c": "0", "e": "94", "p": "0", "cr": "0", "cu": "0", "mis": "0", "r": "0", "dep": "0", "og": "1", "tim": "1183455478473599"}}},
+		{version: "10g",
+			rec: "WAIT #5: nam='SQL*Net message to client' ela= 3 driver id=1650815232 #bytes=1 p3=0 obj#=-1 tim=1183455478473645\n",
+			want: &Record{Kind: "WAIT", Cursor: 5, Fields: map[string]string{
+				"nam": "SQL*Net message to client", "ela": "3", "driver id": "1650815232", "#bytes": "1", "p3": "0", "obj#": "-1", "tim": "1183455478473645"}}},
+		{version: "10g",
+			rec:  "ERROR #5:err=1555 tim=1183455478474900\n",
+			want: &Record{Kind: "ERROR", Cursor: 5, Fields: map[string]string{"err": "1555", "tim": "1183455478474900"}}},
+
+		// 11g: sqlid and plh.
+		{version: "11g",
+			rec: "PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+			want: &Record{Kind: "PARSING IN CURSOR", Cursor: 3, Fields: map[string]string{
+				"len": "80", "dep": "0", "uid": "0", "oct": "3", "lid": "0", "tim": "1409063809187197", "hv": "3670034437", "ad": "7cbeae9d8", "sqlid": "acc988uzvjmmt"}}},
+		{version: "11g",
+			rec: "EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=2949544139,tim=1409063809188197\n",
+			want: &Record{Kind: "EXEC", Cursor: 3, Fields: map[string]string{
+				"c": "0", "e": "1000", "p": "0", "cr": "0", "cu": "0", "mis": "0", "r": "0", "dep": "0", "og": "1", "plh": "2949544139", "tim": "1409063809188197"}}},
+		{version: "11g",
+			rec: "WAIT #3: nam='db file sequential read' ela= 1234 file#=4 block#=123 blocks=1 obj#=75 tim=1409063809287250\n",
+			want: &Record{Kind: "WAIT", Cursor: 3, Fields: map[string]string{
+				"nam": "db file sequential read", "ela": "1234", "file#": "4", "block#": "123", "blocks": "1", "obj#": "75", "tim": "1409063809287250"}}},
+		{version: "11g",
+			rec:  "CLOSE #3:c=0,e=5,dep=0,type=1,tim=1409063809287300\n",
+			want: &Record{Kind: "CLOSE", Cursor: 3, Fields: map[string]string{"c": "0", "e": "5", "dep": "0", "type": "1", "tim": "1409063809287300"}}},
+		{version: "11g",
+			rec: "STAT #3 id=1 cnt=14 pid=0 pos=1 obj=75 op='TABLE ACCESS FULL EMP (cr=7 pr=0 pw=0 time=123 us cost=3 size=532 card=14)'\n",
+			want: &Record{Kind: "STAT", Cursor: 3, Fields: map[string]string{
+				"id": "1", "cnt": "14", "pid": "0", "pos": "1", "obj": "75", "op": "TABLE ACCESS FULL EMP (cr=7 pr=0 pw=0 time=123 us cost=3 size=532 card=14)"}}},
+
+		// 12c: 64 bit cursor numbers.
+		{version: "12c",
+			rec: "PARSING IN CURSOR #140234567890123 len=24 dep=0 uid=111 oct=3 lid=111 tim=5123456789 hv=1388734953 ad='6a1b6b28' sqlid='7h35uxf5uhmm1'\n",
+			want: &Record{Kind: "PARSING IN CURSOR", Cursor: 140234567890123, Fields: map[string]string{
+				"len": "24", "dep": "0", "uid": "111", "oct": "3", "lid": "111", "tim": "5123456789", "hv": "1388734953", "ad": "6a1b6b28", "sqlid": "7h35uxf5uhmm1"}}},
+		{version: "12c",
+			rec: "FETCH #140234567890123:c=52,e=52,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=1388734953,tim=5123456812\n",
+			want: &Record{Kind: "FETCH", Cursor: 140234567890123, Fields: map[string]string{
+				"c": "52", "e": "52", "p": "0", "cr": "0", "cu": "0", "mis": "0", "r": "1", "dep": "0", "og": "1", "plh": "1388734953", "tim": "5123456812"}}},
+		{version: "12c",
+			rec: "PARSE ERROR #140234567890123:len=25 dep=0 uid=111 oct=3 lid=111 tim=5123456900 err=942\n",
+			want: &Record{Kind: "PARSE ERROR", Cursor: 140234567890123, Fields: map[string]string{
+				"len": "25", "dep": "0", "uid": "111", "oct": "3", "lid": "111", "tim": "5123456900", "err": "942"}}},
+
+		// 19c.
+		{version: "19c",
+			rec: "WAIT #139872716370024: nam='SQL*Net message from client' ela= 2307 driver id=1650815232 #bytes=1 p3=0 obj#=-1 tim=4417013912\n",
+			want: &Record{Kind: "WAIT", Cursor: 139872716370024, Fields: map[string]string{
+				"nam": "SQL*Net message from client", "ela": "2307", "driver id": "1650815232", "#bytes": "1", "p3": "0", "obj#": "-1", "tim": "4417013912"}}},
+		{version: "19c",
+			rec:  "CLOSE #139872716370024:c=4,e=4,dep=0,type=0,tim=4417025123\n",
+			want: &Record{Kind: "CLOSE", Cursor: 139872716370024, Fields: map[string]string{"c": "4", "e": "4", "dep": "0", "type": "0", "tim": "4417025123"}}},
+		{version: "19c",
+			rec:  "ERROR #139872716370024:err=942 tim=4417011511\n",
+			want: &Record{Kind: "ERROR", Cursor: 139872716370024, Fields: map[string]string{"err": "942", "tim": "4417011511"}}},
+		{version: "19c",
+			rec:  "XCTEND rlbk=0, rd_only=1, tim=4417025200\n",
+			want: &Record{Kind: "XCTEND", Cursor: NoCursor, Fields: map[string]string{"rlbk": "0", "rd_only": "1", "tim": "4417025200"}}},
+
+		// Whatever the version: fields out of order, unknown fields, spaces in quoted values.
+		{version: "any",
+			rec:  "EXEC #3:tim=1409063809188197,e=1000,con_id=3,c=0\n",
+			want: &Record{Kind: "EXEC", Cursor: 3, Fields: map[string]string{"tim": "1409063809188197", "e": "1000", "con_id": "3", "c": "0"}}},
+		{version: "any",
+			rec:  "PARSING IN CURSOR #3 sqlid='acc988uzvjmmt' ad='7cbe, ae9d8' len=80 dep=1\n",
+			want: &Record{Kind: "PARSING IN CURSOR", Cursor: 3, Fields: map[string]string{"sqlid": "acc988uzvjmmt", "ad": "7cbe, ae9d8", "len": "80", "dep": "1"}}},
+		{version: "any",
+			rec:  "WAIT #3: nam='enq: TX - row lock contention' ela= 2998711 name|mode=1415053318 usn<<16 | slot=65547 sequence=4 obj#=75 tim=1409063812287250\n",
+			want: &Record{Kind: "WAIT", Cursor: 3, Fields: map[string]string{"nam": "enq: TX - row lock contention", "ela": "2998711", "name|mode": "1415053318", "usn<<16 | slot": "65547", "sequence": "4", "obj#": "75", "tim": "1409063812287250"}}},
+
+		// Not records.
+		{version: "any", rec: "EXEC #abc:c=0,e=1\n"},
+		{version: "any", rec: "WAIT #3: nam='db file sequential read ela= 1\n"},
+		{version: "any", rec: "END OF STMT\n"},
+		{version: "any", rec: "=====================\n"},
+	}
+
+	for _, ent := range testCases {
+		got, err := Parse(ent.rec)
+		if ent.want == nil {
+			if err == nil {
+				t.Errorf("%s: Parse(%q) = %+v, want an error", ent.version, ent.rec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Parse(%q) failed: %v", ent.version, ent.rec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("%s: Parse(%q): -> diff -got +want\n%s", ent.version, ent.rec, pretty.Compare(got, ent.want))
+		}
+	}
+}
+
+func TestInt(t *testing.T) {
+	r, err := Parse("EXEC #3:c=0,e=1000,p=0,plh=,tim=1409063809188197\n")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if got, err := r.Int("e"); err != nil || got != 1000 {
+		t.Errorf("Int(e) = %d, %v, want 1000", got, err)
+	}
+	if _, err := r.Int("plh"); err == nil {
+		t.Errorf("Int(plh) of an empty value, want an error")
+	}
+	if _, err := r.Int("cr"); err == nil {
+		t.Errorf("Int(cr) of a missing field, want an error")
+	}
+	if !r.Has("plh") || r.Has("cr") || r.Str("tim") != "1409063809188197" {
+		t.Errorf("Has()/Str() of %+v unexpected", r)
+	}
+}
@@ -0,0 +1,444 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/borisdali/rttanalyzer/baseline"
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/otlp"
+	"github.com/borisdali/rttanalyzer/rttanalyzer"
+	"github.com/borisdali/rttanalyzer/sink"
+)
+
+// configGroup is a group of the config parameters, e.g. the ones of an output media.
+// parse sets a parameter of the group, reporting false if it's not one of them.
+type configGroup interface {
+	parse(key, value string) bool
+}
+
+// traceConfig holds the parameters on how the trace records are parsed.
+type traceConfig struct {
+	binds      string
+	evaluate   string
+	maxCursors string
+	sqlText    string
+	recursive  string
+
+	maxCursorsPerSession int // Set by validate
+	sqlTextLen           int // Set by validate
+}
+
+func (c *traceConfig) parse(key, value string) bool {
+	switch key {
+	case "binds":
+		c.binds = value
+	case "evaluate":
+		c.evaluate = value
+	case "maxcursors":
+		c.maxCursors = value
+	case "sqltext":
+		c.sqlText = value
+	case "recursive":
+		c.recursive = value
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *traceConfig) validate() error {
+	switch c.binds {
+	case "":
+		c.binds = sink.BindsCapture
+	case sink.BindsCapture, sink.BindsMask, sink.BindsOff:
+	default:
+		return fmt.Errorf("binds parameter can be one of %s, %s or %s. Got %s instead", sink.BindsCapture, sink.BindsMask, sink.BindsOff, c.binds)
+	}
+	switch c.evaluate {
+	case "":
+		c.evaluate = sink.EvaluateExecution
+	case sink.EvaluateExecution, sink.EvaluateCall, sink.EvaluateTransaction:
+	default:
+		return fmt.Errorf("evaluate parameter can be one of %s, %s or %s. Got %s instead", sink.EvaluateExecution, sink.EvaluateCall, sink.EvaluateTransaction, c.evaluate)
+	}
+	switch c.recursive {
+	case "":
+		c.recursive = sink.RecursiveSeparate
+	case sink.RecursiveSeparate, sink.RecursiveRollup:
+	default:
+		return fmt.Errorf("recursive parameter can be either %s or %s. Got %s instead", sink.RecursiveSeparate, sink.RecursiveRollup, c.recursive)
+	}
+	c.maxCursorsPerSession = cursor.MaxCursorsPerSession
+	if c.maxCursors != "" {
+		n, err := strconv.Atoi(c.maxCursors)
+		if err != nil || n < 0 {
+			return fmt.Errorf("maxcursors parameter is expected to be a non-negative number. Got %s instead", c.maxCursors)
+		}
+		c.maxCursorsPerSession = n
+	}
+	if c.sqlText != "" {
+		n, err := strconv.Atoi(c.sqlText)
+		if err != nil || n < 0 {
+			return fmt.Errorf("sqltext parameter is expected to be a non-negative number. Got %s instead", c.sqlText)
+		}
+		c.sqlTextLen = n
+	}
+	return nil
+}
+
+// baselineConfig holds the parameters of the baselines of the elapsed times.
+type baselineConfig struct {
+	mode       string
+	file       string
+	warmUp     string
+	factor     string
+	percentile string
+
+	config baseline.Config // Set by validate, if the baselines are on
+}
+
+func (c *baselineConfig) parse(key, value string) bool {
+	switch key {
+	case "baseline":
+		c.mode = value
+	case "baselinefile":
+		c.file = value
+	case "baselinewarmup":
+		c.warmUp = value
+	case "baselinefactor":
+		c.factor = value
+	case "baselinepercentile":
+		c.percentile = value
+	default:
+		return false
+	}
+	return true
+}
+
+// on tells whether the baselines are to be used.
+func (c *baselineConfig) on() bool {
+	return c.mode == "on"
+}
+
+func (c *baselineConfig) validate() error {
+	switch c.mode {
+	case "", "off":
+		return nil
+	case "on":
+	default:
+		return fmt.Errorf("baseline parameter can be either on or off. Got %s instead", c.mode)
+	}
+	c.config = baseline.Config{
+		WarmUp:        baseline.DefaultWarmUp,
+		MinExecutions: baseline.DefaultMinExecutions,
+		Factor:        baseline.DefaultFactor,
+		Percentile:    baseline.DefaultPercentile,
+	}
+	var err error
+	if c.warmUp != "" {
+		if c.config.WarmUp, err = time.ParseDuration(c.warmUp); err != nil || c.config.WarmUp < 0 {
+			return fmt.Errorf("baselinewarmup parameter is expected to be a duration, e.g. 24h. Got %s instead", c.warmUp)
+		}
+	}
+	if c.factor != "" {
+		if c.config.Factor, err = strconv.ParseFloat(c.factor, 64); err != nil || c.config.Factor <= 0 {
+			return fmt.Errorf("baselinefactor parameter is expected to be a positive number. Got %s instead", c.factor)
+		}
+	}
+	if c.percentile != "" {
+		if c.config.Percentile, err = strconv.ParseFloat(c.percentile, 64); err != nil || c.config.Percentile <= 0 || c.config.Percentile > 100 {
+			return fmt.Errorf("baselinepercentile parameter is expected to be a percentile between 0 and 100, e.g. 99. Got %s instead", c.percentile)
+		}
+	}
+	if c.file == "" {
+		c.file = "rtta.baseline"
+	}
+	if !filepath.IsAbs(c.file) {
+		c.file = filepath.Join(rttanalyzer.Dir(), c.file)
+	}
+	return nil
+}
+
+// prometheusConfig holds the parameters of the Prometheus output media.
+type prometheusConfig struct {
+	addr string
+}
+
+func (c *prometheusConfig) parse(key, value string) bool {
+	if key != "metricsaddr" {
+		return false
+	}
+	c.addr = value
+	return true
+}
+
+func (c *prometheusConfig) validate() error {
+	if c.addr == "" {
+		c.addr = sink.DefaultMetricsAddr
+	}
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		return fmt.Errorf("metricsaddr parameter is expected to be a host:port address, e.g. :9161. Got %s instead", c.addr)
+	}
+	return nil
+}
+
+// otlpConfig holds the parameters of the OTLP output media.
+type otlpConfig struct {
+	endpoint string
+	interval string
+
+	intervalDur time.Duration // Set by validate
+}
+
+func (c *otlpConfig) parse(key, value string) bool {
+	switch key {
+	case "otlpendpoint":
+		c.endpoint = value
+	case "otlpinterval":
+		c.interval = value
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *otlpConfig) validate() error {
+	if c.endpoint == "" {
+		c.endpoint = otlp.DefaultEndpoint
+	}
+	if u, err := url.Parse(c.endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("otlpendpoint parameter is expected to be an http(s) URL, e.g. %s. Got %s instead", otlp.DefaultEndpoint, c.endpoint)
+	}
+	c.intervalDur = sink.DefaultOTLPInterval
+	if c.interval != "" {
+		var err error
+		if c.intervalDur, err = time.ParseDuration(c.interval); err != nil || c.intervalDur <= 0 {
+			return fmt.Errorf("otlpinterval parameter is expected to be a positive duration, e.g. 60s. Got %s instead", c.interval)
+		}
+	}
+	return nil
+}
+
+// webhookConfig holds the parameters of the webhook output media.
+type webhookConfig struct {
+	url        string
+	preset     string
+	template   string
+	routingKey string
+	timeout    string
+	retries    string
+
+	options sink.WebhookOptions // Set by validate
+}
+
+func (c *webhookConfig) parse(key, value string) bool {
+	switch key {
+	case "webhookurl":
+		c.url = value
+	case "webhookpreset":
+		c.preset = value
+	case "webhooktemplate":
+		c.template = value
+	case "webhookroutingkey":
+		c.routingKey = value
+	case "webhooktimeout":
+		c.timeout = value
+	case "webhookretries":
+		c.retries = value
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *webhookConfig) validate() error {
+	c.options = sink.WebhookOptions{Preset: c.preset, RoutingKey: c.routingKey, Timeout: sink.DefaultWebhookTimeout, Retries: sink.DefaultWebhookRetries}
+	for _, u := range strings.Split(c.url, ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("webhookurl parameter is expected to be a comma separated list of http(s) URLs. Got %s instead", u)
+		}
+		c.options.URLs = append(c.options.URLs, u)
+	}
+	if len(c.options.URLs) == 0 {
+		return fmt.Errorf("a webhook is requested (via outputtype config parameter), yet webhookurl parameter is not set")
+	}
+	if c.template != "" {
+		fileName := c.template
+		if !filepath.IsAbs(fileName) {
+			fileName = filepath.Join(rttanalyzer.Dir(), fileName)
+		}
+		out, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return fmt.Errorf("error reading the webhook template: %v", err)
+		}
+		c.options.Template = string(out)
+	}
+	var err error
+	if c.timeout != "" {
+		if c.options.Timeout, err = time.ParseDuration(c.timeout); err != nil || c.options.Timeout <= 0 {
+			return fmt.Errorf("webhooktimeout parameter is expected to be a positive duration, e.g. 10s. Got %s instead", c.timeout)
+		}
+	}
+	if c.retries != "" {
+		if c.options.Retries, err = strconv.Atoi(c.retries); err != nil || c.options.Retries < 0 {
+			return fmt.Errorf("webhookretries parameter is expected to be a non-negative number. Got %s instead", c.retries)
+		}
+	}
+	return nil
+}
+
+// syslogConfig holds the parameters of the syslog output media.
+type syslogConfig struct {
+	options sink.SyslogOptions
+}
+
+func (c *syslogConfig) parse(key, value string) bool {
+	switch key {
+	case "syslognetwork":
+		c.options.Network = value
+	case "syslogaddr":
+		c.options.Addr = value
+	case "syslogfacility":
+		c.options.Facility = value
+	case "syslogseverity":
+		c.options.Severities = value
+	default:
+		return false
+	}
+	return true
+}
+
+// emailConfig holds the parameters of the email output media.
+type emailConfig struct {
+	relay    string
+	tls      string
+	user     string
+	password string
+	from     string
+	to       string
+	route    string
+	digest   string
+
+	options sink.EmailOptions // Set by validate
+}
+
+func (c *emailConfig) parse(key, value string) bool {
+	switch key {
+	case "emailrelay":
+		c.relay = value
+	case "emailtls":
+		c.tls = value
+	case "emailuser":
+		c.user = value
+	case "emailpassword":
+		c.password = value
+	case "emailfrom":
+		c.from = value
+	case "emailto":
+		c.to = value
+	case "emailroute":
+		c.route = value
+	case "emaildigest":
+		c.digest = value
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *emailConfig) validate() error {
+	c.options = sink.EmailOptions{Relay: c.relay, TLS: c.tls, Username: c.user, Password: c.password, From: c.from, Digest: sink.DefaultEmailDigest}
+	if c.relay == "" || c.from == "" || c.to == "" {
+		return fmt.Errorf("an email is requested (via outputtype config parameter), yet emailrelay, emailfrom or emailto parameter is not set")
+	}
+	for _, addr := range strings.Split(c.to, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			c.options.To = append(c.options.To, addr)
+		}
+	}
+	if c.route != "" {
+		c.options.Routes = make(map[string][]string)
+	}
+	for _, route := range strings.Split(c.route, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		parts := strings.SplitN(route, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || len(strings.Fields(parts[1])) == 0 {
+			return fmt.Errorf("emailroute parameter is expected to be a comma separated list of owner:recipients pairs, e.g. oe-team:oe@example.com dba@example.com. Got %s instead", route)
+		}
+		c.options.Routes[strings.TrimSpace(parts[0])] = strings.Fields(parts[1])
+	}
+	if c.digest != "" {
+		var err error
+		if c.options.Digest, err = time.ParseDuration(c.digest); err != nil || c.options.Digest <= 0 {
+			return fmt.Errorf("emaildigest parameter is expected to be a positive duration, e.g. 10m. Got %s instead", c.digest)
+		}
+	}
+	return nil
+}
+
+// execConfig holds the parameters of the exec output media.
+type execConfig struct {
+	command     string
+	timeout     string
+	concurrency string
+
+	options sink.ExecOptions // Set by validate
+}
+
+func (c *execConfig) parse(key, value string) bool {
+	switch key {
+	case "execcommand":
+		c.command = value
+	case "exectimeout":
+		c.timeout = value
+	case "execconcurrency":
+		c.concurrency = value
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *execConfig) validate() error {
+	c.options = sink.ExecOptions{Command: strings.Fields(c.command), Timeout: sink.DefaultExecTimeout, Concurrency: sink.DefaultExecConcurrency}
+	if len(c.options.Command) == 0 {
+		return fmt.Errorf("a command is requested (via outputtype config parameter), yet execcommand parameter is not set")
+	}
+	var err error
+	if c.timeout != "" {
+		if c.options.Timeout, err = time.ParseDuration(c.timeout); err != nil || c.options.Timeout <= 0 {
+			return fmt.Errorf("exectimeout parameter is expected to be a positive duration, e.g. 30s. Got %s instead", c.timeout)
+		}
+	}
+	if c.concurrency != "" {
+		if c.options.Concurrency, err = strconv.Atoi(c.concurrency); err != nil || c.options.Concurrency <= 0 {
+			return fmt.Errorf("execconcurrency parameter is expected to be a positive number. Got %s instead", c.concurrency)
+		}
+	}
+	return nil
+}
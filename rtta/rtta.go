@@ -27,8 +27,11 @@ import (
 	"log"
 	"time"
 
+	"github.com/borisdali/rttanalyzer/baseline"
+	"github.com/borisdali/rttanalyzer/cursor"
 	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
 	"github.com/borisdali/rttanalyzer/rttanalyzer"
+	"github.com/borisdali/rttanalyzer/sink"
 	"github.com/borisdali/rttanalyzer/sqlinput"
 	"github.com/borisdali/rttanalyzer/watchdog"
 
@@ -65,6 +68,7 @@ var serviceAction = flag.String("service", "", "Service action: run, start, stop
 var serviceG *bqgen.Service
 var projectNameG string
 var configG *config
+var baselineG *baseline.Baseline
 
 type config struct {
 	dbName      string
@@ -74,6 +78,14 @@ type config struct {
 	outputType  string
 	appCred     string
 	projectName string
+	trace       traceConfig
+	baseline    baselineConfig
+	prometheus  prometheusConfig
+	otlp        otlpConfig
+	webhook     webhookConfig
+	syslog      syslogConfig
+	email       emailConfig
+	exec        execConfig
 }
 
 // loadConfig reads, parses and loads the input parameters.
@@ -84,13 +96,15 @@ func loadConfig(fileName string) (*config, error) {
 	}
 	defer fh.Close()
 
+	// A value may have '=' of its own, e.g. a URL with a query string: only the first one separates it.
 	r := csv.NewReader(fh)
-	//r.FieldsPerRecord = -1
+	r.FieldsPerRecord = -1
 	r.TrimLeadingSpace = true
 	r.Comma = '='
 	r.Comment = '#'
 
-	var dbName, dirName, mode, sqlInput, outputType, appCred, projectName string
+	c := &config{}
+	groups := []configGroup{&c.trace, &c.baseline, &c.prometheus, &c.otlp, &c.webhook, &c.syslog, &c.email, &c.exec}
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -99,36 +113,92 @@ func loadConfig(fileName string) (*config, error) {
 		if err != nil {
 			return nil, err
 		}
-		if *debug { fmt.Printf("[%v] dbg> record[0]=%s, record[1]=%s\n", time.Now().Format("2006-01-02 15:04:05"), strings.TrimSpace(record[0]), strings.TrimSpace(record[1])) }
+		if len(record) < 2 {
+			return nil, fmt.Errorf("expected a config parameter = value, got %q", strings.Join(record, "="))
+		}
+		key, value := strings.TrimSpace(record[0]), strings.TrimSpace(strings.Join(record[1:], "="))
+		if *debug { fmt.Printf("[%v] dbg> record[0]=%s, record[1]=%s\n", time.Now().Format("2006-01-02 15:04:05"), key, value) }
 
-		switch strings.TrimSpace(record[0]) {
+		switch key {
 		case "dbname":
-			dbName = strings.TrimSpace(record[1])
+			c.dbName = value
 		case "dirname":
-			dirName = strings.TrimSpace(record[1])
+			c.dirName = value
 		case "mode":
-			mode = strings.TrimSpace(record[1])
+			c.mode = value
 		case "sqlinput":
-			sqlInput = strings.TrimSpace(record[1])
+			c.sqlInput = value
 		case "outputtype":
-			outputType = strings.TrimSpace(record[1])
+			c.outputType = value
 		case "appcredentials":
-			appCred = strings.TrimSpace(record[1])
+			c.appCred = value
 		case "projectname":
-			projectName = strings.TrimSpace(record[1])
+			c.projectName = value
 		default:
-			return nil, fmt.Errorf("unknown config parameter: %v", strings.TrimSpace(record[0]))
+			parsed := false
+			for _, g := range groups {
+				if parsed = g.parse(key, value); parsed {
+					break
+				}
+			}
+			if !parsed {
+				return nil, fmt.Errorf("unknown config parameter: %v", key)
+			}
 		}
 	}
-	return &config{
-		dbName:      dbName,
-		dirName:     dirName,
-		mode:        mode,
-		sqlInput:    sqlInput,
-		outputType:  outputType,
-		appCred:     appCred,
-		projectName: projectName,
-	}, nil
+	return c, nil
+}
+
+// validate checks the input parameters, setting the defaults of the ones not set.  The
+// parameters of an output media are only checked if it's among the ones of outputtype.
+func (c *config) validate() error {
+	if c.dbName == "" {
+		return fmt.Errorf("dbname parameter is not provided")
+	}
+	if c.dirName == "" {
+		return fmt.Errorf("dirname parameter is not provided")
+	}
+	if c.sqlInput == "" {
+		return fmt.Errorf("sqlinput parameter is not provided")
+	}
+	if err := c.trace.validate(); err != nil {
+		return err
+	}
+	if err := c.baseline.validate(); err != nil {
+		return err
+	}
+	if err := c.prometheus.validate(); err != nil {
+		return err
+	}
+	if err := c.otlp.validate(); err != nil {
+		return err
+	}
+	if hasOutput(c.outputType, "webhook") {
+		if err := c.webhook.validate(); err != nil {
+			return err
+		}
+	}
+	if hasOutput(c.outputType, "email") {
+		if err := c.email.validate(); err != nil {
+			return err
+		}
+	}
+	if hasOutput(c.outputType, "exec") {
+		if err := c.exec.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasOutput tells whether an output media is among the comma separated ones of outputtype.
+func hasOutput(outputType, media string) bool {
+	for _, t := range strings.Split(outputType, ",") {
+		if strings.TrimSpace(t) == media {
+			return true
+		}
+	}
+	return false
 }
 
 func getService(ctx context.Context) (*bqgen.Service, error) {
@@ -152,7 +222,20 @@ func dequeueWrap(ctx context.Context, client *pubsub.Client) {
 }
 
 func watchdogWrap(ctx context.Context, client *pubsub.Client) {
-	if err := watchdog.Run(ctx, client, serviceG, configG.dbName, configG.dirName, configG.sqlInput, configG.mode, configG.outputType, configG.projectName); err != nil {
+	if err := watchdog.Run(ctx, client, serviceG, configG.dbName, configG.dirName, configG.sqlInput, configG.mode, configG.outputType, configG.projectName, sink.Options{
+		Binds:        configG.trace.binds,
+		Evaluate:     configG.trace.evaluate,
+		SQLText:      configG.trace.sqlTextLen,
+		Recursive:    configG.trace.recursive,
+		Baseline:     baselineG,
+		MetricsAddr:  configG.prometheus.addr,
+		OTLPEndpoint: configG.otlp.endpoint,
+		OTLPInterval: configG.otlp.intervalDur,
+		Webhook:      configG.webhook.options,
+		Syslog:       configG.syslog.options,
+		Email:        configG.email.options,
+		Exec:         configG.exec.options,
+	}); err != nil {
 		fmt.Printf("a call to watchdog.Run fails. Is DB trace directory set correctly (path, permissions)? Aborting. err: %v\n", err)
 		os.Exit(1)
 	}
@@ -170,6 +253,7 @@ func main() {
 		rttanalyzer.Debug = *debug
 		rttpubsub.Debug = *debug
 		sqlinput.Debug = *debug
+		baseline.Debug = *debug
 		fmt.Printf("[%v] dbg> os.Args = %#v\n", time.Now().Format("2006-01-02 15:04:05"), os.Args)
 	}
 
@@ -187,19 +271,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	if config.dbName == "" {
-		fmt.Printf("dbname parameter is not provided in %q config file. Aborting.\n", configFileName)
-		os.Exit(1)
-	}
-	if config.dirName == "" {
-		fmt.Printf("dirname parameter is not provided in %q config file. Aborting.\n", configFileName)
+	if err := config.validate(); err != nil {
+		fmt.Printf("error in %q config file: %v. Aborting.\n", configFileName, err)
 		os.Exit(1)
 	}
-	if config.sqlInput == "" {
-		fmt.Printf("sqlinput parameter is not provided in %q config file. Aborting.\n", configFileName)
-		os.Exit(1)
+	cursor.MaxCursorsPerSession = config.trace.maxCursorsPerSession
+	if config.baseline.on() {
+		if baselineG, err = baseline.Load(config.baseline.file, config.baseline.config); err != nil {
+			fmt.Printf("error loading the baselines: %v. Aborting.\n", err)
+			os.Exit(1)
+		}
 	}
-	if *dequeue && config.outputType != "pubsub" {
+	if *dequeue && !hasOutput(config.outputType, "pubsub") {
 		fmt.Printf("a dequeue mode is requested on the command line, but outputtype is not set to pubsub (outputtype is set to %s) in the %q config file. Aborting.\n", config.outputType, configFileName)
 		os.Exit(1)
 	}
@@ -208,7 +291,7 @@ func main() {
 
 	var service *bqgen.Service
 	projectName := config.projectName
-	if config.outputType == "pubsub" {
+	if hasOutput(config.outputType, "pubsub") {
 		if config.appCred == "" {
 			fmt.Println("a Pub/Sub mode is requested (via outputtype config parameter), yet appcredential mandatory parameter is not set. Aborting.")
 			os.Exit(1)
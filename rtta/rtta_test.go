@@ -69,3 +69,95 @@ projectname = MyProjectName
 		t.Errorf("loadSQL(): -> diff -got +want\n%s", pretty.Compare(config, wanted))
 	}
 }
+
+// loadConfigText loads a config file of the text given.
+func loadConfigText(t *testing.T, text string) (*config, error) {
+	fh, err := ioutil.TempFile("", "configFileCopy")
+	if err != nil {
+		t.Fatalf("cannot open a temp file to write the config file to: %v", err)
+	}
+	defer func() {
+		fh.Close()
+		os.Remove(fh.Name())
+	}()
+	if _, err := fh.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(fh.Name())
+}
+
+func TestLoadConfigEquals(t *testing.T) {
+	const sampleConfig = `outputtype = webhook
+webhookurl = https://hooks.example.com/services/x?token=abc&team=dba
+`
+	config, err := loadConfigText(t, sampleConfig)
+	if err != nil {
+		t.Fatalf("loadConfig() failed: %v", err)
+	}
+	if got, want := config.webhook.url, "https://hooks.example.com/services/x?token=abc&team=dba"; got != want {
+		t.Errorf("webhookurl = %q, want %q", got, want)
+	}
+
+	if _, err := loadConfigText(t, "dbname\n"); err == nil {
+		t.Errorf("loadConfig() of a parameter with no value succeeded, want an error")
+	}
+}
+
+func TestLoadConfigExecCommand(t *testing.T) {
+	config, err := loadConfigText(t, "outputtype = exec\nexeccommand = /usr/local/bin/page --team=dba --severity=crit\n")
+	if err != nil {
+		t.Fatalf("loadConfig() failed: %v", err)
+	}
+	if got, want := config.exec.command, "/usr/local/bin/page --team=dba --severity=crit"; got != want {
+		t.Errorf("execcommand = %q, want %q", got, want)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	const required = "dbname = CLOUD2\ndirname = /u01/trace\nsqlinput = rtta.sqlinput\n"
+	var testCases = []struct {
+		text    string
+		wantErr bool
+	}{
+		{text: required + "outputtype = varz\n"},
+		{text: "dirname = /u01/trace\nsqlinput = rtta.sqlinput\n", wantErr: true},
+		{text: required + "binds = all\n", wantErr: true},
+		{text: required + "otlpinterval = -1m\n", wantErr: true},
+		{text: required + "outputtype = varz, webhook\nwebhookurl = ftp://hooks.example.com\n", wantErr: true},
+		// The parameters of an output media that isn't requested are left alone.
+		{text: required + "outputtype = varz\nwebhookurl = ftp://hooks.example.com\n"},
+		{text: required + "outputtype = email\nemailrelay = smtp.example.com:587\nemailfrom = rtta@example.com\nemailto = dba@example.com\nemailroute = oe-team\n", wantErr: true},
+		{text: required + "outputtype = exec\n", wantErr: true},
+		{text: required + "outputtype = exec\nexeccommand = /usr/local/bin/page\nexecconcurrency = 0\n", wantErr: true},
+	}
+
+	for _, ent := range testCases {
+		config, err := loadConfigText(t, ent.text)
+		if err != nil {
+			t.Fatalf("loadConfig(%q) failed: %v", ent.text, err)
+		}
+		if err := config.validate(); (err != nil) != ent.wantErr {
+			t.Errorf("validate() of %q = %v, want an error: %v", ent.text, err, ent.wantErr)
+		}
+	}
+}
+
+func TestHasOutput(t *testing.T) {
+	var testCases = []struct {
+		outputType string
+		media      string
+		want       bool
+	}{
+		{"pubsub", "pubsub", true},
+		{"varz, webhook, prometheus", "webhook", true},
+		{"varz,prometheus", "prometheus", true},
+		{"varz, webhook", "pubsub", false},
+		{"webhooks", "webhook", false},
+	}
+
+	for _, ent := range testCases {
+		if got := hasOutput(ent.outputType, ent.media); got != ent.want {
+			t.Errorf("hasOutput(%q, %q) = %v, want %v", ent.outputType, ent.media, got, ent.want)
+		}
+	}
+}
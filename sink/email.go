@@ -0,0 +1,340 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// Security of the connection to the SMTP relay.
+const (
+	EmailStartTLS = "starttls" // The relay must support STARTTLS
+	EmailNoTLS    = "none"     // In the clear, e.g. for a relay on localhost
+)
+
+// DefaultEmailDigest is how often the events are mailed by default.
+const DefaultEmailDigest = 10 * time.Minute
+
+// emailTimeout caps the delivery of a digest to the SMTP relay.
+const emailTimeout = 30 * time.Second
+
+// emailMaxEvents caps the events listed in a digest, the ones beyond are only counted.
+const emailMaxEvents = 500
+
+// EmailOptions are the user tunables (set in rtta.conf) of the email output media.
+type EmailOptions struct {
+	Relay    string              // host:port of the SMTP relay
+	TLS      string              // One of EmailStartTLS (the default) or EmailNoTLS
+	Username string              // Of the PLAIN authentication, none if not set
+	Password string              // Of the PLAIN authentication
+	From     string              // Sender of the digests
+	To       []string            // Recipients of the events of the business txs with no route
+	Routes   map[string][]string // Recipients by the owner of the business tx
+	Digest   time.Duration       // How often the events are mailed, DefaultEmailDigest if not set
+}
+
+// emailDigest holds the events to be mailed to a recipient list.
+type emailDigest struct {
+	to      []string
+	since   time.Time // Of the first event
+	events  []*rttpubsub.PayloadSummary
+	dropped int // Events beyond emailMaxEvents
+}
+
+// Email provides specific implementation of the Dumper interface for email: the events (the
+// violations, new plans, errors and burn rate alerts) are batched per recipient list, the one
+// routed to by the owner of the business tx or Options.Email.To, and each batch is mailed as
+// a digest through an SMTP relay every Options.Email.Digest.
+// It is safe to use from multiple goroutines simultaneously.
+type Email struct {
+	Generic
+	sync.Mutex
+	digests   map[string]*emailDigest // By the recipient list
+	host      string                  // Of the relay
+	tlsConfig *tls.Config
+	hostname  string
+	stop      chan struct{} // Closed by Close to have the digests mailed one last time
+	done      chan struct{} // Closed once they are
+	stopOnce  sync.Once
+}
+
+// Init validates the email options.
+func (em *Email) Init() error {
+	o := em.Options.Email
+	var err error
+	if em.host, _, err = net.SplitHostPort(o.Relay); err != nil {
+		return fmt.Errorf("Email.Init: relay is expected to be a host:port address: %v", err)
+	}
+	switch o.TLS {
+	case "", EmailStartTLS, EmailNoTLS:
+	default:
+		return fmt.Errorf("Email.Init: tls can be one of %s or %s, got %q", EmailStartTLS, EmailNoTLS, o.TLS)
+	}
+	if o.Username != "" && o.TLS == EmailNoTLS {
+		return fmt.Errorf("Email.Init: no authentication in the clear, STARTTLS is needed")
+	}
+	if _, err := mail.ParseAddress(o.From); err != nil {
+		return fmt.Errorf("Email.Init: bad sender %q: %v", o.From, err)
+	}
+	if len(o.To) == 0 {
+		return fmt.Errorf("Email.Init: no recipient to mail the events to")
+	}
+	lists := [][]string{o.To}
+	for _, to := range o.Routes {
+		lists = append(lists, to)
+	}
+	for _, to := range lists {
+		for _, addr := range to {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return fmt.Errorf("Email.Init: bad recipient %q: %v", addr, err)
+			}
+		}
+	}
+	em.digests = make(map[string]*emailDigest)
+	em.tlsConfig = &tls.Config{ServerName: em.host}
+	if em.hostname, _ = os.Hostname(); em.hostname == "" {
+		em.hostname = "localhost"
+	}
+	return nil
+}
+
+// Dump method specific to Email target.
+func (em *Email) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := em.state().parseRecord(traceRec, em.MonitoredSQLs, curTracker, em.Options)
+	if err != nil {
+		return err
+	}
+	return em.deliver(ctx, client, pr)
+}
+
+// deliver adds the events of a parsed record to the digests of their recipient lists.
+func (em *Email) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	ps := em.payloads(pr)
+	if len(ps) == 0 {
+		return nil
+	}
+	em.Lock()
+	defer em.Unlock()
+	for _, p := range ps {
+		to := em.Options.Email.To
+		if routed, ok := em.Options.Email.Routes[p.Owner]; ok {
+			to = routed
+		}
+		key := strings.Join(to, ",")
+		d, ok := em.digests[key]
+		if !ok {
+			d = &emailDigest{to: to, since: time.Now()}
+			em.digests[key] = d
+		}
+		if len(d.events) >= emailMaxEvents {
+			d.dropped++
+			continue
+		}
+		d.events = append(d.events, p)
+	}
+	return nil
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (em *Email) LoadSQL() error {
+	sql, err := mustLoadSQL(em.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> email.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	em.MonitoredSQLs = sql
+	return nil
+}
+
+// Start starts mailing the digests every Options.Email.Digest in the background, until the
+// context is done or the Email is closed, when the events pending are mailed one last time.
+func (em *Email) Start(ctx context.Context) {
+	interval := em.Options.Email.Digest
+	if interval <= 0 {
+		interval = DefaultEmailDigest
+	}
+	fmt.Printf("[%v] info> mailing the digests of the events through %s every %v\n", time.Now().Format("2006-01-02 15:04:05"), em.Options.Email.Relay, interval)
+	em.stop = make(chan struct{})
+	em.done = make(chan struct{})
+	go func() {
+		defer close(em.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				em.flush(time.Now())
+				return
+			case <-em.stop:
+				em.flush(time.Now())
+				return
+			case <-ticker.C:
+				em.flush(time.Now())
+			}
+		}
+	}()
+}
+
+// Close stops mailing the digests, returning once the events pending are mailed one last time.
+func (em *Email) Close() error {
+	if em.done == nil {
+		return nil
+	}
+	em.stopOnce.Do(func() { close(em.stop) })
+	<-em.done
+	return nil
+}
+
+// flush mails the digests pending as of a time.  A digest that can't be mailed is logged and
+// dropped, so that a relay that is down doesn't pile the events up.
+func (em *Email) flush(now time.Time) {
+	em.Lock()
+	digests := em.digests
+	em.digests = make(map[string]*emailDigest)
+	em.Unlock()
+
+	keys := make([]string, 0, len(digests))
+	for key := range digests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		d := digests[key]
+		if err := em.send(d.to, em.message(d, now)); err != nil {
+			fmt.Printf("[%v] error> could not mail a digest of %d events to %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), len(d.events)+d.dropped, key, err)
+			continue
+		}
+		if Debug {
+			fmt.Printf("[%v] dbg> email.flush: mailed a digest of %d events to %s\n", time.Now().Format("2006-01-02 15:04:05"), len(d.events)+d.dropped, key)
+		}
+	}
+}
+
+// message renders a digest mailed at a time as a plain text message: the tally of the events
+// by kind as the subject and an event per line as the body.
+func (em *Email) message(d *emailDigest, now time.Time) []byte {
+	counts := make(map[string]int)
+	for _, p := range d.events {
+		counts[eventKind(p)]++
+	}
+	var tally []string
+	for _, k := range []struct{ kind, name string }{{EventViolation, "violation"}, {EventNewPlan, "new plan"}, {EventError, "error"}, {EventBurn, "burn rate alert"}} {
+		switch n := counts[k.kind]; n {
+		case 0:
+		case 1:
+			tally = append(tally, "1 "+k.name)
+		default:
+			tally = append(tally, fmt.Sprintf("%d %ss", n, k.name))
+		}
+	}
+	if d.dropped > 0 {
+		tally = append(tally, fmt.Sprintf("%d more", d.dropped))
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", em.Options.Email.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(d.to, ", "))
+	fmt.Fprintf(&b, "Subject: [rttanalyzer] %s: %s\r\n", em.DBName, strings.Join(tally, ", "))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&b, "RTTAnalyzer events of %s from %s to %s:\r\n\r\n", em.DBName, d.since.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"))
+	for _, p := range d.events {
+		fmt.Fprintf(&b, "%s %-9s %s\r\n", p.EventTime.Format("2006-01-02 15:04:05.000"), eventKind(p), summary(p))
+	}
+	if d.dropped > 0 {
+		fmt.Fprintf(&b, "\r\n... and %d more events not listed.\r\n", d.dropped)
+	}
+	return b.Bytes()
+}
+
+// send mails a message to the recipients through the relay.
+func (em *Email) send(to []string, msg []byte) error {
+	o := em.Options.Email
+	conn, err := net.DialTimeout("tcp", o.Relay, emailTimeout)
+	if err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+	c, err := smtp.NewClient(conn, em.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("email.send: %v", err)
+	}
+	defer c.Close()
+	if err := c.Hello(em.hostname); err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	if o.TLS != EmailNoTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("email.send: %s doesn't support STARTTLS", o.Relay)
+		}
+		if err := c.StartTLS(em.tlsConfig); err != nil {
+			return fmt.Errorf("email.send: %v", err)
+		}
+	}
+	if o.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", o.Username, o.Password, em.host)); err != nil {
+			return fmt.Errorf("email.send: %v", err)
+		}
+	}
+	if err := c.Mail(bareAddress(o.From)); err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(bareAddress(addr)); err != nil {
+			return fmt.Errorf("email.send: %v", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	if err := c.Quit(); err != nil {
+		return fmt.Errorf("email.send: %v", err)
+	}
+	return nil
+}
+
+// bareAddress strips the name off an address validated by Init, e.g. "DBA <dba@example.com>".
+func bareAddress(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return addr
+}
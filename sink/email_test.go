@@ -0,0 +1,310 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Email_test runs unit tests on the digests mailed through an SMTP relay.
+package sink
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+	"golang.org/x/net/context"
+)
+
+// smtpMail is a mail received by the SMTP stand-in.
+type smtpMail struct {
+	tls  bool
+	auth string // user:password
+	from string
+	to   []string
+	data string // With the line endings of the mail turned into \n
+}
+
+// smtpStandIn is an in-process SMTP relay recording the mails it receives.
+type smtpStandIn struct {
+	sync.Mutex
+	listener net.Listener
+	config   *tls.Config // STARTTLS is not supported if nil
+	mails    []smtpMail
+}
+
+// newSMTPStandIn starts an SMTP stand-in on localhost, supporting STARTTLS with a self-signed
+// certificate trusted by the pool returned if startTLS is set.
+func newSMTPStandIn(t *testing.T, startTLS bool) (*smtpStandIn, *x509.CertPool) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %v", err)
+	}
+	s := &smtpStandIn{listener: listener}
+	pool := x509.NewCertPool()
+	if startTLS {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("ecdsa.GenerateKey failed: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "127.0.0.1"},
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("x509.CreateCertificate failed: %v", err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		pool.AddCert(cert)
+		s.config = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, pool
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stand-in ESMTP")
+	var mail smtpMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			tp.PrintfLine("250-stand-in")
+			if s.config != nil && !mail.tls {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.config)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, tp, mail.tls = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			fields := strings.Fields(line)
+			resp, err := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			if err != nil {
+				tp.PrintfLine("501 bad response")
+				continue
+			}
+			mail.auth = strings.Replace(strings.TrimPrefix(string(resp), "\x00"), "\x00", ":", 1)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			mail.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+			s.Lock()
+			s.mails = append(s.mails, mail)
+			s.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// emailRecs end with a violation of Billing: 30 ms over the threshold of 20 ms.
+var emailRecs = []string{
+	"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034438 ad='7cbeae9d9' sqlid='bzscyq07w79ab'\n",
+	"EXEC #4:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+	"FETCH #4:c=0,e=20000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809217197\n",
+	"CLOSE #4:c=0,e=1,dep=0,type=0,tim=1409063809217198\n",
+}
+
+func TestEmailDigest(t *testing.T) {
+	relay, pool := newSMTPStandIn(t, true)
+	defer relay.listener.Close()
+
+	em := &Email{Generic: testGeneric(Options{Email: EmailOptions{
+		Relay:    relay.listener.Addr().String(),
+		Username: "rtta",
+		Password: "s3cr3t",
+		From:     "RTTAnalyzer <rtta@example.com>",
+		To:       []string{"dba@example.com"},
+		Routes:   map[string][]string{"oe-team": []string{"oe@example.com", "DBA <dba@example.com>"}},
+	}})}
+	em.MonitoredSQLs[0].Owner = "oe-team"
+	em.MonitoredSQLs = append(em.MonitoredSQLs, MonitoredSQL{
+		BusinessTxName: "Billing",
+		ELAThreshold:   20,
+		SQLID:          []string{"bzscyq07w79ab"},
+	})
+	if err := em.Init(); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	em.tlsConfig.RootCAs = pool
+	// The digests are only mailed as the Email is closed.
+	em.Start(context.Background())
+	// Two violations of Order Entry and one of Billing.
+	dumpRecs(t, em, violationRecs, emailRecs, violationRecs)
+
+	relay.Lock()
+	defer relay.Unlock()
+	if len(relay.mails) != 2 {
+		t.Fatalf("relay got %d mails, want a digest per recipient list", len(relay.mails))
+	}
+	// The digests are mailed in the order of their recipient lists.
+	var testCases = []struct {
+		to          []string
+		wantSubject string
+		wantLines   []string
+	}{
+		{
+			to:          []string{"dba@example.com"},
+			wantSubject: "Subject: [rttanalyzer] CLOUD2: 1 violation\n",
+			wantLines:   []string{"violation CLOUD2: Billing [SQL_ID=bzscyq07w79ab] ran for 30.000 [ms] (threshold of 20.000 [ms])"},
+		},
+		{
+			to:          []string{"oe@example.com", "dba@example.com"},
+			wantSubject: "Subject: [rttanalyzer] CLOUD2: 2 violations\n",
+			wantLines: []string{
+				"violation CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])",
+				"violation CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])",
+			},
+		},
+	}
+	for i, ent := range testCases {
+		m := relay.mails[i]
+		if !m.tls || m.auth != "rtta:s3cr3t" || m.from != "rtta@example.com" || !reflect.DeepEqual(m.to, ent.to) {
+			t.Errorf("relay got a mail from %s to %v (TLS %v, auth %q), want one from rtta@example.com to %v over TLS authenticated as rtta", m.from, m.to, m.tls, m.auth, ent.to)
+		}
+		if !strings.Contains(m.data, ent.wantSubject) {
+			t.Errorf("relay got %q, want %q", m.data, ent.wantSubject)
+		}
+		if got := strings.Count(m.data, " violation CLOUD2: "); got != len(ent.wantLines) {
+			t.Errorf("relay got %q, want %d events", m.data, len(ent.wantLines))
+		}
+		for _, line := range ent.wantLines {
+			if !strings.Contains(m.data, line) {
+				t.Errorf("relay got %q, want %q", m.data, line)
+			}
+		}
+	}
+}
+
+func TestEmailStartTLS(t *testing.T) {
+	relay, _ := newSMTPStandIn(t, false)
+	defer relay.listener.Close()
+
+	o := EmailOptions{Relay: relay.listener.Addr().String(), From: "rtta@example.com", To: []string{"dba@example.com"}}
+	em := &Email{Generic: Generic{Options: Options{Email: o}}}
+	if err := em.Init(); err != nil {
+		t.Fatalf("Init(%+v) failed: %v", o, err)
+	}
+	if err := em.send(o.To, []byte("Subject: test\r\n\r\ntest\r\n")); err == nil {
+		t.Errorf("send() through a relay with no STARTTLS succeeded, want an error")
+	}
+
+	em.Options.Email.TLS = EmailNoTLS
+	if err := em.send(o.To, []byte("Subject: test\r\n\r\ntest\r\n")); err != nil {
+		t.Fatalf("send() in the clear failed: %v", err)
+	}
+	relay.Lock()
+	defer relay.Unlock()
+	if len(relay.mails) != 1 || relay.mails[0].tls || relay.mails[0].data != "Subject: test\n\ntest\n" {
+		t.Errorf("relay got %+v, want the mail in the clear", relay.mails)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	since := time.Date(2017, 1, 30, 16, 40, 0, 0, time.UTC)
+	now := time.Date(2017, 1, 30, 16, 50, 0, 0, time.UTC)
+	at := time.Date(2017, 1, 30, 16, 43, 20, 123000000, time.UTC)
+	em := &Email{Generic: Generic{DBName: "CLOUD2", Options: Options{Email: EmailOptions{From: "rtta@example.com"}}}}
+	d := &emailDigest{
+		to:    []string{"oe@example.com", "dba@example.com"},
+		since: since,
+		events: []*rttpubsub.PayloadSummary{
+			{DB: "CLOUD2", IsViolation: true, BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt", LastELA: 70, Threshold: 50, EventTime: at},
+			{DB: "CLOUD2", IsViolation: true, BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt", LastELA: 80, Threshold: 50, EventTime: at},
+			{DB: "CLOUD2", IsNewPlan: true, BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt", PlanHash: "2949544139", EventTime: at},
+		},
+		dropped: 2,
+	}
+	want := "From: rtta@example.com\r\n" +
+		"To: oe@example.com, dba@example.com\r\n" +
+		"Subject: [rttanalyzer] CLOUD2: 2 violations, 1 new plan, 2 more\r\n" +
+		"Date: Mon, 30 Jan 2017 16:50:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		"RTTAnalyzer events of CLOUD2 from 2017-01-30 16:40:00 to 2017-01-30 16:50:00:\r\n\r\n" +
+		"2017-01-30 16:43:20.123 violation CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])\r\n" +
+		"2017-01-30 16:43:20.123 violation CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 80.000 [ms] (threshold of 50.000 [ms])\r\n" +
+		"2017-01-30 16:43:20.123 newplan   CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] runs with a new plan 2949544139\r\n" +
+		"\r\n... and 2 more events not listed.\r\n"
+	if got := string(em.message(d, now)); got != want {
+		t.Errorf("message():\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestEmailInit(t *testing.T) {
+	var testCases = []EmailOptions{
+		{From: "rtta@example.com", To: []string{"dba@example.com"}},
+		{Relay: "smtp.example.com:587", TLS: "ssl", From: "rtta@example.com", To: []string{"dba@example.com"}},
+		{Relay: "smtp.example.com:25", TLS: EmailNoTLS, Username: "rtta", From: "rtta@example.com", To: []string{"dba@example.com"}},
+		{Relay: "smtp.example.com:587", From: "rtta", To: []string{"dba@example.com"}},
+		{Relay: "smtp.example.com:587", From: "rtta@example.com"},
+		{Relay: "smtp.example.com:587", From: "rtta@example.com", To: []string{"dba@example.com"}, Routes: map[string][]string{"oe-team": []string{"oe team"}}},
+	}
+
+	for _, o := range testCases {
+		em := &Email{Generic: Generic{Options: Options{Email: o}}}
+		if err := em.Init(); err == nil {
+			t.Errorf("Init(%+v) succeeded, want an error", o)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// Defaults of the commands run.
+const (
+	DefaultExecTimeout     = 30 * time.Second
+	DefaultExecConcurrency = 4
+)
+
+// execWaitDelay caps the wait for the output of a command once it ended or was killed.
+const execWaitDelay = time.Second
+
+// execMaxOutput caps the output of a command kept for the logs.
+const execMaxOutput = 1024
+
+// ExecOptions are the user tunables (set in rtta.conf) of the exec output media.
+type ExecOptions struct {
+	Command     []string      // Path of the command and its arguments, run with no shell
+	Timeout     time.Duration // After which the command is killed, DefaultExecTimeout if not set
+	Concurrency int           // Commands run at a time at most, DefaultExecConcurrency if not set
+}
+
+// Exec provides specific implementation of the Dumper interface for a user command: it is run
+// for each event (a violation, a new plan, an error or a burn rate alert) with the event as
+// JSON on its standard input (see rttpubsub.PayloadSummary) and its main fields as RTTA_*
+// environment variables.  The exit status of the command is logged.
+// The commands run in the background, Dump only waits for one to end when
+// Options.Exec.Concurrency of them are running already.
+type Exec struct {
+	Generic
+	slots   chan struct{}  // A token per command running
+	running sync.WaitGroup // The commands running
+}
+
+// Init validates the exec options.
+func (ex *Exec) Init() error {
+	o := ex.Options.Exec
+	if len(o.Command) == 0 {
+		return fmt.Errorf("Exec.Init: no command to run")
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("Exec.Init: the timeout is expected to be positive, got %v", o.Timeout)
+	}
+	if o.Concurrency < 0 {
+		return fmt.Errorf("Exec.Init: the concurrency is expected to be positive, got %d", o.Concurrency)
+	}
+	concurrency := o.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultExecConcurrency
+	}
+	ex.slots = make(chan struct{}, concurrency)
+	return nil
+}
+
+// Dump method specific to Exec target.
+func (ex *Exec) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := ex.state().parseRecord(traceRec, ex.MonitoredSQLs, curTracker, ex.Options)
+	if err != nil {
+		return err
+	}
+	return ex.deliver(ctx, client, pr)
+}
+
+// deliver runs the command for the events of a parsed record.
+func (ex *Exec) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	for _, p := range ex.payloads(pr) {
+		select {
+		case ex.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		ex.running.Add(1)
+		go func(p *rttpubsub.PayloadSummary) {
+			defer ex.running.Done()
+			defer func() { <-ex.slots }()
+			start := time.Now()
+			if err := ex.run(ctx, p); err != nil {
+				fmt.Printf("[%v] error> the command run for an event of %s failed after %v: %v\n", time.Now().Format("2006-01-02 15:04:05"), p.BusinessTxName, time.Since(start), err)
+				return
+			}
+			fmt.Printf("[%v] info> the command run for an event of %s exited with status 0 after %v\n", time.Now().Format("2006-01-02 15:04:05"), p.BusinessTxName, time.Since(start))
+		}(p)
+	}
+	return nil
+}
+
+// Close waits for the commands running to end (or to be killed once their timeout is over).
+func (ex *Exec) Close() error {
+	ex.running.Wait()
+	return nil
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (ex *Exec) LoadSQL() error {
+	sql, err := mustLoadSQL(ex.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> exec.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	ex.MonitoredSQLs = sql
+	return nil
+}
+
+// run runs the command for an event, killing it once Options.Exec.Timeout is over.  The error
+// returned carries the exit status and the output of the command.
+func (ex *Exec) run(ctx context.Context, p *rttpubsub.PayloadSummary) error {
+	o := ex.Options.Exec
+	in, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("exec.run: %v", err)
+	}
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, o.Command[0], o.Command[1:]...)
+	cmd.Env = append(os.Environ(), execEnv(p)...)
+	cmd.Stdin = bytes.NewReader(in)
+	// The output is read off a pipe of our own: a child left behind by a killed command (e.g. by
+	// a shell script) keeps it open, and so would keep cmd.Wait waiting for it.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("exec.run: %v", err)
+	}
+	out := &execOutput{}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(out, pr)
+	}()
+	cmd.Stdout, cmd.Stderr = pw, pw
+	err = cmd.Start()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	pw.Close()
+	select {
+	case <-copied:
+	case <-time.After(execWaitDelay):
+	}
+	pr.Close()
+	<-copied
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("killed: %v (timeout of %v)", ctx.Err(), timeout)
+		}
+		return fmt.Errorf("exec.run: %s: %v: %q", strings.Join(o.Command, " "), err, out.Bytes())
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> exec.run: %s: %q\n", time.Now().Format("2006-01-02 15:04:05"), strings.Join(o.Command, " "), out.Bytes())
+	}
+	return nil
+}
+
+// execEnv returns the environment variables describing an event.
+func execEnv(p *rttpubsub.PayloadSummary) []string {
+	env := []string{
+		"RTTA_EVENT=" + eventKind(p),
+		"RTTA_DB=" + p.DB,
+		"RTTA_BUSINESS_TX=" + p.BusinessTxName,
+		"RTTA_SQL_ID=" + p.SQLID,
+		fmt.Sprintf("RTTA_THRESHOLD=%.3f", p.Threshold),
+		fmt.Sprintf("RTTA_ELA=%.3f", p.LastELA),
+		"RTTA_PLAN_HASH=" + p.PlanHash,
+		"RTTA_EVENT_TIME=" + p.EventTime.Format(time.RFC3339Nano),
+		"RTTA_OWNER=" + p.Owner,
+		"RTTA_SEVERITY=" + p.Severity,
+		"RTTA_SUMMARY=" + summary(p),
+	}
+	switch eventKind(p) {
+	case EventError:
+		env = append(env, fmt.Sprintf("RTTA_ERROR=ORA-%05d", p.ErrorCode))
+	case EventBurn:
+		env = append(env, "RTTA_BURN_RATE="+p.BurnRate, fmt.Sprintf("RTTA_BUDGET_LEFT=%.4f", p.BudgetLeft))
+	}
+	return env
+}
+
+// execOutput keeps the first execMaxOutput bytes of the output of a command.
+type execOutput struct {
+	bytes.Buffer
+}
+
+func (o *execOutput) Write(b []byte) (int, error) {
+	if room := execMaxOutput - o.Len(); room > 0 {
+		if len(b) > room {
+			o.Buffer.Write(b[:room])
+		} else {
+			o.Buffer.Write(b)
+		}
+	}
+	return len(b), nil
+}
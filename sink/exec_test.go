@@ -0,0 +1,151 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Exec_test runs unit tests on the commands run for the events.
+package sink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+	"golang.org/x/net/context"
+)
+
+// dumpExec feeds the records of executions of Order Entry to an exec running a shell script,
+// and waits for the commands to end.
+func dumpExec(t *testing.T, o ExecOptions, script string, executions int) {
+	o.Command = []string{"/bin/sh", "-c", script}
+	ex := &Exec{Generic: testGeneric(Options{Exec: o})}
+	ex.MonitoredSQLs[0].Owner = "oe-team"
+	if err := ex.Init(); err != nil {
+		t.Fatalf("Init(%+v) failed: %v", o, err)
+	}
+	var recs [][]string
+	for i := 0; i < executions; i++ {
+		recs = append(recs, violationRecs)
+	}
+	dumpRecs(t, ex, recs...)
+}
+
+func TestExecPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExecPayload")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dumpExec(t, ExecOptions{}, `cat > "`+dir+`/stdin"; env | grep ^RTTA_ > "`+dir+`/env"`, 1)
+
+	in, err := ioutil.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatalf("the command did not run: %v", err)
+	}
+	var got rttpubsub.PayloadSummary
+	if err := json.Unmarshal(in, &got); err != nil {
+		t.Fatalf("the command got %s on stdin, want a PayloadSummary: %v", in, err)
+	}
+	if !got.IsViolation || got.BusinessTxName != "Order Entry" || got.SQLID != "acc988uzvjmmt" || got.LastELA != 70 {
+		t.Errorf("the command got %+v on stdin, want the violation of Order Entry", got)
+	}
+
+	env, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatalf("reading the environment of the command failed: %v", err)
+	}
+	for _, want := range []string{
+		"RTTA_EVENT=violation",
+		"RTTA_DB=CLOUD2",
+		"RTTA_BUSINESS_TX=Order Entry",
+		"RTTA_SQL_ID=acc988uzvjmmt",
+		"RTTA_THRESHOLD=50.000",
+		"RTTA_ELA=70.000",
+		"RTTA_OWNER=oe-team",
+		"RTTA_SUMMARY=CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])",
+	} {
+		if !strings.Contains(string(env), want+"\n") {
+			t.Errorf("the command got the environment %q, want %s", env, want)
+		}
+	}
+}
+
+func TestExecConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExecConcurrency")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// A command running while another one is shows up in the overlaps.
+	script := `if mkdir "` + dir + `/running"; then sleep 0.05; rmdir "` + dir + `/running"; else echo >> "` + dir + `/overlaps"; fi; echo >> "` + dir + `/runs"`
+	dumpExec(t, ExecOptions{Concurrency: 1}, script, 4)
+
+	if runs, err := ioutil.ReadFile(filepath.Join(dir, "runs")); err != nil || len(runs) != 4 {
+		t.Errorf("the command ran %d times (%v), want 4", len(runs), err)
+	}
+	if overlaps, err := ioutil.ReadFile(filepath.Join(dir, "overlaps")); err == nil {
+		t.Errorf("the commands overlapped %d times with a concurrency of 1", len(overlaps))
+	}
+}
+
+func TestExecStatus(t *testing.T) {
+	p := &rttpubsub.PayloadSummary{DB: "CLOUD2", IsViolation: true, BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt", LastELA: 70, Threshold: 50}
+	var testCases = []struct {
+		script  string
+		timeout time.Duration
+		wantErr string // Empty if the command is to succeed
+	}{
+		{script: "exit 0"},
+		{script: "echo paging failed >&2; exit 3", wantErr: `exit status 3: "paging failed\n"`},
+		{script: "sleep 10", timeout: 100 * time.Millisecond, wantErr: "killed: context deadline exceeded (timeout of 100ms)"},
+		// The sleep left behind by the killed shell keeps the output open.
+		{script: "echo paging; sleep 10; echo done", timeout: 100 * time.Millisecond, wantErr: `killed: context deadline exceeded (timeout of 100ms): "paging\n"`},
+	}
+
+	for _, ent := range testCases {
+		o := ExecOptions{Command: []string{"/bin/sh", "-c", ent.script}, Timeout: ent.timeout}
+		ex := &Exec{Generic: Generic{Options: Options{Exec: o}}}
+		if err := ex.Init(); err != nil {
+			t.Fatalf("Init(%+v) failed: %v", o, err)
+		}
+		start := time.Now()
+		err := ex.run(context.Background(), p)
+		switch {
+		case ent.wantErr == "" && err != nil:
+			t.Errorf("run(%q) failed: %v", ent.script, err)
+		case ent.wantErr != "" && (err == nil || !strings.Contains(err.Error(), ent.wantErr)):
+			t.Errorf("run(%q) = %v, want an error with %q", ent.script, err, ent.wantErr)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("run(%q) took %v, want it killed after %v", ent.script, elapsed, ent.timeout)
+		}
+	}
+}
+
+func TestExecInit(t *testing.T) {
+	var testCases = []ExecOptions{
+		{},
+		{Command: []string{"/usr/local/bin/page"}, Timeout: -time.Second},
+		{Command: []string{"/usr/local/bin/page"}, Concurrency: -1},
+	}
+
+	for _, o := range testCases {
+		ex := &Exec{Generic: Generic{Options: Options{Exec: o}}}
+		if err := ex.Init(); err == nil {
+			t.Errorf("Init(%+v) succeeded, want an error", o)
+		}
+	}
+}
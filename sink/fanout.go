@@ -0,0 +1,171 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/miner"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// deliverer is implemented by all the output media: deliver pushes out what a trace record
+// parsed already turned into, so that the record is only parsed once however many output
+// media it is delivered to, and share makes them read the State the record was parsed with.
+type deliverer interface {
+	deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error
+	share(st *State)
+}
+
+// fanoutQueue is the number of records (that turned into events) queued for each output media
+// of a Fanout.
+var fanoutQueue = 1000
+
+// Fanout provides specific implementation of the Dumper interface for several output media at
+// once: each record is parsed once (with the SQL statements of interest and the Options of the
+// Fanout) and the events it turned into are queued for each of the Sinks, delivered to each one
+// by a goroutine of its own so that a slow output media doesn't hold back the others.  A record
+// is dropped (and logged) for an output media whose queue is full, and an output media that
+// panics is logged like one that fails.
+type Fanout struct {
+	Generic
+	Sinks  []miner.Dumper // The output media of this package, e.g. a *Webhook and a *Prometheus
+	queues []chan queuedRecord
+	mu     sync.RWMutex // Guards closed against the sends to the queues
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// queuedRecord is a parsed record queued for an output media of a Fanout.
+type queuedRecord struct {
+	ctx    context.Context
+	client *pubsub.Client
+	pr     *parsedSummary
+}
+
+// Init validates the output media of the Fanout, makes them share its State and starts
+// delivering to them.
+func (f *Fanout) Init() error {
+	if len(f.Sinks) == 0 {
+		return fmt.Errorf("Fanout.Init: no output media to deliver the events to")
+	}
+	for _, s := range f.Sinks {
+		d, ok := s.(deliverer)
+		if !ok {
+			return fmt.Errorf("Fanout.Init: %T is not an output media of the sink package", s)
+		}
+		d.share(f.state())
+	}
+	for _, s := range f.Sinks {
+		queue := make(chan queuedRecord, fanoutQueue)
+		f.queues = append(f.queues, queue)
+		f.wg.Add(1)
+		go f.drain(s, queue)
+	}
+	return nil
+}
+
+// drain delivers the records queued for an output media until the queue is closed.
+func (f *Fanout) drain(s miner.Dumper, queue chan queuedRecord) {
+	defer f.wg.Done()
+	for q := range queue {
+		if err := f.deliverTo(q.ctx, q.client, s.(deliverer), q.pr); err != nil {
+			fmt.Printf("[%v] error> could not deliver an event of %s to %T: %v\n", time.Now().Format("2006-01-02 15:04:05"), q.pr.businessTxName, s, err)
+		}
+	}
+}
+
+// Dump method specific to Fanout target.
+func (f *Fanout) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := f.state().parseRecord(traceRec, f.MonitoredSQLs, curTracker, f.Options)
+	if err != nil {
+		return err
+	}
+	if !pr.isEvent() {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return fmt.Errorf("Fanout.Dump: closed")
+	}
+	for i, queue := range f.queues {
+		select {
+		case queue <- queuedRecord{ctx: ctx, client: client, pr: pr}:
+		default:
+			fmt.Printf("[%v] error> the queue of %T is full, an event of %s is dropped\n", time.Now().Format("2006-01-02 15:04:05"), f.Sinks[i], pr.businessTxName)
+		}
+	}
+	return nil
+}
+
+// Close stops taking records, waits for the records queued already to be delivered and then
+// closes the output media that need it, e.g. to send what they hold back.
+func (f *Fanout) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	for _, queue := range f.queues {
+		close(queue)
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+
+	var first error
+	for _, s := range f.Sinks {
+		c, ok := s.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			fmt.Printf("[%v] error> could not close %T: %v\n", time.Now().Format("2006-01-02 15:04:05"), s, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// deliverTo delivers a parsed record to an output media, turning a panic into an error.
+func (f *Fanout) deliverTo(ctx context.Context, client *pubsub.Client, d deliverer, pr *parsedSummary) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Fanout.deliverTo: panic: %v", r)
+		}
+	}()
+	return d.deliver(ctx, client, pr)
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (f *Fanout) LoadSQL() error {
+	sql, err := mustLoadSQL(f.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> fanout.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	f.MonitoredSQLs = sql
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Fanout_test runs unit tests on the events delivered to several output media at once.
+package sink
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/miner"
+	"github.com/borisdali/rttanalyzer/stats"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// brokenSink is an output media failing to deliver every record, or panicking if panics is set.
+type brokenSink struct {
+	Generic
+	panics     bool
+	deliveries int
+}
+
+func (b *brokenSink) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	return fmt.Errorf("brokenSink.Dump: not to be called by a Fanout")
+}
+
+func (b *brokenSink) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	b.deliveries++
+	if b.panics {
+		panic("broken beyond repair")
+	}
+	return fmt.Errorf("brokenSink.deliver: broken")
+}
+
+func TestFanout(t *testing.T) {
+	first, second := &webhookReceiver{}, &webhookReceiver{}
+	var webhooks []miner.Dumper
+	for _, wr := range []*webhookReceiver{first, second} {
+		server := httptest.NewServer(wr)
+		defer server.Close()
+		wh := &Webhook{Generic: Generic{DBName: "CLOUD2", Options: Options{Webhook: WebhookOptions{URLs: []string{server.URL}}}}}
+		if err := wh.Init(); err != nil {
+			t.Fatalf("Init() failed: %v", err)
+		}
+		webhooks = append(webhooks, wh)
+	}
+	failing, panicking := &brokenSink{}, &brokenSink{panics: true}
+
+	// The broken output media come first: the webhooks get the events all the same.
+	f := &Fanout{
+		Generic: testGeneric(Options{}),
+		Sinks:   []miner.Dumper{failing, panicking, &Prometheus{}, webhooks[0], webhooks[1]},
+	}
+	if err := f.Init(); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	dumpRecs(t, f, violationRecs)
+
+	// Only the record that turned into the violation is delivered.
+	if failing.deliveries != 1 || panicking.deliveries != 1 {
+		t.Errorf("the broken output media got %d and %d records, want the violation each", failing.deliveries, panicking.deliveries)
+	}
+	for _, wr := range []*webhookReceiver{first, second} {
+		if len(wr.bodies) != 1 {
+			t.Errorf("webhook got %d requests, want the violation delivered to each one", len(wr.bodies))
+		}
+	}
+	// Each record is parsed once: the execution is counted once.
+	if got := f.State.execs.Get(stats.Key{BusinessTxName: "Order Entry"}); got.Executions != 1 || got.Violations != 1 {
+		t.Errorf("the Fanout counted %d executions with %d violations, want the violation counted once", got.Executions, got.Violations)
+	}
+}
+
+// stuckSink is an output media that gets stuck delivering a record until it's released.
+type stuckSink struct {
+	Generic
+	started    chan struct{}
+	release    chan struct{}
+	deliveries int
+}
+
+func (s *stuckSink) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	return fmt.Errorf("stuckSink.Dump: not to be called by a Fanout")
+}
+
+func (s *stuckSink) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	s.deliveries++
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestFanoutOverflow(t *testing.T) {
+	defer func(n int) { fanoutQueue = n }(fanoutQueue)
+	fanoutQueue = 1
+	stuck := &stuckSink{started: make(chan struct{}, 3), release: make(chan struct{})}
+	f := &Fanout{
+		Generic: testGeneric(Options{}),
+		Sinks:   []miner.Dumper{stuck},
+	}
+	if err := f.Init(); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	dump := func() {
+		for _, rec := range violationRecs {
+			if err := f.Dump(context.Background(), nil, nil, curTracker, rec); err != nil {
+				t.Fatalf("Dump(%q) failed: %v", rec, err)
+			}
+		}
+	}
+
+	// The first violation gets the output media stuck, the second one is queued and the
+	// third one is dropped rather than holding back the Fanout.
+	dump()
+	<-stuck.started
+	dump()
+	dump()
+	close(stuck.release)
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if stuck.deliveries != 2 {
+		t.Errorf("the stuck output media got %d violations, want 2", stuck.deliveries)
+	}
+}
+
+func TestFanoutInit(t *testing.T) {
+	var testCases = [][]miner.Dumper{
+		nil,
+		{&Varz{}, miner.Dumper(nil)},
+	}
+
+	for _, sinks := range testCases {
+		f := &Fanout{Sinks: sinks}
+		if err := f.Init(); err == nil {
+			t.Errorf("Init(%v) succeeded, want an error", sinks)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Helpers_test holds the fixtures shared by the unit tests of the output media.
+package sink
+
+import (
+	"io"
+	"testing"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/miner"
+	"golang.org/x/net/context"
+)
+
+// violationRecs end with a violation of Order Entry: 70 ms over the threshold of 50 ms,
+// reported as the cursor is closed.
+var violationRecs = []string{
+	"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+	"EXEC #3:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+	"FETCH #3:c=0,e=50000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809267197\n",
+	"CLOSE #3:c=0,e=1,dep=0,type=0,tim=1409063809267198\n",
+}
+
+// testGeneric returns the Generic of an output media monitoring Order Entry on CLOUD2
+// (acc988uzvjmmt, with a threshold of 50 ms) with the options.
+func testGeneric(opts Options) Generic {
+	return Generic{
+		DBName: "CLOUD2",
+		MonitoredSQLs: []MonitoredSQL{
+			MonitoredSQL{
+				BusinessTxName: "Order Entry",
+				ELAThreshold:   50,
+				SQLID:          []string{"acc988uzvjmmt"},
+			},
+		},
+		Options: opts,
+	}
+}
+
+// dumpRecs feeds the lists of records in turn to a Dumper, as the ones of a single trace file,
+// then closes it (if it's an io.Closer) so that what it delivers in the background is done with.
+func dumpRecs(t *testing.T, d miner.Dumper, recs ...[]string) {
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	for _, list := range recs {
+		for _, rec := range list {
+			if err := d.Dump(context.Background(), nil, nil, curTracker, rec); err != nil {
+				t.Fatalf("Dump(%q) failed: %v", rec, err)
+			}
+		}
+	}
+	if c, ok := d.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			t.Fatalf("Close() failed: %v", err)
+		}
+	}
+}
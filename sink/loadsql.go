@@ -0,0 +1,349 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of the SQL input file.  The structured formats (YAML and JSON) are told apart
+// from the CSV one by the file extension or, failing that, by the file content.
+const (
+	sqlInputCSV  = "csv"
+	sqlInputJSON = "json"
+	sqlInputYAML = "yaml"
+)
+
+// sqlInputFormat determines the format of the SQL input file.
+func sqlInputFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return sqlInputJSON
+	case ".yaml", ".yml":
+		return sqlInputYAML
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") {
+			return sqlInputJSON
+		}
+		if strings.HasPrefix(line, "transactions:") || line == "---" {
+			return sqlInputYAML
+		}
+		return sqlInputCSV
+	}
+	return sqlInputCSV
+}
+
+// parseThreshold parses a threshold on the elapsed time, returning it in milliseconds.
+// A plain (possibly fractional) number is taken as milliseconds, otherwise the unit is
+// explicit, e.g. 2s, 150ms or 1.5s.
+func parseThreshold(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("parseThreshold: expected milliseconds or a duration with a unit (e.g. 2s or 150ms), got %q", s)
+		}
+		ms = float64(d) / float64(time.Millisecond)
+	}
+	if ms <= 0 {
+		return 0, fmt.Errorf("parseThreshold: the threshold is expected to be positive, got %q", s)
+	}
+	return ms, nil
+}
+
+// millis is a threshold of the structured SQL input file, given as a number of
+// milliseconds or as a string with a unit.
+type millis float64
+
+// set parses a YAML or JSON value of a threshold.
+func (m *millis) set(v interface{}) error {
+	var s string
+	switch v := v.(type) {
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("expected a threshold, got %v", v)
+	}
+	ms, err := parseThreshold(s)
+	if err != nil {
+		return err
+	}
+	*m = millis(ms)
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (m *millis) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return m.set(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *millis) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return m.set(v)
+}
+
+// Phases caps the elapsed time of the PARSE, EXEC and FETCH phases of an execution
+// (or a call with EvaluateCall) in milliseconds.  0 means no limit.
+type Phases struct {
+	Parse float64
+	Exec  float64
+	Fetch float64
+}
+
+// breached returns the names of the phases (parse, exec or fetch) over their limits.
+func (p Phases) breached(e cursor.Execution) []string {
+	var names []string
+	for _, f := range []struct {
+		name  string
+		limit float64
+		ela   int64
+	}{{"parse", p.Parse, e.Parse}, {"exec", p.Exec, e.Exec}, {"fetch", p.Fetch, e.Fetch}} {
+		if f.limit > 0 && float64(f.ela)/1000 >= f.limit {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// override returns the limits of p with the ones set in o taking precedence.
+func (p Phases) override(o Phases) Phases {
+	if o.Parse > 0 {
+		p.Parse = o.Parse
+	}
+	if o.Exec > 0 {
+		p.Exec = o.Exec
+	}
+	if o.Fetch > 0 {
+		p.Fetch = o.Fetch
+	}
+	return p
+}
+
+// SQLOverride holds the thresholds of a SQL ID that don't go by the ones of its business tx.
+// Zero values go by the business tx.
+type SQLOverride struct {
+	ELAThreshold float64
+	Phases       Phases
+}
+
+// thresholdOf returns the threshold on the elapsed time of a SQL of the business tx.
+func (sw MonitoredSQL) thresholdOf(sqlID string) float64 {
+	if o, ok := sw.Overrides[sqlID]; ok && o.ELAThreshold > 0 {
+		return o.ELAThreshold
+	}
+	return sw.ELAThreshold
+}
+
+// findTx returns the business tx of a name or nil if there's none.
+func findTx(wantSQL []MonitoredSQL, busTxName string) *MonitoredSQL {
+	for i := range wantSQL {
+		if wantSQL[i].BusinessTxName == busTxName {
+			return &wantSQL[i]
+		}
+	}
+	return nil
+}
+
+// phasesOf returns the limits on the phases of an execution of a SQL of a business tx.
+func phasesOf(wantSQL []MonitoredSQL, busTxName, sqlID string) Phases {
+	sw := findTx(wantSQL, busTxName)
+	if sw == nil {
+		return Phases{}
+	}
+	return sw.Phases.override(sw.Overrides[sqlID].Phases)
+}
+
+// sqlInputFile is the structured (YAML or JSON) SQL input file, e.g.
+//
+//	transactions:
+//	- name: Order Entry
+//	  threshold: 1.5s
+//	  owner: oe-team
+//	  severity: critical
+//	  phases: {fetch: 500ms}
+//	  limits: {gets: 50000, r: 10000}
+//	  allowerrors: [ORA-00001]
+//	  slo: {target: 99.9, threshold: 2s, window: 28d}
+//	  sql:
+//	  - sqlid: acc988uzvjmmt
+//	    threshold: 150ms
+//	  - text: ^INSERT INTO OE\.ORDERS
+//	  - context: module=OE_ENTRY action=SUBMIT
+type sqlInputFile struct {
+	Transactions []sqlInputTx `yaml:"transactions" json:"transactions"`
+}
+
+// sqlInputTx is a business tx of the structured SQL input file.
+type sqlInputTx struct {
+	Name        string           `yaml:"name" json:"name"`
+	Threshold   millis           `yaml:"threshold" json:"threshold"`
+	Phases      sqlInputPhases   `yaml:"phases" json:"phases"`
+	Limits      map[string]int64 `yaml:"limits" json:"limits"`
+	AllowErrors []interface{}    `yaml:"allowerrors" json:"allowerrors"`
+	Owner       string           `yaml:"owner" json:"owner"`
+	Severity    string           `yaml:"severity" json:"severity"`
+	SLO         *sqlInputSLO     `yaml:"slo" json:"slo"`
+	SQL         []sqlInputSQL    `yaml:"sql" json:"sql"`
+}
+
+// sqlInputSQL is a SQL of a business tx of the structured SQL input file: one of a SQL ID,
+// a SQL text pattern or an application context rule.  The threshold and the phase limits
+// of a SQL ID override the ones of the business tx.
+type sqlInputSQL struct {
+	SQLID     string         `yaml:"sqlid" json:"sqlid"`
+	Text      string         `yaml:"text" json:"text"`
+	Context   string         `yaml:"context" json:"context"`
+	Threshold millis         `yaml:"threshold" json:"threshold"`
+	Phases    sqlInputPhases `yaml:"phases" json:"phases"`
+}
+
+// sqlInputPhases are the limits on the phases of the structured SQL input file.
+type sqlInputPhases struct {
+	Parse millis `yaml:"parse" json:"parse"`
+	Exec  millis `yaml:"exec" json:"exec"`
+	Fetch millis `yaml:"fetch" json:"fetch"`
+}
+
+// sqlInputSLO is the Service Level Objective of a business tx of the structured SQL input
+// file: the percentage of its executions (target) expected under a threshold over a window.
+type sqlInputSLO struct {
+	Target    float64 `yaml:"target" json:"target"`
+	Threshold millis  `yaml:"threshold" json:"threshold"`
+	Window    string  `yaml:"window" json:"window"`
+}
+
+// phases converts the limits on the phases.
+func (p sqlInputPhases) phases() Phases {
+	return Phases{Parse: float64(p.Parse), Exec: float64(p.Exec), Fetch: float64(p.Fetch)}
+}
+
+// loadStructuredSQL loads the business txs of a YAML or JSON SQL input file.
+func loadStructuredSQL(data []byte, format string) ([]MonitoredSQL, error) {
+	var f sqlInputFile
+	var err error
+	if format == sqlInputJSON {
+		err = json.Unmarshal(data, &f)
+	} else {
+		err = yaml.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loadStructuredSQL: can't parse the %s SQL input: %v", format, err)
+	}
+
+	var s []MonitoredSQL
+	for i, tx := range f.Transactions {
+		if tx.Name == "" {
+			return nil, fmt.Errorf("loadStructuredSQL: business tx #%d has no name", i+1)
+		}
+		if tx.Threshold <= 0 {
+			return nil, fmt.Errorf("loadStructuredSQL: business tx %s has no threshold", tx.Name)
+		}
+		if len(tx.SQL) == 0 {
+			return nil, fmt.Errorf("loadStructuredSQL: business tx %s has no SQL", tx.Name)
+		}
+		sw := MonitoredSQL{
+			BusinessTxName: tx.Name,
+			ELAThreshold:   float64(tx.Threshold),
+			Phases:         tx.Phases.phases(),
+			Owner:          tx.Owner,
+			Severity:       tx.Severity,
+		}
+
+		var limits []string
+		for name, value := range tx.Limits {
+			limits = append(limits, fmt.Sprintf("%s=%d", name, value))
+		}
+		sort.Strings(limits)
+		if sw.Limits, err = parseLimits(strings.Join(limits, " ")); err != nil {
+			return nil, fmt.Errorf("loadStructuredSQL: bad limits of %s: %v", tx.Name, err)
+		}
+		var allowErrors []string
+		for _, code := range tx.AllowErrors {
+			allowErrors = append(allowErrors, fmt.Sprint(code))
+		}
+		if sw.AllowedErrors, err = parseAllowedErrors(strings.Join(allowErrors, " ")); err != nil {
+			return nil, fmt.Errorf("loadStructuredSQL: bad allowed errors of %s: %v", tx.Name, err)
+		}
+		if tx.SLO != nil {
+			if sw.SLO, err = newObjective(tx.SLO.Target, float64(tx.SLO.Threshold), tx.SLO.Window, sw.ELAThreshold); err != nil {
+				return nil, fmt.Errorf("loadStructuredSQL: bad SLO of %s: %v", tx.Name, err)
+			}
+		}
+
+		for _, sql := range tx.SQL {
+			override := SQLOverride{ELAThreshold: float64(sql.Threshold), Phases: sql.Phases.phases()}
+			switch {
+			case sql.SQLID != "" && sql.Text == "" && sql.Context == "":
+				sw.SQLID = append(sw.SQLID, sql.SQLID)
+				if override != (SQLOverride{}) {
+					if sw.Overrides == nil {
+						sw.Overrides = make(map[string]SQLOverride)
+					}
+					sw.Overrides[sql.SQLID] = override
+				}
+				continue
+			case sql.Text != "" && sql.SQLID == "" && sql.Context == "":
+				re, err := regexp.Compile(sql.Text)
+				if err != nil {
+					return nil, fmt.Errorf("loadStructuredSQL: bad SQL text pattern of %s: %v", tx.Name, err)
+				}
+				sw.SQLText = append(sw.SQLText, re)
+			case sql.Context != "" && sql.SQLID == "" && sql.Text == "":
+				rule, err := parseContextRule(sql.Context)
+				if err != nil {
+					return nil, fmt.Errorf("loadStructuredSQL: bad application context rule of %s: %v", tx.Name, err)
+				}
+				sw.Context = append(sw.Context, rule)
+			default:
+				return nil, fmt.Errorf("loadStructuredSQL: each SQL of %s is expected to have one of sqlid, text or context", tx.Name)
+			}
+			if override != (SQLOverride{}) {
+				return nil, fmt.Errorf("loadStructuredSQL: the threshold of a SQL of %s can only be overridden for a sqlid", tx.Name)
+			}
+		}
+		s = append(s, sw)
+	}
+	return s, nil
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Loadsql_test runs unit tests on the structured (YAML and JSON) SQL input file.
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/slo"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseThreshold(t *testing.T) {
+	var testCases = []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{s: "150", want: 150},
+		{s: " 0.5 ", want: 0.5},
+		{s: "150ms", want: 150},
+		{s: "2s", want: 2000},
+		{s: "1.5s", want: 1500},
+		{s: "250us", want: 0.25},
+		{s: "0", wantErr: true},
+		{s: "-1s", wantErr: true},
+		{s: "2 seconds", wantErr: true},
+		{s: "", wantErr: true},
+	}
+
+	for _, ent := range testCases {
+		got, err := parseThreshold(ent.s)
+		if (err != nil) != ent.wantErr {
+			t.Errorf("parseThreshold(%q) error = %v, want an error: %v", ent.s, err, ent.wantErr)
+			continue
+		}
+		if got != ent.want {
+			t.Errorf("parseThreshold(%q) = %v, want %v", ent.s, got, ent.want)
+		}
+	}
+}
+
+func TestSQLInputFormat(t *testing.T) {
+	var testCases = []struct {
+		filename string
+		data     string
+		want     string
+	}{
+		{filename: "rtta.sqlinput", data: "# comment\nOrder Entry, 150, acc988uzvjmmt\n", want: sqlInputCSV},
+		{filename: "rtta.sqlinput", data: "\n# comment\ntransactions:\n- name: Order Entry\n", want: sqlInputYAML},
+		{filename: "rtta.sqlinput", data: "---\ntransactions: []\n", want: sqlInputYAML},
+		{filename: "rtta.sqlinput", data: "  {\"transactions\": []}\n", want: sqlInputJSON},
+		{filename: "rtta.sqlinput.json", data: "", want: sqlInputJSON},
+		{filename: "rtta.sqlinput.YML", data: "", want: sqlInputYAML},
+		{filename: "rtta.sqlinput", data: "", want: sqlInputCSV},
+	}
+
+	for _, ent := range testCases {
+		if got := sqlInputFormat(ent.filename, []byte(ent.data)); got != ent.want {
+			t.Errorf("sqlInputFormat(%q, %q) = %s, want %s", ent.filename, ent.data, got, ent.want)
+		}
+	}
+}
+
+func TestLoadStructuredSQL(t *testing.T) {
+	const sampleYAML = `# Order Entry and friends.
+transactions:
+- name: Order Entry
+  threshold: 1.5s
+  owner: oe-team
+  severity: critical
+  phases: {fetch: 500ms}
+  limits: {gets: 50000, r: 10000}
+  allowerrors: [1403, ORA-00001]
+  slo: {target: 99.9, window: 7d}
+  sql:
+  - sqlid: acc988uzvjmmt
+    threshold: 150ms
+    phases:
+      exec: 100
+  - sqlid: 7zt3ax6mmtrbq
+  - context: module=OE_ENTRY action=SUBMIT
+- name: EBS/Post GL
+  threshold: 10.5
+  sql:
+  - sqlid: qweabcl
+`
+	const sampleJSON = `{
+	"transactions": [
+		{
+			"name": "Order Entry",
+			"threshold": "1.5s",
+			"owner": "oe-team",
+			"severity": "critical",
+			"phases": {"fetch": "500ms"},
+			"limits": {"gets": 50000, "r": 10000},
+			"allowerrors": [1403, "ORA-00001"],
+			"slo": {"target": 99.9, "window": "7d"},
+			"sql": [
+				{"sqlid": "acc988uzvjmmt", "threshold": "150ms", "phases": {"exec": 100}},
+				{"sqlid": "7zt3ax6mmtrbq"},
+				{"context": "module=OE_ENTRY action=SUBMIT"}
+			]
+		},
+		{"name": "EBS/Post GL", "threshold": 10.5, "sql": [{"sqlid": "qweabcl"}]}
+	]
+}
+`
+	want := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   1500,
+			SQLID:          []string{"acc988uzvjmmt", "7zt3ax6mmtrbq"},
+			Context:        []ContextRule{{Module: "OE_ENTRY", Action: "SUBMIT"}},
+			Limits:         Limits{Gets: 50000, Rows: 10000},
+			AllowedErrors:  []int64{1403, 1},
+			Phases:         Phases{Fetch: 500},
+			Overrides:      map[string]SQLOverride{"acc988uzvjmmt": {ELAThreshold: 150, Phases: Phases{Exec: 100}}},
+			Owner:          "oe-team",
+			Severity:       "critical",
+			SLO:            &slo.Objective{Target: percent(99.9), Threshold: 1500, Window: 7 * 24 * time.Hour},
+		},
+		MonitoredSQL{
+			BusinessTxName: "EBS/Post GL",
+			ELAThreshold:   10.5,
+			SQLID:          []string{"qweabcl"},
+		},
+	}
+
+	// Auto-detected by the content, whatever the file name.
+	for format, data := range map[string]string{sqlInputYAML: sampleYAML, sqlInputJSON: sampleJSON} {
+		fh, err := ioutil.TempFile("", "TestLoadStructuredSQL")
+		if err != nil {
+			t.Fatalf("ioutil.TempFile() failed: couldn't open tmp file: %v", err)
+		}
+		defer func() {
+			fh.Close()
+			os.Remove(fh.Name())
+		}()
+		if _, err := fh.WriteString(data); err != nil {
+			t.Fatalf("file.Write() failed: couldn't write to tmp file: %v", err)
+		}
+
+		got, err := loadSQL(fh.Name())
+		if err != nil {
+			t.Errorf("loadSQL(%s) failed: %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("loadSQL(%s): -> diff -got +want\n%s", format, pretty.Compare(got, want))
+		}
+	}
+}
+
+func TestLoadStructuredSQLErrors(t *testing.T) {
+	var testCases = []struct {
+		name string
+		data string
+	}{
+		{name: "no name", data: "transactions:\n- threshold: 1s\n  sql: [{sqlid: acc988uzvjmmt}]\n"},
+		{name: "no threshold", data: "transactions:\n- name: Order Entry\n  sql: [{sqlid: acc988uzvjmmt}]\n"},
+		{name: "bad threshold", data: "transactions:\n- name: Order Entry\n  threshold: 1 sec\n  sql: [{sqlid: acc988uzvjmmt}]\n"},
+		{name: "no SQL", data: "transactions:\n- name: Order Entry\n  threshold: 1s\n"},
+		{name: "SQL ID and text", data: "transactions:\n- name: Order Entry\n  threshold: 1s\n  sql: [{sqlid: acc988uzvjmmt, text: ^SELECT}]\n"},
+		{name: "override of a text", data: "transactions:\n- name: Order Entry\n  threshold: 1s\n  sql: [{text: ^SELECT, threshold: 2s}]\n"},
+		{name: "bad limit", data: "transactions:\n- name: Order Entry\n  threshold: 1s\n  limits: {ela: 1}\n  sql: [{sqlid: acc988uzvjmmt}]\n"},
+		{name: "bad error", data: "transactions:\n- name: Order Entry\n  threshold: 1s\n  allowerrors: [snapshot too old]\n  sql: [{sqlid: acc988uzvjmmt}]\n"},
+		{name: "bad SLO", data: "transactions:\n- name: Order Entry\n  threshold: 1s\n  slo: {target: 200}\n  sql: [{sqlid: acc988uzvjmmt}]\n"},
+		{name: "bad YAML", data: "transactions:\n- name: [Order Entry\n"},
+	}
+
+	for _, ent := range testCases {
+		if got, err := loadStructuredSQL([]byte(ent.data), sqlInputYAML); err == nil {
+			t.Errorf("%s: loadStructuredSQL() = %+v, want an error", ent.name, got)
+		}
+	}
+}
+
+func TestParseRecordOverrides(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   100,
+			SQLID:          []string{"acc988uzvjmmt", "7zt3ax6mmtrbq"},
+			Phases:         Phases{Fetch: 30},
+			Overrides:      map[string]SQLOverride{"acc988uzvjmmt": {ELAThreshold: 20.5, Phases: Phases{Fetch: 50}}},
+		},
+	}
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+		// Over its own threshold of 20.5 ms, but within its own fetch limit of 50 ms.
+		"FETCH #3:c=0,e=40000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809237197\n",
+		"CLOSE #3:c=0,e=2,dep=0,type=0,tim=1409063809237200\n",
+		"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809240197 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+		"EXEC #4:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809250197\n",
+		// Within the threshold of the business tx, but over its fetch limit of 30 ms.
+		"FETCH #4:c=0,e=40000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809290197\n",
+		"CLOSE #4:c=0,e=2,dep=0,type=0,tim=1409063809290200\n",
+	}
+	type violation struct {
+		sqlID     string
+		threshold float64
+		breached  []string
+		execution cursor.Execution
+	}
+	want := []violation{
+		{sqlID: "acc988uzvjmmt", threshold: 20.5, breached: []string{"ela"}, execution: cursor.Execution{Exec: 10000, Fetch: 40000, Fetches: 1, IO: cursor.IO{CR: 10, Rows: 1}}},
+		{sqlID: "7zt3ax6mmtrbq", threshold: 100, breached: []string{"fetch"}, execution: cursor.Execution{Exec: 10000, Fetch: 40000, Fetches: 1, IO: cursor.IO{CR: 10, Rows: 1}}},
+	}
+
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []violation
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = append(got, violation{sqlID: pr.sqlID, threshold: pr.threshold, breached: pr.breached, execution: pr.execution})
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord(): -> diff -got +want\n%s", pretty.Compare(got, want))
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/miner"
+	"github.com/borisdali/rttanalyzer/otlp"
+	"github.com/borisdali/rttanalyzer/stats"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// DefaultOTLPInterval is how often the metrics are pushed to the OpenTelemetry collector by default.
+const DefaultOTLPInterval = time.Minute
+
+// otlpCloseTimeout caps the last push of the metrics on Close.
+var otlpCloseTimeout = 10 * time.Second
+
+// otlpScope is the instrumentation scope (and the service name) of the metrics.
+const otlpScope = "rttanalyzer"
+
+// OTLP provides specific implementation of the Dumper interface for OpenTelemetry.
+// The counts of all the executions are pushed to a collector over OTLP/HTTP every
+// Options.OTLPInterval, and right away on a violation.  The metrics are cumulative, so
+// a push that fails is made up for by the next one.
+type OTLP struct {
+	Generic
+	exporter *otlp.Exporter
+	start    time.Time     // Since when the counts are accumulated
+	push     chan struct{} // Asks for a push ahead of the interval
+	stop     chan struct{} // Closed by Close to have the metrics pushed one last time
+	done     chan struct{} // Closed once they are
+	stopOnce sync.Once
+}
+
+// Dump method specific to OTLP target.
+func (o *OTLP) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := o.state().parseRecord(traceRec, o.MonitoredSQLs, curTracker, o.Options)
+	if err != nil {
+		return err
+	}
+	return o.deliver(ctx, client, pr)
+}
+
+// deliver asks for a push ahead of the interval if a parsed record is a violation.
+func (o *OTLP) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	if !pr.isViolation || o.push == nil {
+		return nil
+	}
+	// The violation is only counted by now: a push is pending already if the channel is full.
+	select {
+	case o.push <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (o *OTLP) LoadSQL() error {
+	sql, err := mustLoadSQL(o.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> otlp.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	o.MonitoredSQLs = sql
+	return nil
+}
+
+// Start starts pushing the metrics to Options.OTLPEndpoint (otlp.DefaultEndpoint if not set)
+// in the background, until the context is done or the OTLP is closed, when the metrics are
+// pushed one last time.
+func (o *OTLP) Start(ctx context.Context) {
+	endpoint, interval := o.Options.OTLPEndpoint, o.Options.OTLPInterval
+	if endpoint == "" {
+		endpoint = otlp.DefaultEndpoint
+	}
+	if interval <= 0 {
+		interval = DefaultOTLPInterval
+	}
+	o.exporter = &otlp.Exporter{
+		Endpoint: endpoint,
+		Resource: []otlp.Attribute{{Key: "service.name", Value: otlpScope}},
+		Scope:    otlpScope,
+	}
+	o.start = time.Now()
+	o.push = make(chan struct{}, 1)
+	o.stop = make(chan struct{})
+	o.done = make(chan struct{})
+	fmt.Printf("[%v] info> pushing the metrics to the OpenTelemetry collector at %s every %v\n", time.Now().Format("2006-01-02 15:04:05"), endpoint, interval)
+	go func() {
+		defer close(o.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				o.last()
+				return
+			case <-o.stop:
+				o.last()
+				return
+			case <-ticker.C:
+			case <-o.push:
+			}
+			o.export(ctx)
+		}
+	}()
+}
+
+// Close stops pushing the metrics, returning once they are pushed one last time.
+func (o *OTLP) Close() error {
+	if o.done == nil {
+		return nil
+	}
+	o.stopOnce.Do(func() { close(o.stop) })
+	<-o.done
+	return nil
+}
+
+// export pushes the metrics as of now.
+func (o *OTLP) export(ctx context.Context) {
+	if err := o.exporter.Export(ctx, o.metrics(o.state().Stats(), time.Now())); err != nil {
+		fmt.Printf("[%v] warning> could not push the metrics to the OpenTelemetry collector: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+}
+
+// last pushes the metrics one last time, within otlpCloseTimeout.
+func (o *OTLP) last() {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpCloseTimeout)
+	defer cancel()
+	o.export(ctx)
+}
+
+// metrics converts the counts of the executions of the SQL IDs and, under metric names of their
+// own (rtta.business_tx.*), of the business txs along with the internal metrics of rtta to OTLP
+// metrics as of a time.  Elapsed times are in seconds.
+func (o *OTLP) metrics(entries []stats.Entry, now time.Time) []otlp.Metric {
+	var sqls, txs []stats.Entry
+	for _, e := range entries {
+		if e.SQLID == "" {
+			txs = append(txs, e)
+		} else {
+			sqls = append(sqls, e)
+		}
+	}
+	metrics := append(o.counts("rtta.", "monitored SQL", sqls, now), o.counts("rtta.business_tx.", "business txs", txs, now)...)
+
+	m := miner.Counts()
+	internal := func(name, description, unit string, kind int, value int64) otlp.Metric {
+		return otlp.Metric{Name: name, Description: description, Unit: unit, Kind: kind, Points: []otlp.NumberPoint{{Start: o.start, Time: now, Value: float64(value)}}}
+	}
+	return append(metrics,
+		internal("rtta.miners.active", "Miners currently reading a trace file.", "{miner}", otlp.KindGauge, m.Active),
+		internal("rtta.read.bytes", "Bytes of the trace records read.", "By", otlp.KindSum, m.BytesRead),
+		internal("rtta.parse.errors", "Trace records that couldn't be parsed.", "{record}", otlp.KindSum, ParseErrors()),
+	)
+}
+
+// counts converts the counts of the executions of the monitored SQL or of the business txs (of
+// what, for the description of the metrics) to OTLP metrics named with prefix as of a time.
+func (o *OTLP) counts(prefix, of string, entries []stats.Entry, now time.Time) []otlp.Metric {
+	executions := otlp.Metric{Name: prefix + "executions", Description: "Executions of the " + of + " that ended.", Unit: "{execution}", Kind: otlp.KindSum}
+	violations := otlp.Metric{Name: prefix + "violations", Description: "Executions of the " + of + " over their threshold or limits.", Unit: "{violation}", Kind: otlp.KindSum}
+	last := otlp.Metric{Name: prefix + "elapsed.last", Description: "Elapsed time of the execution of the " + of + " that ended last.", Unit: "s", Kind: otlp.KindGauge}
+	worst := otlp.Metric{Name: prefix + "elapsed.worst", Description: "Elapsed time of the longest execution of the " + of + ".", Unit: "s", Kind: otlp.KindGauge}
+	elapsed := otlp.Metric{Name: prefix + "elapsed", Description: "Elapsed times of the executions of the " + of + ".", Unit: "s", Kind: otlp.KindHistogram}
+
+	bounds := make([]float64, len(stats.Buckets))
+	for i, b := range stats.Buckets {
+		bounds[i] = b / 1000
+	}
+	for _, e := range entries {
+		attrs := o.attributes(e.Key)
+		executions.Points = append(executions.Points, otlp.NumberPoint{Attributes: attrs, Start: o.start, Time: now, Value: float64(e.Executions)})
+		violations.Points = append(violations.Points, otlp.NumberPoint{Attributes: attrs, Start: o.start, Time: now, Value: float64(e.Violations)})
+		last.Points = append(last.Points, otlp.NumberPoint{Attributes: attrs, Time: now, Value: e.LastELA / 1000})
+		worst.Points = append(worst.Points, otlp.NumberPoint{Attributes: attrs, Time: now, Value: e.WorstELA / 1000})
+		counts := make([]uint64, len(e.Histogram))
+		for i, n := range e.Histogram {
+			counts[i] = uint64(n)
+		}
+		elapsed.Histograms = append(elapsed.Histograms, otlp.HistogramPoint{Attributes: attrs, Start: o.start, Time: now, Count: uint64(e.Executions), Sum: e.TotalELA / 1000, Bounds: bounds, Counts: counts})
+	}
+	return []otlp.Metric{executions, violations, last, worst, elapsed}
+}
+
+// attributes returns the attributes of the counts of a key.
+func (o *OTLP) attributes(k stats.Key) []otlp.Attribute {
+	attrs := []otlp.Attribute{{Key: "db", Value: o.DBName}, {Key: "business_tx", Value: k.BusinessTxName}}
+	if k.SQLID != "" {
+		attrs = append(attrs, otlp.Attribute{Key: "sql_id", Value: k.SQLID})
+	}
+	return attrs
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Otlp_test runs unit tests on the metrics pushed to an OpenTelemetry collector.
+package sink
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/otlp"
+	"github.com/borisdali/rttanalyzer/stats"
+	"golang.org/x/net/context"
+)
+
+func TestOTLPPush(t *testing.T) {
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("receiver got %q on %s, want application/x-protobuf on /v1/metrics", r.Header.Get("Content-Type"), r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	// Pushes ahead of the interval are up to the violations.
+	o := &OTLP{Generic: testGeneric(Options{OTLPEndpoint: receiver.URL, OTLPInterval: time.Hour})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o.Start(ctx)
+
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+		"EXEC #3:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		"FETCH #3:c=0,e=50000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809267197\n",
+		"CLOSE #3:c=0,e=1,dep=0,type=0,tim=1409063809267198\n",
+	}
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	for _, rec := range recs[:4] {
+		if err := o.Dump(ctx, nil, nil, curTracker, rec); err != nil {
+			t.Fatalf("Dump(%q) failed: %v", rec, err)
+		}
+	}
+	select {
+	case body := <-bodies:
+		t.Errorf("Dump() pushed %q with no violation, want no push ahead of the interval", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := o.Dump(ctx, nil, nil, curTracker, recs[4]); err != nil {
+		t.Fatalf("Dump(%q) failed: %v", recs[4], err)
+	}
+	select {
+	case body := <-bodies:
+		for _, want := range []string{"service.name", "rtta.executions", "rtta.violations", "rtta.elapsed.worst", "rtta.parse.errors", "CLOUD2", "Order Entry", "acc988uzvjmmt"} {
+			if !bytes.Contains(body, []byte(want)) {
+				t.Errorf("the violation pushed %q, want it to carry %s", body, want)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Dump() of a violation pushed nothing")
+	}
+
+	// The counts since the last push are pushed as the OTLP is closed.
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	select {
+	case body := <-bodies:
+		if !bytes.Contains(body, []byte("rtta.executions")) {
+			t.Errorf("Close() pushed %q, want the metrics", body)
+		}
+	default:
+		t.Errorf("Close() returned with no last push")
+	}
+}
+
+func TestOTLPMetrics(t *testing.T) {
+	start := time.Unix(1485794600, 0)
+	now := time.Unix(1485794660, 0)
+	o := &OTLP{Generic: Generic{DBName: "CLOUD2"}, start: start}
+	histogram := make([]int64, len(stats.Buckets)+1)
+	histogram[3], histogram[6] = 1, 1
+	entries := []stats.Entry{
+		{Key: stats.Key{BusinessTxName: "Order Entry"}, Counts: stats.Counts{Executions: 3, Violations: 1, TotalELA: 90, LastELA: 10, WorstELA: 70, Histogram: histogram}},
+		{Key: stats.Key{BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt"}, Counts: stats.Counts{Executions: 2, Violations: 1, TotalELA: 80, LastELA: 70, WorstELA: 70, Histogram: histogram}},
+	}
+
+	metrics := o.metrics(entries, now)
+	byName := make(map[string]otlp.Metric)
+	for _, m := range metrics {
+		byName[m.Name] = m
+	}
+	attrs := []otlp.Attribute{{Key: "db", Value: "CLOUD2"}, {Key: "business_tx", Value: "Order Entry"}, {Key: "sql_id", Value: "acc988uzvjmmt"}}
+	txAttrs := attrs[:2]
+	wantPoints := []struct {
+		name  string
+		kind  int
+		point otlp.NumberPoint
+	}{
+		{"rtta.executions", otlp.KindSum, otlp.NumberPoint{Attributes: attrs, Start: start, Time: now, Value: 2}},
+		{"rtta.violations", otlp.KindSum, otlp.NumberPoint{Attributes: attrs, Start: start, Time: now, Value: 1}},
+		{"rtta.elapsed.last", otlp.KindGauge, otlp.NumberPoint{Attributes: attrs, Time: now, Value: 0.07}},
+		{"rtta.elapsed.worst", otlp.KindGauge, otlp.NumberPoint{Attributes: attrs, Time: now, Value: 0.07}},
+		// The business txs have metrics of their own, with no sql_id attribute.
+		{"rtta.business_tx.executions", otlp.KindSum, otlp.NumberPoint{Attributes: txAttrs, Start: start, Time: now, Value: 3}},
+		{"rtta.business_tx.violations", otlp.KindSum, otlp.NumberPoint{Attributes: txAttrs, Start: start, Time: now, Value: 1}},
+		{"rtta.business_tx.elapsed.last", otlp.KindGauge, otlp.NumberPoint{Attributes: txAttrs, Time: now, Value: 0.01}},
+		{"rtta.business_tx.elapsed.worst", otlp.KindGauge, otlp.NumberPoint{Attributes: txAttrs, Time: now, Value: 0.07}},
+	}
+	for _, ent := range wantPoints {
+		m := byName[ent.name]
+		if m.Kind != ent.kind || len(m.Points) != 1 || !reflect.DeepEqual(m.Points[0], ent.point) {
+			t.Errorf("metric %s = %+v, want a kind %d with %+v", ent.name, m, ent.kind, ent.point)
+		}
+	}
+
+	h := byName["rtta.elapsed"].Histograms
+	if len(h) != 1 || h[0].Count != 2 || h[0].Sum != 0.08 || h[0].Bounds[3] != 0.01 || h[0].Counts[3] != 1 || h[0].Counts[6] != 1 || len(h[0].Counts) != len(h[0].Bounds)+1 {
+		t.Errorf("metric rtta.elapsed = %+v, want 2 executions of 80 ms in the buckets up to 0.01 and 0.1 s", h)
+	}
+	if h := byName["rtta.business_tx.elapsed"].Histograms; len(h) != 1 || h[0].Count != 3 || !reflect.DeepEqual(h[0].Attributes, txAttrs) {
+		t.Errorf("metric rtta.business_tx.elapsed = %+v, want 3 executions of Order Entry", h)
+	}
+}
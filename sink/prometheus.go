@@ -0,0 +1,195 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/miner"
+	"github.com/borisdali/rttanalyzer/stats"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// DefaultMetricsAddr is the address the Prometheus output media serves /metrics on by default.
+const DefaultMetricsAddr = ":9161"
+
+// metricsPath is the path of the metrics for Prometheus to scrape.
+const metricsPath = "/metrics"
+
+// Prometheus provides specific implementation of the Dumper interface for Prometheus.
+// Rather than pushing the violations out, it serves the counts of all the executions on
+// /metrics (in the Prometheus text exposition format) for a Prometheus server to scrape.
+type Prometheus struct {
+	Generic
+}
+
+// Dump method specific to Prometheus target.
+// The executions are counted as their records are parsed, the metrics are rendered off the
+// counts on every scrape.
+func (p *Prometheus) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	_, err := p.state().parseRecord(traceRec, p.MonitoredSQLs, curTracker, p.Options)
+	return err
+}
+
+// deliver has nothing to do: the executions of a parsed record are counted already.
+func (p *Prometheus) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	return nil
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (p *Prometheus) LoadSQL() error {
+	sql, err := mustLoadSQL(p.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> prometheus.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	p.MonitoredSQLs = sql
+	return nil
+}
+
+// Serve starts serving the metrics on Options.MetricsAddr (DefaultMetricsAddr if not set) in
+// the background.  It returns once the address is listened on.
+func (p *Prometheus) Serve() error {
+	addr := p.Options.MetricsAddr
+	if addr == "" {
+		addr = DefaultMetricsAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Prometheus.Serve: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, p)
+	fmt.Printf("[%v] info> serving the metrics for Prometheus on http://%s%s\n", time.Now().Format("2006-01-02 15:04:05"), l.Addr(), metricsPath)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			fmt.Printf("[%v] error> the metrics for Prometheus are no longer served on %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), l.Addr(), err)
+		}
+	}()
+	return nil
+}
+
+// ServeHTTP renders the metrics.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	p.writeMetrics(&b, p.state().Stats())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := w.Write(b.Bytes()); err != nil && Debug {
+		fmt.Printf("[%v] dbg> prometheus.ServeHTTP: error writing the metrics to %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), r.RemoteAddr, err)
+	}
+}
+
+// writeMetrics renders the counts of the executions of the SQL IDs and, under metric names of
+// their own (rtta_business_tx_*), of the business txs along with the internal metrics of rtta.
+// Elapsed times are in seconds, as is customary for Prometheus.
+func (p *Prometheus) writeMetrics(b *bytes.Buffer, entries []stats.Entry) {
+	var sqls, txs []stats.Entry
+	for _, e := range entries {
+		if e.SQLID == "" {
+			txs = append(txs, e)
+		} else {
+			sqls = append(sqls, e)
+		}
+	}
+	p.writeCounts(b, "rtta_", "monitored SQL", sqls)
+	p.writeCounts(b, "rtta_business_tx_", "business txs", txs)
+
+	m, c := miner.Counts(), cursor.Counts()
+	internal := []struct {
+		name, typ, help string
+		value           int64
+	}{
+		{"rtta_active_miners", "gauge", "Miners currently reading a trace file.", m.Active},
+		{"rtta_read_bytes_total", "counter", "Bytes of the trace records read.", m.BytesRead},
+		{"rtta_parse_errors_total", "counter", "Trace records that couldn't be parsed.", ParseErrors()},
+		{"rtta_tracked_cursors", "gauge", "Cursors of the monitored SQL currently tracked.", c.Tracked},
+		{"rtta_evicted_cursors_total", "counter", "Cursors evicted by the maxcursors cap.", c.Evicted},
+	}
+	for _, i := range internal {
+		writeHeader(b, i.name, i.typ, i.help)
+		fmt.Fprintf(b, "%s %d\n", i.name, i.value)
+	}
+}
+
+// writeCounts renders the counts of the executions of the monitored SQL or of the business txs
+// (of what, for the help of the metrics) under metric names starting with prefix.
+func (p *Prometheus) writeCounts(b *bytes.Buffer, prefix, of string, entries []stats.Entry) {
+	families := []struct {
+		name, typ, help string
+		value           func(stats.Counts) float64
+	}{
+		{prefix + "executions_total", "counter", "Executions of the " + of + " that ended.", func(c stats.Counts) float64 { return float64(c.Executions) }},
+		{prefix + "violations_total", "counter", "Executions of the " + of + " over their threshold or limits.", func(c stats.Counts) float64 { return float64(c.Violations) }},
+		{prefix + "last_elapsed_seconds", "gauge", "Elapsed time of the execution of the " + of + " that ended last.", func(c stats.Counts) float64 { return c.LastELA / 1000 }},
+		{prefix + "worst_elapsed_seconds", "gauge", "Elapsed time of the longest execution of the " + of + ".", func(c stats.Counts) float64 { return c.WorstELA / 1000 }},
+	}
+	for _, f := range families {
+		writeHeader(b, f.name, f.typ, f.help)
+		for _, e := range entries {
+			fmt.Fprintf(b, "%s{%s} %s\n", f.name, p.labels(e.Key), formatFloat(f.value(e.Counts)))
+		}
+	}
+
+	histogram := prefix + "elapsed_seconds"
+	writeHeader(b, histogram, "histogram", "Elapsed times of the executions of the "+of+".")
+	for _, e := range entries {
+		labels := p.labels(e.Key)
+		var n int64
+		for i, le := range stats.Buckets {
+			n += e.Histogram[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", histogram, labels, formatFloat(le/1000), n)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", histogram, labels, e.Executions)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", histogram, labels, formatFloat(e.TotalELA/1000))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", histogram, labels, e.Executions)
+	}
+}
+
+// labels renders the labels of the counts of a key.
+func (p *Prometheus) labels(k stats.Key) string {
+	labels := fmt.Sprintf("db=\"%s\",business_tx=\"%s\"", labelValue(p.DBName), labelValue(k.BusinessTxName))
+	if k.SQLID != "" {
+		labels += fmt.Sprintf(",sql_id=\"%s\"", labelValue(k.SQLID))
+	}
+	return labels
+}
+
+// writeHeader renders the HELP and TYPE lines of a metric.
+func writeHeader(b *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labelEscaper escapes the characters that can't appear as is in a label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue escapes a label value.
+func labelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// formatFloat renders a sample value or a bucket bound.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Prometheus_test runs unit tests on the metrics served to Prometheus.
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"golang.org/x/net/context"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	p := &Prometheus{Generic: testGeneric(Options{})}
+	p.MonitoredSQLs[0].BusinessTxName = `Order "Entry"`
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+		// Ends the first execution (10 ms), then a violation (70 ms) ended by the CLOSE.
+		"EXEC #3:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		"FETCH #3:c=0,e=50000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809267197\n",
+		"CLOSE #3:c=0,e=1,dep=0,type=0,tim=1409063809267198\n",
+	}
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	for _, rec := range recs {
+		if err := p.Dump(context.Background(), nil, nil, curTracker, rec); err != nil {
+			t.Fatalf("Dump(%q) failed: %v", rec, err)
+		}
+	}
+
+	server := httptest.NewServer(p)
+	defer server.Close()
+	resp, err := http.Get(server.URL + metricsPath)
+	if err != nil {
+		t.Fatalf("http.Get(%s) failed: %v", metricsPath, err)
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", metricsPath, err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+
+	wantLines := []string{
+		"# TYPE rtta_executions_total counter",
+		`rtta_executions_total{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt"} 2`,
+		`rtta_violations_total{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt"} 1`,
+		`rtta_last_elapsed_seconds{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt"} 0.07`,
+		`rtta_worst_elapsed_seconds{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt"} 0.07`,
+		"# TYPE rtta_elapsed_seconds histogram",
+		`rtta_elapsed_seconds_bucket{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt",le="0.005"} 0`,
+		`rtta_elapsed_seconds_bucket{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt",le="0.01"} 1`,
+		`rtta_elapsed_seconds_bucket{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt",le="0.05"} 1`,
+		`rtta_elapsed_seconds_bucket{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt",le="0.1"} 2`,
+		`rtta_elapsed_seconds_bucket{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt",le="+Inf"} 2`,
+		`rtta_elapsed_seconds_sum{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt"} 0.08`,
+		`rtta_elapsed_seconds_count{db="CLOUD2",business_tx="Order \"Entry\"",sql_id="acc988uzvjmmt"} 2`,
+		// The business txs have metrics of their own, with no sql_id label.
+		"# TYPE rtta_business_tx_executions_total counter",
+		`rtta_business_tx_executions_total{db="CLOUD2",business_tx="Order \"Entry\""} 2`,
+		`rtta_business_tx_violations_total{db="CLOUD2",business_tx="Order \"Entry\""} 1`,
+		`rtta_business_tx_worst_elapsed_seconds{db="CLOUD2",business_tx="Order \"Entry\""} 0.07`,
+		"# TYPE rtta_business_tx_elapsed_seconds histogram",
+		`rtta_business_tx_elapsed_seconds_count{db="CLOUD2",business_tx="Order \"Entry\""} 2`,
+		"# TYPE rtta_active_miners gauge",
+		"# TYPE rtta_read_bytes_total counter",
+		"# TYPE rtta_parse_errors_total counter",
+	}
+	lines := make(map[string]bool)
+	for _, l := range strings.Split(string(out), "\n") {
+		lines[l] = true
+	}
+	for _, want := range wantLines {
+		if !lines[want] {
+			t.Errorf("%s has no line %s, got:\n%s", metricsPath, want, out)
+		}
+	}
+	// A series of a business tx is never mixed up with the ones of its SQL IDs.
+	for l := range lines {
+		if strings.HasPrefix(l, "rtta_executions_total{") && !strings.Contains(l, "sql_id=") {
+			t.Errorf("%s has a line %s, want the business tx counted under rtta_business_tx_executions_total", metricsPath, l)
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	var testCases = []struct {
+		value string
+		want  string
+	}{
+		{value: "Order Entry", want: "Order Entry"},
+		{value: `EBS\Month "End"`, want: `EBS\\Month \"End\"`},
+		{value: "two\nlines", want: `two\nlines`},
+	}
+
+	for _, ent := range testCases {
+		if got := labelValue(ent.value); got != ent.want {
+			t.Errorf("labelValue(%q) = %q, want %q", ent.value, got, ent.want)
+		}
+	}
+}
@@ -16,20 +16,26 @@ limitations under the License.
 package sink
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"log"
 	"golang.org/x/net/context"
+	"github.com/borisdali/rttanalyzer/baseline"
 	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/record"
+	"github.com/borisdali/rttanalyzer/slo"
+	"github.com/borisdali/rttanalyzer/stats"
 	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
 	bqgen "google.golang.org/api/bigquery/v2"
 	"cloud.google.com/go/pubsub"
@@ -40,66 +46,91 @@ const (
 	traceRecordTypeInvalid         = iota // Not a valid trace line
 	traceRecordTypeParsingInCursor        // Initial cursor parsing (that leads to "openning" a new cursor and adding it to the map)
 	traceRecordTypeParseExecFetch         // Actual PARSE, EXEC or FETCH cursor execution stages
+	traceRecordTypeSession                // "*** SESSION ID:" marker switching the session the following records belong to
+	traceRecordTypeWait                   // WAIT lines of a 10046 level 8 trace
+	traceRecordTypeBinds                  // BINDS header of a 10046 level 4 trace
+	traceRecordTypeBindDetail             // Indented Bind#n, value=, etc. lines following a BINDS header
+	traceRecordTypeStat                   // STAT lines with the row source operations of an execution plan
+	traceRecordTypeClose                  // CLOSE of a cursor, ending its execution in progress
+	traceRecordTypeXctend                 // XCTEND: end (commit or rollback) of a database transaction
+	traceRecordTypeContext                // "*** MODULE NAME:", "*** ACTION NAME:", etc. markers of the application context
+	traceRecordTypeTimestamp              // "*** 2017-01-30 16:43:20.123" wall clock time marker
+	traceRecordTypeError                  // ERROR and PARSE ERROR: an ORA- error raised by a cursor's call
 )
 
-var Debug bool
-var errExistingCursor = fmt.Errorf("existing-cursor")
+// Values of the Options.Binds switch.
+const (
+	BindsCapture = "capture" // Report the bind values of violating executions (the default)
+	BindsMask    = "mask"    // Report the bind variables, but not their values
+	BindsOff     = "off"     // Don't parse the BINDS blocks at all
+)
 
-// CursorTrackerProtected is a syncronization mechanism to access the CursorTracker map.
-type CursorTrackerProtected struct {
-	sync.RWMutex
-	Cursors map[int64]*cursor.Cursor
-}
+// Values of the Options.Evaluate switch.
+const (
+	EvaluateExecution = "execution" // Judge the total of all the calls of an execution (the default)
+	EvaluateCall      = "call"      // Judge each PARSE, EXEC and FETCH call on its own
+	// Judge the wall time from the first monitored SQL of a business transaction run by
+	// a session up to the commit (XCTEND rlbk=0) that ends it.
+	EvaluateTransaction = "transaction"
+)
 
-// Get performs a protected read of the underlying map.  It is safe to use from multiple
-// goroutines simultaneously.
-func (c *CursorTrackerProtected) get(key int64) *cursor.Cursor {
-	c.RLock()
-	defer c.RUnlock()
-	return c.Cursors[key]
-}
+// Values of the Options.Recursive switch.  Either way the recursive (dep>0) calls are
+// rolled up into the top-level (dep=0) call they are run on behalf of, as tkprof does,
+// and reported along with its violations.
+const (
+	RecursiveSeparate = "separate" // Monitor the recursive SQL on its own too (the default)
+	RecursiveRollup   = "rollup"   // Only monitor the top-level SQL
+)
 
-// hasValue performs a protected map lookup.  It is safe to use from multiple goroutines
-// simultaneously.
-func (c *CursorTrackerProtected) hasValue(key int64) bool {
-	c.RLock()
-	_, ok := c.Cursors[key]
-	c.RUnlock()
-	return ok
-}
+// maskedBindValue replaces bind values in the BindsMask mode.
+const maskedBindValue = "****"
+
+// topWaitEvents is the number of the longest wait events reported with a violation.
+const topWaitEvents = 3
 
-// set performs a protected write.  It is safe to use from multiple goroutines simultaneously.
-func (c *CursorTrackerProtected) set(key int64, value *cursor.Cursor) {
-	c.Lock()
-	c.Cursors[key] = value
-	c.Unlock()
+// idleWaits are the wait events that happen between the calls and so are not part of their elapsed time.
+var idleWaits = map[string]bool{
+	"SQL*Net message from client": true,
 }
 
-// delete performs a protected write, and is safe to use from multiple goroutines.
-func (c *CursorTrackerProtected) delete(key int64) {
-	c.Lock()
-	delete(c.Cursors, key)
-	c.Unlock()
+var Debug bool
+
+// planRegistry keeps the plan hash values each monitored SQL ran with, across the trace files.
+type planRegistry struct {
+	sync.Mutex
+	plans map[string]map[string]bool
 }
 
-// compareAndSet automically checks if a cursor is new or an existing one.
-// It a cursor is new, it then proceeds with "openning it" and "setting" it in the Cursor map.
-func (c *CursorTrackerProtected) compareAndSet(key int64, open func() (*cursor.Cursor, error)) (*cursor.Cursor, error) {
-	c.Lock()
-	defer c.Unlock()
-	if _, ok := c.Cursors[key]; ok {
-		if Debug { fmt.Printf("[%v] dbg> compareAndSet: existing cursor: ok=%v\n", time.Now().Format("2006-01-02 15:04:05"), ok) }
-		return nil, errExistingCursor
+// isNew records a plan hash value of a SQL ID, reporting whether it is new (the first one of a SQL ID is not).
+func (p *planRegistry) isNew(sqlID, planHash string) bool {
+	p.Lock()
+	defer p.Unlock()
+	seen, ok := p.plans[sqlID]
+	if !ok {
+		p.plans[sqlID] = map[string]bool{planHash: true}
+		return false
 	}
-	cur, err := open()
-	if err != nil {
-		return nil, fmt.Errorf("compareAndSet: error from calling open: %v", err)
+	if seen[planHash] {
+		return false
 	}
+	seen[planHash] = true
+	return true
+}
 
-	// c.set(key, cur): this would cause double locking due to lock/unlock in the set method.
-	c.Cursors[key] = cur
-	if Debug { fmt.Printf("[%v] dbg> c.Cursors[key]: key=%v, value=%v\n", time.Now().Format("2006-01-02 15:04:05"), key, c.Cursors[key]) }
-	return cur, nil
+// Options are the user tunables (set in rtta.conf) of the output media.
+type Options struct {
+	Binds        string             // One of BindsCapture, BindsMask or BindsOff
+	Evaluate     string             // One of EvaluateExecution, EvaluateCall or EvaluateTransaction
+	SQLText      int                // Number of characters of the SQL text included in violations, 0 for none
+	Recursive    string             // One of RecursiveSeparate or RecursiveRollup
+	Baseline     *baseline.Baseline // Learned baselines the executions are judged against once learned, nil for the static thresholds only
+	MetricsAddr  string             // Address the Prometheus output media serves /metrics on, e.g. :9161
+	OTLPEndpoint string             // OTLP/HTTP endpoint of the OpenTelemetry collector, e.g. http://localhost:4318
+	OTLPInterval time.Duration      // How often the metrics are pushed to the OpenTelemetry collector
+	Webhook      WebhookOptions     // Of the webhook output media
+	Syslog       SyslogOptions      // Of the syslog output media
+	Email        EmailOptions       // Of the email output media
+	Exec         ExecOptions        // Of the exec output media
 }
 
 // Generic hosts common members of all structs and is meant to reduce code duplication.
@@ -108,7 +139,9 @@ type Generic struct {
 	FileSQL       string
 	Client	      *pubsub.Client
 	MonitoredSQLs []MonitoredSQL
-	CursorTracker *CursorTrackerProtected
+	Options       Options
+	State         *State // What is learned off the trace files, a fresh one if not set
+	stateOnce     sync.Once
 }
 
 // Varz provides specific implemenation of the Dumper interface for Varz.
@@ -120,20 +153,47 @@ type Varz struct {
 }
 
 // Dump method specific to Varz target.
-func (v *Varz) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, traceRec string) error {
+func (v *Varz) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
 	// TODO(bdali): This method may perform a *lot* of IO and so it may need to be refactored.
 	// Spin up another goroutine that only dumps the varz line once every 30 seconds?
-	pr, err := parseRecord(traceRec, v.MonitoredSQLs, v.CursorTracker)
+	pr, err := v.state().parseRecord(traceRec, v.MonitoredSQLs, curTracker, v.Options)
 	if err != nil {
 		return err
 	}
+	return v.deliver(ctx, client, pr)
+}
+
+// deliver writes the varz lines of a parsed record.
+func (v *Varz) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	if pr.isNewPlan {
+		fileName := filepath.Join(v.Dir, v.FilePrefix+"."+v.DBName+"."+normalizeName(pr.businessTxName)+".newplan"+v.FileExtension)
+		varzMessage := fmt.Sprintf("rttanalyzer{id=%s,businesstxname=%q,sqlid=%s} map:plan planhash:%s eventtime:%s\n",
+			v.DBName, pr.businessTxName, pr.sqlID, pr.planHash, pr.when().Format(time.RFC3339Nano))
+		if Debug { fmt.Printf("[%v] dbg> varz=%v\n", time.Now().Format("2006-01-02 15:04:05"), varzMessage)}
+		ioutil.WriteFile(fileName, []byte(varzMessage), 0644)
+	}
+	if pr.isError {
+		fileName := filepath.Join(v.Dir, v.FilePrefix+"."+v.DBName+"."+normalizeName(pr.businessTxName)+".error"+v.FileExtension)
+		varzMessage := fmt.Sprintf("rttanalyzer{id=%s,businesstxname=%q,sqlid=%s} map:error error:ORA-%05d phase:%s planhash:%s eventtime:%s owner:%q severity:%q\n",
+			v.DBName, pr.businessTxName, pr.sqlID, pr.errorCode, pr.errorPhase, pr.planHash, pr.when().Format(time.RFC3339Nano), pr.owner, pr.severity)
+		if Debug { fmt.Printf("[%v] dbg> varz=%v\n", time.Now().Format("2006-01-02 15:04:05"), varzMessage)}
+		ioutil.WriteFile(fileName, []byte(varzMessage), 0644)
+	}
+	for _, a := range pr.burnAlerts {
+		fileName := filepath.Join(v.Dir, v.FilePrefix+"."+v.DBName+"."+normalizeName(pr.businessTxName)+".burn."+a.Name+v.FileExtension)
+		varzMessage := fmt.Sprintf("rttanalyzer{id=%s,businesstxname=%q,burnrate=%s} map:burn target:%g threshold:%.1f window:%s longrate:%.2f shortrate:%.2f good:%d bad:%d budgetleft:%.4f eventtime:%s owner:%q severity:%q\n",
+			v.DBName, pr.businessTxName, a.Name, a.Objective.Target, a.Objective.Threshold, a.Objective.Window, a.LongRate, a.ShortRate, a.Good, a.Bad, a.BudgetLeft, a.At.Format(time.RFC3339Nano), pr.owner, pr.severity)
+		if Debug { fmt.Printf("[%v] dbg> varz=%v\n", time.Now().Format("2006-01-02 15:04:05"), varzMessage)}
+		ioutil.WriteFile(fileName, []byte(varzMessage), 0644)
+	}
 	if !pr.isViolation {
 		return nil
 	}
 	// TODO(bdali): need to check/replace special characters with perhaps underscores.
 	fileName := filepath.Join(v.Dir, v.FilePrefix+"."+v.DBName+"."+normalizeName(pr.businessTxName)+v.FileExtension)
-	varzMessage := fmt.Sprintf("rttanalyzer{id=%s,businesstxname=%q,runtimethreshold=%.1f,sqlid=%s} map:stats lastela:%.3f worstela:%.3f violations:%d\n",
-		v.DBName, pr.businessTxName, pr.threshold, pr.sqlID, pr.lastELA, pr.worstELA, pr.numViolations)
+	counts := v.state().counts(pr.businessTxName)
+	varzMessage := fmt.Sprintf("rttanalyzer{id=%s,businesstxname=%q,runtimethreshold=%.1f,sqlid=%s} map:stats lastela:%.3f worstela:%.3f violations:%d phases:%q topwaits:%q binds:%q planhash:%s statements:%q sqltext:%q eventtime:%s io:%q breached:%q owner:%q severity:%q executions:%d avgela:%.3f violationratio:%.4f baseline:%q\n",
+		v.DBName, pr.businessTxName, pr.threshold, pr.sqlID, pr.lastELA, pr.worstELA, pr.numViolations, formatPhases(pr.execution), formatWaits(pr.topWaits), formatBinds(pr.binds), pr.planHash, formatStatements(pr.statements, pr.lastELA), pr.sqlText, pr.when().Format(time.RFC3339Nano), formatIO(pr.execution.IO), strings.Join(pr.breached, ","), pr.owner, pr.severity, counts.Executions, counts.AvgELA(), counts.ViolationRatio(), formatBaseline(pr.baseline))
 	out := []byte(varzMessage)
 	if Debug { fmt.Printf("[%v] dbg> varz=%v\n", time.Now().Format("2006-01-02 15:04:05"), varzMessage)}
 	ioutil.WriteFile(fileName, out, 0644)
@@ -169,18 +229,60 @@ type PubSub struct {
 }
 
 // Dump method specific to PubSub target.
-func (ps *PubSub) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, traceRec string) error {
-	pr, err := parseRecord(traceRec, ps.MonitoredSQLs, ps.CursorTracker)
+func (ps *PubSub) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := ps.state().parseRecord(traceRec, ps.MonitoredSQLs, curTracker, ps.Options)
 	if err != nil {
 		return err
 	}
-	if !pr.isViolation {
-		return nil
+	return ps.deliver(ctx, client, pr)
+}
+
+// deliver enqueues the events of a parsed record.
+func (ps *PubSub) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	for _, psMessage := range ps.payloads(pr) {
+		if err := rttpubsub.Enqueue(ctx, client, psMessage); err != nil {
+			return fmt.Errorf("sink.Dump for PubSub: error in calling rttpubsub.Enqueue: %v", err)
+		}
+	}
+	return nil
+}
+
+// payloads returns the events a trace record turned into as PayloadSummaries, its burn rate alerts first.
+func (g *Generic) payloads(pr *parsedSummary) []*rttpubsub.PayloadSummary {
+	dbName := g.DBName
+	var events []*rttpubsub.PayloadSummary
+	for _, a := range pr.burnAlerts {
+		events = append(events, &rttpubsub.PayloadSummary{
+			DB:             dbName,
+			IsBurnAlert:    true,
+			BusinessTxName: pr.businessTxName,
+			Threshold:      a.Objective.Threshold,
+			EnqueueTime:    time.Now(),
+			EventTime:      a.At,
+			Owner:          pr.owner,
+			Severity:       pr.severity,
+			BurnRate:       a.Name,
+			LongBurnRate:   a.LongRate,
+			ShortBurnRate:  a.ShortRate,
+			SLOTarget:      a.Objective.Target,
+			SLOWindow:      a.Objective.Window,
+			GoodCount:      a.Good,
+			BadCount:       a.Bad,
+			BudgetLeft:     a.BudgetLeft,
+		})
+	}
+	if !pr.isViolation && !pr.isNewPlan && !pr.isError {
+		return events
 	}
 
+	counts := g.state().counts(pr.businessTxName)
 	psMessage := &rttpubsub.PayloadSummary{
-		DB:             ps.DBName,
-		IsViolation:    true,
+		DB:             dbName,
+		IsViolation:    pr.isViolation,
+		IsNewPlan:      pr.isNewPlan,
+		IsError:        pr.isError,
+		ErrorCode:      pr.errorCode,
+		ErrorPhase:     pr.errorPhase,
 		BusinessTxName: pr.businessTxName,
 		Threshold:      pr.threshold,
 		SQLID:          pr.sqlID,
@@ -188,11 +290,56 @@ func (ps *PubSub) Dump(ctx context.Context, client *pubsub.Client, service *bqge
 		LastELA:        pr.lastELA,
 		NumViolations:  pr.numViolations,
 		EnqueueTime:    time.Now(),
+		EventTime:      pr.when(),
+		ParseELA:       float64(pr.execution.Parse) / 1000,
+		ExecELA:        float64(pr.execution.Exec) / 1000,
+		FetchELA:       float64(pr.execution.Fetch) / 1000,
+		Fetches:        pr.execution.Fetches,
+		TopWaits:       payloadWaits(pr.topWaits),
+		Binds:          payloadBinds(pr.binds),
+		PlanHash:       pr.planHash,
+		RowSource:      payloadRowSource(pr.rowSource),
+		Statements:     payloadStatements(pr.statements, pr.lastELA),
+		SQLText:        pr.sqlText,
+		BufferGets:     pr.execution.Gets(),
+		PhysReads:      pr.execution.PhysReads,
+		Rows:           pr.execution.Rows,
+		Misses:         pr.execution.Misses,
+		Breached:       pr.breached,
+		Owner:          pr.owner,
+		Severity:       pr.severity,
+		Executions:     counts.Executions,
+		AvgELA:         counts.AvgELA(),
+		ViolationRatio: counts.ViolationRatio(),
 	}
-	if err := rttpubsub.Enqueue(ctx, client, psMessage); err != nil {
-		return fmt.Errorf("sink.Dump for PubSub: error in calling rttpubsub.Enqueue: %v", err)
+	if pr.baseline != nil {
+		psMessage.BaselineP50 = pr.baseline.P50
+		psMessage.BaselinePTarget = pr.baseline.PTarget
+		psMessage.BaselinePercentile = pr.baseline.Percentile
+		psMessage.BaselineFactor = pr.baseline.Factor
 	}
-	return nil
+	return append(events, psMessage)
+}
+
+// Kinds of the events, e.g. as told by the MSGID of a syslog message.
+const (
+	EventViolation = "violation"
+	EventNewPlan   = "newplan"
+	EventError     = "error"
+	EventBurn      = "burn"
+)
+
+// eventKind returns the kind of an event.  A violation that happened with a new plan is a violation.
+func eventKind(p *rttpubsub.PayloadSummary) string {
+	switch {
+	case p.IsBurnAlert:
+		return EventBurn
+	case p.IsError:
+		return EventError
+	case p.IsViolation:
+		return EventViolation
+	}
+	return EventNewPlan
 }
 
 // LoadSQL uploads user-provided mapping of business transactions to SQL statements.
@@ -206,43 +353,191 @@ func (ps *PubSub) LoadSQL() error {
 	return nil
 }
 
-// Streamz provides specific implementation of the Dumper interface for Monarch's StreamZ.
-// Not implemented yet..
-type Streamz struct {
-	Generic
-	// Streamz specific attributes go here..
+// sqlTextPrefix marks the SQL text patterns among the SQL IDs of a business tx, e.g. text:^INSERT INTO OE\.ORDERS
+const sqlTextPrefix = "text:"
+
+// ContextRule matches the SQLs run under an application context, e.g. module=OE_ENTRY action=SUBMIT
+// in the SQL input file.  Empty attributes match any value.
+type ContextRule struct {
+	Module   string
+	Action   string
+	ClientID string
+	Service  string
+	SQLID    string
 }
 
-// Dump method specific to Streamz target.
-func (s *Streamz) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, traceRec string) error {
-	pr, err := parseRecord(traceRec, s.MonitoredSQLs, s.CursorTracker)
-	if err != nil {
-		return err
+// matches reports whether or not a SQL parsed under an application context matches the rule.
+func (r ContextRule) matches(sqlID string, ctx cursor.Context) bool {
+	for _, v := range []struct{ want, got string }{{r.Module, ctx.Module}, {r.Action, ctx.Action}, {r.ClientID, ctx.ClientID}, {r.Service, ctx.Service}, {r.SQLID, sqlID}} {
+		if v.want != "" && v.want != v.got {
+			return false
+		}
 	}
-	if !pr.isViolation {
-		return nil
+	return true
+}
+
+// parseContextRule parses the key=value pairs of a ContextRule, e.g. module='JDBC Thin Client' action=SUBMIT
+func parseContextRule(field string) (ContextRule, error) {
+	var r ContextRule
+	for field = strings.TrimSpace(field); field != ""; field = strings.TrimSpace(field) {
+		eq := strings.Index(field, "=")
+		if eq <= 0 {
+			return r, fmt.Errorf("parseContextRule: expected key=value, got %q", field)
+		}
+		key, value := field[:eq], field[eq+1:]
+		if strings.HasPrefix(value, "'") {
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return r, fmt.Errorf("parseContextRule: unterminated quote in %q", field)
+			}
+			value, field = value[1:end+1], value[end+2:]
+		} else if sp := strings.Index(value, " "); sp >= 0 {
+			value, field = value[:sp], value[sp:]
+		} else {
+			field = ""
+		}
+		switch key {
+		case "module":
+			r.Module = value
+		case "action":
+			r.Action = value
+		case "clientid":
+			r.ClientID = value
+		case "service":
+			r.Service = value
+		case "sqlid":
+			r.SQLID = value
+		default:
+			return r, fmt.Errorf("parseContextRule: unknown key %q, expected one of module, action, clientid, service or sqlid", key)
+		}
 	}
+	if r == (ContextRule{}) {
+		return r, fmt.Errorf("parseContextRule: empty rule")
+	}
+	return r, nil
+}
 
-	// TODO(bdali): Not finished..
-	return nil
+// limitPrefix marks the I/O and rows limits among the SQL IDs of a business tx, e.g. limit:gets=50000 r=10000
+const limitPrefix = "limit:"
+
+// dimensionELA is the name of the elapsed time dimension of a violation.
+const dimensionELA = "ela"
+
+// Limits caps the I/O and rows of an execution (or a call with EvaluateCall). 0 means no limit.
+// The names of the limits are the ones used in the SQL input file and in the violations.
+type Limits struct {
+	Gets      int64 // gets: buffer gets, i.e. cr + cu
+	CR        int64 // cr: consistent reads
+	CU        int64 // cu: current mode reads
+	PhysReads int64 // p: physical reads
+	Rows      int64 // r: rows processed
+	Misses    int64 // mis: library cache misses
 }
 
-// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
-func (s *Streamz) LoadSQL() error {
-	sql, err := mustLoadSQL(s.FileSQL)
-	if err != nil {
-		return err
+// fields lists the limits by name along with the stat they apply to.
+func (l *Limits) fields(io cursor.IO) []struct {
+	name  string
+	limit *int64
+	value int64
+} {
+	return []struct {
+		name  string
+		limit *int64
+		value int64
+	}{{"gets", &l.Gets, io.Gets()}, {"cr", &l.CR, io.CR}, {"cu", &l.CU, io.CU}, {"p", &l.PhysReads, io.PhysReads}, {"r", &l.Rows, io.Rows}, {"mis", &l.Misses, io.Misses}}
+}
+
+// breached returns the names of the limits the I/O stats are over.
+func (l Limits) breached(io cursor.IO) []string {
+	var names []string
+	for _, f := range l.fields(io) {
+		if *f.limit > 0 && f.value > *f.limit {
+			names = append(names, f.name)
+		}
 	}
-	if Debug { fmt.Printf("[%v] dbg> streamz.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)}
-	s.MonitoredSQLs = sql
-	return nil
+	return names
+}
+
+// parseLimits parses the name=value limits of the SQL input file, e.g. gets=50000 r=10000
+func parseLimits(field string) (Limits, error) {
+	var l Limits
+	for _, kv := range strings.Fields(field) {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return l, fmt.Errorf("parseLimits: expected name=value, got %q", kv)
+		}
+		n, err := strconv.ParseInt(kv[i+1:], 10, 64)
+		if err != nil || n <= 0 {
+			return l, fmt.Errorf("parseLimits: the limit of %s is expected to be a positive number, got %q", kv[:i], kv[i+1:])
+		}
+		var found bool
+		for _, f := range l.fields(cursor.IO{}) {
+			if f.name == kv[:i] {
+				*f.limit, found = n, true
+			}
+		}
+		if !found {
+			return l, fmt.Errorf("parseLimits: unknown limit %q, expected one of gets, cr, cu, p, r or mis", kv[:i])
+		}
+	}
+	return l, nil
+}
+
+// limitsOf returns the I/O and rows limits of a business tx.
+func limitsOf(wantSQL []MonitoredSQL, busTxName string) Limits {
+	for _, sw := range wantSQL {
+		if sw.BusinessTxName == busTxName {
+			return sw.Limits
+		}
+	}
+	return Limits{}
+}
+
+// allowErrorsPrefix marks the errors expected of a business tx among its SQL IDs, e.g. allowerrors:1403 ORA-00001
+const allowErrorsPrefix = "allowerrors:"
+
+// parseAllowedErrors parses a space separated list of error numbers, with or without their ORA- prefix.
+func parseAllowedErrors(field string) ([]int64, error) {
+	var codes []int64
+	for _, code := range strings.Fields(field) {
+		n, err := strconv.ParseInt(strings.TrimPrefix(strings.ToUpper(code), "ORA-"), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("parseAllowedErrors: expected an error number, got %q", code)
+		}
+		codes = append(codes, n)
+	}
+	return codes, nil
+}
+
+// isAllowedError reports whether or not an error is expected of a business tx.
+func isAllowedError(wantSQL []MonitoredSQL, busTxName string, code int64) bool {
+	for _, sw := range wantSQL {
+		if sw.BusinessTxName != busTxName {
+			continue
+		}
+		for _, allowed := range sw.AllowedErrors {
+			if allowed == code {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // MonitoredSQL lists SQL statements that belong to each Business Tx of interest.
 type MonitoredSQL struct {
 	BusinessTxName string
-	ELAThreshold   int64
+	ELAThreshold   float64 // Milliseconds
 	SQLID          []string
+	SQLText        []*regexp.Regexp       // Patterns of the SQL text, for SQLs whose SQL_ID is not stable
+	Context        []ContextRule          // Application contexts whose SQLs belong to the business tx
+	Limits         Limits                 // Limits on the I/O and rows of an execution, on top of ELAThreshold
+	AllowedErrors  []int64                // Errors expected of the business tx, not to be reported
+	Phases         Phases                 // Limits on the PARSE, EXEC and FETCH phases of an execution
+	Overrides      map[string]SQLOverride // Thresholds of the SQL IDs that don't go by the ones of the business tx
+	Owner          string                 // Who to turn to about the business tx, e.g. a team
+	Severity       string                 // How bad its violations are, e.g. critical
+	SLO            *slo.Objective         // Service Level Objective of the business tx, if any
 	LastELA        float64
 	WorstELA       float64
 	NumViolations  int64
@@ -254,13 +549,16 @@ func loadSQL(filename string) ([]MonitoredSQL, error) {
 	var s []MonitoredSQL
 	// TODO(bdali): add a check if sqlInput is a fully qualified path as opposed to relative
 	// and in this case do not join with Dir:
-	fh, err := os.Open(filepath.Join(rttanalyzer.Dir(), filename))
-	// fh, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filepath.Join(rttanalyzer.Dir(), filename))
+	// data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	if format := sqlInputFormat(filename, data); format != sqlInputCSV {
+		return loadStructuredSQL(data, format)
+	}
 
-	r := csv.NewReader(fh)
+	r := csv.NewReader(bytes.NewReader(data))
 	r.FieldsPerRecord = -1
 	r.TrimLeadingSpace = true
 	r.Comment = '#'
@@ -272,14 +570,58 @@ func loadSQL(filename string) ([]MonitoredSQL, error) {
 		if err != nil {
 			return nil, err
 		}
-		elaThres, err := strconv.Atoi(record[1])
+		if len(record) < 3 {
+			return nil, fmt.Errorf("loadSQL: expected a business tx name, a threshold and at least one SQL ID, got %q", record)
+		}
+		elaThres, err := parseThreshold(record[1])
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("loadSQL: bad threshold of %s: %v", record[0], err)
+		}
+		var sqlIDs []string
+		var sqlText []*regexp.Regexp
+		var context []ContextRule
+		var limits Limits
+		var allowedErrors []int64
+		var objective *slo.Objective
+		for _, sql := range record[2:] {
+			switch {
+			case strings.HasPrefix(sql, sloPrefix):
+				if objective, err = parseSLO(sql[len(sloPrefix):], elaThres); err != nil {
+					return nil, fmt.Errorf("loadSQL: bad SLO of %s: %v", record[0], err)
+				}
+			case strings.HasPrefix(sql, allowErrorsPrefix):
+				if allowedErrors, err = parseAllowedErrors(sql[len(allowErrorsPrefix):]); err != nil {
+					return nil, fmt.Errorf("loadSQL: bad allowed errors of %s: %v", record[0], err)
+				}
+			case strings.HasPrefix(sql, limitPrefix):
+				if limits, err = parseLimits(sql[len(limitPrefix):]); err != nil {
+					return nil, fmt.Errorf("loadSQL: bad limits of %s: %v", record[0], err)
+				}
+			case strings.HasPrefix(sql, sqlTextPrefix):
+				re, err := regexp.Compile(sql[len(sqlTextPrefix):])
+				if err != nil {
+					return nil, fmt.Errorf("loadSQL: bad SQL text pattern of %s: %v", record[0], err)
+				}
+				sqlText = append(sqlText, re)
+			case strings.Contains(sql, "="):
+				rule, err := parseContextRule(sql)
+				if err != nil {
+					return nil, fmt.Errorf("loadSQL: bad application context rule of %s: %v", record[0], err)
+				}
+				context = append(context, rule)
+			default:
+				sqlIDs = append(sqlIDs, sql)
+			}
 		}
 		s = append(s, MonitoredSQL{
 			BusinessTxName: record[0],
-			ELAThreshold:   int64(elaThres),
-			SQLID:          record[2:],
+			ELAThreshold:   elaThres,
+			SQLID:          sqlIDs,
+			SQLText:        sqlText,
+			Context:        context,
+			Limits:         limits,
+			AllowedErrors:  allowedErrors,
+			SLO:            objective,
 		})
 	}
 	return s, nil
@@ -294,13 +636,129 @@ type parsedSummary struct {
 	worstELA       float64
 	lastELA        float64
 	numViolations  int64
+	execution      cursor.Execution
+	topWaits       []cursor.Wait
+	binds          []cursor.Bind
+	isNewPlan      bool
+	planHash       string
+	rowSource      []cursor.RowSource
+	statements     []cursor.Statement // Statements of a business transaction or recursive SQL of an execution
+	sqlText        string
+	eventTime      time.Time         // Wall clock time of the event as told by the trace file, if known
+	breached       []string          // Dimensions (dimensionELA or the names of Limits) over their limits
+	isError        bool              // An ORA- error raised by a monitored SQL
+	errorCode      int64             // Error number, e.g. 1555 for ORA-01555
+	errorPhase     string            // Call (PARSE, EXEC or FETCH) the error was raised in, if known
+	owner          string            // Owner of the business tx, if set in the SQL input file
+	severity       string            // Severity of the business tx, if set in the SQL input file
+	burnAlerts     []slo.Alert       // Burn rate alerts on the error budget of the business tx
+	baseline       *baseline.Learned // Baseline of the SQL ID the violation was judged against, if learned
+}
+
+// isEvent reports whether a trace record turned into an event to deliver.
+func (pr *parsedSummary) isEvent() bool {
+	return pr.isViolation || pr.isNewPlan || pr.isError || len(pr.burnAlerts) > 0
+}
+
+// when returns the time of an event as told by the trace file, or the current time.
+func (pr *parsedSummary) when() time.Time {
+	if pr.eventTime.IsZero() {
+		return time.Now()
+	}
+	return pr.eventTime
+}
+
+// formatPhases renders the per phase breakdown of an execution's elapsed time [ms].
+func formatPhases(e cursor.Execution) string {
+	return fmt.Sprintf("parse=%.3f,exec=%.3f,fetch=%.3f/%d", float64(e.Parse)/1000, float64(e.Exec)/1000, float64(e.Fetch)/1000, e.Fetches)
+}
+
+// formatBaseline renders the learned baseline [ms] a violation was judged against, if any.
+func formatBaseline(l *baseline.Learned) string {
+	if l == nil {
+		return ""
+	}
+	return fmt.Sprintf("p50=%.3f,p%g=%.3f,factor=%g,executions=%d", l.P50, l.Percentile, l.PTarget, l.Factor, l.Executions)
+}
+
+// formatIO renders the I/O stats of an execution.
+func formatIO(io cursor.IO) string {
+	return fmt.Sprintf("gets=%d,cr=%d,cu=%d,p=%d,r=%d,mis=%d", io.Gets(), io.CR, io.CU, io.PhysReads, io.Rows, io.Misses)
+}
+
+// formatWaits renders wait events as a comma separated list of name=ela[ms]/count.
+func formatWaits(waits []cursor.Wait) string {
+	var s []string
+	for _, w := range waits {
+		s = append(s, fmt.Sprintf("%s=%.3f/%d", w.Name, float64(w.ELA)/1000, w.Count))
+	}
+	return strings.Join(s, ",")
 }
 
-// openCursor parses a trace record, gets other attributes and "opens" a cursor
+// payloadWaits converts wait events to their Pub/Sub message representation.
+func payloadWaits(waits []cursor.Wait) []rttpubsub.WaitEvent {
+	var pw []rttpubsub.WaitEvent
+	for _, w := range waits {
+		pw = append(pw, rttpubsub.WaitEvent{Name: w.Name, TotalELA: float64(w.ELA) / 1000, Count: w.Count})
+	}
+	return pw
+}
+
+// formatBinds renders bind values as :position=value pairs, numbered from 1 as in the SQL text.
+func formatBinds(binds []cursor.Bind) string {
+	var s []string
+	for _, b := range binds {
+		s = append(s, fmt.Sprintf(":%d=%s", b.Position+1, b.Value))
+	}
+	return strings.Join(s, ",")
+}
+
+// payloadBinds converts bind values to their Pub/Sub message representation.
+func payloadBinds(binds []cursor.Bind) []rttpubsub.Bind {
+	var pb []rttpubsub.Bind
+	for _, b := range binds {
+		pb = append(pb, rttpubsub.Bind{Position: b.Position + 1, Value: b.Value})
+	}
+	return pb
+}
+
+// formatStatements renders the statements of a business transaction as sqlid=ela[ms]/share[%] pairs.
+func formatStatements(statements []cursor.Statement, txELA float64) string {
+	var s []string
+	for _, st := range payloadStatements(statements, txELA) {
+		s = append(s, fmt.Sprintf("%s=%.3f/%.1f%%", st.SQLID, st.ELA, st.Share))
+	}
+	return strings.Join(s, ",")
+}
+
+// payloadStatements converts the statements of a business transaction to their Pub/Sub message representation.
+func payloadStatements(statements []cursor.Statement, txELA float64) []rttpubsub.Statement {
+	var ps []rttpubsub.Statement
+	for _, st := range statements {
+		elaF := float64(st.ELA) / 1000
+		var share float64
+		if txELA > 0 {
+			share = elaF / txELA * 100
+		}
+		ps = append(ps, rttpubsub.Statement{SQLID: st.SQLID, ELA: elaF, Calls: st.Calls, Share: share})
+	}
+	return ps
+}
+
+// payloadRowSource converts a row source tree to its Pub/Sub message representation.
+func payloadRowSource(rowSource []cursor.RowSource) []rttpubsub.RowSource {
+	var prs []rttpubsub.RowSource
+	for _, rs := range rowSource {
+		prs = append(prs, rttpubsub.RowSource{ID: rs.ID, ParentID: rs.ParentID, Operation: rs.Operation, Rows: rs.Rows, CR: rs.CR, Time: float64(rs.Time) / 1000})
+	}
+	return prs
+}
+
+// openCursor gets other attributes of a PARSING IN CURSOR record and "opens" a cursor
 // by instantiating a new cursor variable that is used to create a new tracker map entry.
-func openCursor(rec string, getCursorID int64, getSQLID, businessTxName string, elaThreshold int64) (*cursor.Cursor, error) {
-	// To open a cursor we are to get/parse the other cursor attributes.
-	otherAttr, err := parseOtherAttr(rec)
+func openCursor(r *record.Record, getCursorID int64, getSQLID, businessTxName string, elaThreshold float64) (*cursor.Cursor, error) {
+	// To open a cursor we are to get the other cursor attributes.
+	otherAttr, err := parseOtherAttr(r)
 	if err != nil {
 		return nil, err
 	}
@@ -317,6 +775,38 @@ func traceRecordType(rec string) int {
 	if strings.HasPrefix(rec, "PARSE #") || strings.HasPrefix(rec, "EXEC #") || strings.HasPrefix(rec, "FETCH #") {
 		return traceRecordTypeParseExecFetch
 	}
+	if strings.HasPrefix(rec, "*** SESSION ID:(") {
+		return traceRecordTypeSession
+	}
+	if strings.HasPrefix(rec, "WAIT #") {
+		return traceRecordTypeWait
+	}
+	if strings.HasPrefix(rec, "BINDS #") {
+		return traceRecordTypeBinds
+	}
+	if strings.HasPrefix(rec, " ") {
+		return traceRecordTypeBindDetail
+	}
+	if strings.HasPrefix(rec, "STAT #") {
+		return traceRecordTypeStat
+	}
+	if strings.HasPrefix(rec, "CLOSE #") {
+		return traceRecordTypeClose
+	}
+	if strings.HasPrefix(rec, "XCTEND ") {
+		return traceRecordTypeXctend
+	}
+	if strings.HasPrefix(rec, "ERROR #") || strings.HasPrefix(rec, "PARSE ERROR #") {
+		return traceRecordTypeError
+	}
+	if strings.HasPrefix(rec, "*** ") && len(rec) > 4 && rec[4] >= '0' && rec[4] <= '9' {
+		return traceRecordTypeTimestamp
+	}
+	for _, name := range contextMarkers {
+		if strings.HasPrefix(rec, "*** "+name+":(") {
+			return traceRecordTypeContext
+		}
+	}
 	// There are likely be more cases in the future when we introduce detailed logging.
 	return traceRecordTypeInvalid
 }
@@ -326,17 +816,18 @@ func traceRecordType(rec string) int {
 // This function returns a newCursor (bool) to signal whether or not a new cursor has been opened.
 // If an existing cursor has been used instead, it also returns a cursor ID (getCursorID int64), a SQL
 // that this cursor was opened for (getSQLID string), a Business Tx in question that this SQL works for
-// (businessTxName string) and the monitoring threshold on the SQL elapsed time (elaThreshold int64).
-func parsingInCursor(rec string, wantSQL []MonitoredSQL, curTracker *CursorTrackerProtected) (newCursor bool, getCursorID int64, getSQLID, businessTxName string, elaThreshold int64, err error) {
+// (businessTxName string), the monitoring threshold on the SQL elapsed time (elaThreshold float64) and
+// whether or not the business tx is told by the application context of each call (byContext bool).
+func parsingInCursor(rec string, r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker) (newCursor bool, getCursorID int64, getSQLID, businessTxName string, elaThreshold float64, byContext bool, err error) {
 	// Quick minimal parse just to get the SQL ID and Cursor# (the rest may not be needed for majority of trace records)
 	// if the SQL ID is not the one of interest.
-	getSQLID, getCursorString, err := parseSQLID(rec)
+	getSQLID, getCursorString, err := parseSQLID(r)
 	if err != nil {
-		return false, 0, "", "", 0, err
+		return false, 0, "", "", 0, false, err
 	}
 	getCursorInt, err := strconv.Atoi(getCursorString)
 	if err != nil {
-		return false, 0, "", "", 0, fmt.Errorf("parsingInCursor: error in CursorID string->int conversion [SQLID=%s]: %v", getSQLID, err)
+		return false, 0, "", "", 0, false, fmt.Errorf("parsingInCursor: error in CursorID string->int conversion [SQLID=%s]: %v", getSQLID, err)
 	}
 	getCursorID = int64(getCursorInt)
 	if Debug { fmt.Printf("[%v] dbg> getSQLID=%v, getCursorID=%d\n", time.Now().Format("2006-01-02 15:04:05"), getSQLID, getCursorID)}
@@ -344,30 +835,50 @@ func parsingInCursor(rec string, wantSQL []MonitoredSQL, curTracker *CursorTrack
 	// Is the parsed cursor for our SQL ID of interest?
 	var isInterestingSQL bool
 	isInterestingSQL, businessTxName, elaThreshold = interestingSQL(getSQLID, wantSQL)
-	if !isInterestingSQL {
+	// Any other SQL may belong to a business tx defined by application context rules: the cursor
+	// may be parsed under one context and executed under another, so the context of each call tells.
+	if !isInterestingSQL && hasContext(wantSQL) {
+		byContext = true
+		_, businessTxName, elaThreshold = interestingContext(getSQLID, curTracker.Context(), wantSQL)
+	}
+	if !isInterestingSQL && !byContext {
 		if Debug { fmt.Printf("[%v] dbg> parsingInCursor: a valid trace record containing PARSING IN CURSOR keywords, but not the SQL ID of interest(getSQLID=%v, wantSQL=%v): %v. Skipping..\n", time.Now().Format("2006-01-02 15:04:05"), getSQLID, wantSQL, strings.Replace(rec, "\n", "", 1))}
-		return true, -1, "", "", -1, nil
+		// A cursor# that was open for a SQL of interest now belongs to another SQL. Cursors
+		// matched by their SQL text (rather than SQL ID) stay put as long as their SQL ID does.
+		if cur := curTracker.Get(getCursorID); cur != nil && cur.SQLID != getSQLID {
+			curTracker.Delete(getCursorID)
+		}
+		return true, -1, "", "", -1, false, nil
 	}
 
 	// So we are parsing a cursor for a SQL of interest. Is the cursor already "Open"?
 	// If not-> open a cursor. If yes-> check if the cursor is open for our SQL.
-	fmt.Printf("[%v] info> interesting SQL found: %s (BusinessTxName=%s, ELA Threshold=%v)\n", time.Now().Format("2006-01-02 15:04:05"), getSQLID, businessTxName, elaThreshold)
+	if byContext {
+		if Debug { fmt.Printf("[%v] dbg> parsingInCursor: SQL %s is tracked by the application context of its calls (BusinessTxName=%q for now)\n", time.Now().Format("2006-01-02 15:04:05"), getSQLID, businessTxName)}
+	} else {
+		fmt.Printf("[%v] info> interesting SQL found: %s (BusinessTxName=%s, ELA Threshold=%v)\n", time.Now().Format("2006-01-02 15:04:05"), getSQLID, businessTxName, elaThreshold)
+	}
 
-	// Replace "if !curTracker.hasValue(getCursorID) {" test with the one below with stronger atomicity guarantees:
-	_, err = curTracker.compareAndSet(getCursorID, func() (*cursor.Cursor, error) {
-		return openCursor(rec, getCursorID, getSQLID, businessTxName, elaThreshold)
+	// Replace "if !curTracker.HasValue(getCursorID) {" test with the one below with stronger atomicity guarantees:
+	_, err = curTracker.CompareAndSet(getCursorID, func() (*cursor.Cursor, error) {
+		cur, err := openCursor(r, getCursorID, getSQLID, businessTxName, elaThreshold)
+		if err != nil {
+			return nil, err
+		}
+		cur.ByContext = byContext
+		return cur, nil
 	})
 
-	if err == errExistingCursor {
+	if err == cursor.ErrExistingCursor {
 		fmt.Printf("[%v] info> parsingInCursor: existingCursor\n", time.Now().Format("2006-01-02 15:04:05"))
-		return false, getCursorID, getSQLID, businessTxName, elaThreshold, nil
+		return false, getCursorID, getSQLID, businessTxName, elaThreshold, byContext, nil
 	}
 	if err != nil {
 		fmt.Printf("[%v] error> parsingInCursor: unexpected error in a new cursor.\n", time.Now().Format("2006-01-02 15:04:05"))
-		return true, -1, "", "", -1, err
+		return true, -1, "", "", -1, false, err
 	}
 	fmt.Printf("[%v] info> parsingInCursor: New cursor# %v. Open for SQLID=%v, BusinessTxName=%s: %s\n", time.Now().Format("2006-01-02 15:04:05"), getCursorID, getSQLID, businessTxName, rec)
-	return true, -1, "", "", -1, nil
+	return true, -1, "", "", -1, false, nil
 }
 
 // parseRecord dissects the trace record, extract a cursor# and SQL ID.
@@ -377,41 +888,236 @@ func parsingInCursor(rec string, wantSQL []MonitoredSQL, curTracker *CursorTrack
 // Next we are ready to receive PARSE|EXEC|FETCH trace records for the previously opened cursor.
 // Get the run time of each execution phase and compare against business tx. thresholds.
 // Record a violation if that threshold is crossed.
-func parseRecord(rec string, wantSQL []MonitoredSQL, curTracker *CursorTrackerProtected) (*parsedSummary, error) {
+func (st *State) parseRecord(rec string, wantSQL []MonitoredSQL, curTracker *cursor.Tracker, opts Options) (*parsedSummary, error) {
+	p := &pending{rec: rec, wantSQL: wantSQL}
+	pr, err := st.summarize(rec, wantSQL, curTracker, opts, p)
+	if err != nil {
+		atomic.AddInt64(&parseErrors, 1)
+		return nil, err
+	}
+	return p.finish(pr), nil
+}
+
+// pending holds what the summary of a record is completed with once the record is parsed.
+type pending struct {
+	rec        string
+	wantSQL    []MonitoredSQL
+	eventTime  time.Time      // Of the record as told by the trace file, if known
+	parseErr   *parsedSummary // Of a PARSE ERROR whose SQL text ends with the record
+	burnAlerts []slo.Alert    // Set off by the executions that end with the record
+	burnTxName string         // Business tx of those executions
+}
+
+// finish completes the summary of a record with its burn rate alerts, PARSE ERROR, time and business tx metadata.
+func (p *pending) finish(pr *parsedSummary) *parsedSummary {
+	if pr == nil {
+		return nil
+	}
+	if len(p.burnAlerts) > 0 {
+		pr.burnAlerts = append(pr.burnAlerts, p.burnAlerts...)
+		if pr.businessTxName == "" {
+			pr.businessTxName = p.burnTxName
+		}
+	}
+	// The error of a PARSE ERROR rides along with the record, unless it's an event of its own.
+	if p.parseErr != nil && p.parseErr.isError {
+		if pr.isViolation || pr.isNewPlan || pr.isError || (pr.businessTxName != "" && pr.businessTxName != p.parseErr.businessTxName) {
+			fmt.Printf("[%v] warning> ORA-%05d raised during the PARSE phase of %s is not reported: %q is an event of its own\n", time.Now().Format("2006-01-02 15:04:05"), p.parseErr.errorCode, p.parseErr.businessTxName, p.rec)
+		} else {
+			p.parseErr.burnAlerts = pr.burnAlerts
+			*pr = *p.parseErr
+		}
+	}
+	// Events are stamped with the wall clock time of the record (if it can be told from the trace) rather than
+	// with the time they are parsed at, which may be way later, e.g. when catching up after a restart.
+	if !p.eventTime.IsZero() && pr.isEvent() {
+		pr.eventTime = p.eventTime
+	}
+	// Events carry the descriptive metadata of their business tx.
+	if pr.businessTxName != "" {
+		if sw := findTx(p.wantSQL, pr.businessTxName); sw != nil {
+			pr.owner, pr.severity = sw.Owner, sw.Severity
+		}
+	}
+	return pr
+}
+
+// summarize parses a record for parseRecord, leaving what its summary is completed with in p.
+func (st *State) summarize(rec string, wantSQL []MonitoredSQL, curTracker *cursor.Tracker, opts Options, p *pending) (*parsedSummary, error) {
 	recValidClassifier := traceRecordType(rec)
+	// The SQL text that follows PARSING IN CURSOR may span many lines, up to END OF STMT.
+	// A trace record in the midst of it means that END OF STMT has been missed, except for
+	// the SQL text that follows PARSE ERROR: it has no END OF STMT and ends with the record.
+	if curTracker.InSQLText() {
+		if strings.HasPrefix(rec, "END OF STMT") {
+			block := curTracker.EndSQLText()
+			if block != nil && block.ParseErr != 0 {
+				return parseErrorText(block, wantSQL, opts), nil
+			}
+			endSQLText(block, wantSQL, curTracker)
+			return &parsedSummary{}, nil
+		}
+		if recValidClassifier == traceRecordTypeInvalid || recValidClassifier == traceRecordTypeBindDetail {
+			curTracker.AddSQLText(strings.TrimSuffix(rec, "\n"))
+			return &parsedSummary{}, nil
+		}
+		if block := curTracker.EndSQLText(); block != nil && block.ParseErr != 0 {
+			p.parseErr = parseErrorText(block, wantSQL, opts)
+		} else {
+			if Debug { fmt.Printf("[%v] dbg> parseRecord: no END OF STMT before %q\n", time.Now().Format("2006-01-02 15:04:05"), rec)}
+		}
+	}
+	if recValidClassifier != traceRecordTypeBindDetail && curTracker.BindsCursor() != nil {
+		curTracker.EndBinds()
+	}
+	// The records of the cursors (and XCTEND) are tokenized once here for all the helpers below.
+	var r *record.Record
+	if hasFields(recValidClassifier) {
+		var err error
+		if r, err = record.Parse(rec); err != nil {
+			return nil, fmt.Errorf("parseRecord: %v", err)
+		}
+	}
+	if tim := parseTim(r); tim > 0 {
+		p.eventTime = curTracker.EventTime(tim)
+	}
 	if Debug { fmt.Printf("[%v] dbg> parseRecord: traceRecordType=%d\n", time.Now().Format("2006-01-02 15:04:05"), recValidClassifier)}
 	switch recValidClassifier {
 	case traceRecordTypeInvalid:
 		if Debug { fmt.Printf("[%v] dbg> parseRecord: not a valid trace record of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), rec)}
+	case traceRecordTypeTimestamp:
+		wall, err := parseMarkerTime(rec[len("*** "):])
+		if err != nil {
+			return nil, err
+		}
+		curTracker.MarkTime(wall)
+	case traceRecordTypeSession:
+		sessionID, err := parseSessionID(rec)
+		if err != nil {
+			return nil, err
+		}
+		if wall, err := parseMarkerTime(rec[strings.LastIndex(rec, ")")+1:]); err == nil {
+			curTracker.MarkTime(wall)
+		}
+		if Debug { fmt.Printf("[%v] dbg> parseRecord: trace %s switched to session %s\n", time.Now().Format("2006-01-02 15:04:05"), curTracker.TraceName, sessionID)}
+		curTracker.SetSession(sessionID)
+	case traceRecordTypeContext:
+		name, value, err := parseContext(rec)
+		if err != nil {
+			return nil, err
+		}
+		if Debug { fmt.Printf("[%v] dbg> parseRecord: %s of session %s set to %q\n", time.Now().Format("2006-01-02 15:04:05"), name, curTracker.Session(), value)}
+		curTracker.SetContext(name, value)
+	case traceRecordTypeWait:
+		isKnown, cursorID, name, ela, err := parseWait(r, curTracker)
+		if err != nil {
+			return nil, err
+		}
+		if !isKnown || idleWaits[name] {
+			return &parsedSummary{}, nil
+		}
+		curTracker.Get(cursorID).AddWait(name, ela)
+	case traceRecordTypeBinds:
+		if opts.Binds == BindsOff {
+			return &parsedSummary{}, nil
+		}
+		curTracker.StartBinds(r.Cursor)
+	case traceRecordTypeBindDetail:
+		cur := curTracker.BindsCursor()
+		if cur == nil {
+			return &parsedSummary{}, nil
+		}
+		if err := parseBind(rec, cur, opts.Binds == BindsMask); err != nil {
+			return nil, err
+		}
+	case traceRecordTypeStat:
+		isKnown, cursorID, rs, err := parseStat(r, curTracker)
+		if err != nil {
+			return nil, err
+		}
+		if !isKnown {
+			return &parsedSummary{}, nil
+		}
+		curTracker.Get(cursorID).AddRowSource(*rs)
+	case traceRecordTypeClose:
+		// Whatever the type of the close (hard or into the session cursor cache), the cursor#
+		// is released by the application and the cursor is retired from the tracker.
+		cursorID := r.Cursor
+		if !curTracker.HasValue(cursorID) {
+			return &parsedSummary{}, nil
+		}
+		// The execution in progress, if any, ends with the cursor and is judged as a whole.
+		var violation *parsedSummary
+		if cur := curTracker.Get(cursorID); cur.LastCall() != "" && cur.BusinessTxName != "" {
+			done := cur.Execution()
+			var err error
+			if violation, err = st.judgeExecution(wantSQL, cur, &done, cur.TopWaits(topWaitEvents), cur.Binds(), opts); err != nil {
+				return nil, err
+			}
+			p.burnTxName = cur.BusinessTxName
+			p.burnAlerts = st.endExecution(r, wantSQL, curTracker, cur, done, opts)
+		}
+		// The cursor# may be reused for another SQL from now on: a new PARSING IN CURSOR follows then.
+		curTracker.Close(cursorID)
+		if Debug { fmt.Printf("[%v] dbg> parseRecord: cursor# %v closed, %d cursors left in %s (rec=%s)\n", time.Now().Format("2006-01-02 15:04:05"), cursorID, curTracker.Len(), curTracker.TraceName, rec)}
+		if violation != nil {
+			return violation, nil
+		}
+	case traceRecordTypeError:
+		return parseError(r, wantSQL, curTracker, opts)
+	case traceRecordTypeXctend:
+		if opts.Evaluate != EvaluateTransaction {
+			return &parsedSummary{}, nil
+		}
+		return st.endTransaction(r, wantSQL, curTracker)
 	case traceRecordTypeParsingInCursor:
-		newCursor, getCursorID, getSQLID, businessTxName, elaThreshold, err := parsingInCursor(rec, wantSQL, curTracker)
+		cursorID, depth, err := setDepth(r, curTracker)
+		if err != nil {
+			return nil, err
+		}
+		if depth > 0 && opts.Recursive == RecursiveRollup {
+			if Debug { fmt.Printf("[%v] dbg> parseRecord: recursive SQL is only rolled up into its top-level call: %s\n", time.Now().Format("2006-01-02 15:04:05"), rec)}
+			// The cursor# may have been open for a top-level SQL of interest before.
+			curTracker.Delete(cursorID)
+			// Its SQL text is skipped all the same, up to END OF STMT.
+			curTracker.StartSQLText(&cursor.SQLTextBlock{Key: cursorID})
+			return &parsedSummary{}, nil
+		}
+		newCursor, getCursorID, getSQLID, businessTxName, elaThreshold, byContext, err := parsingInCursor(rec, r, wantSQL, curTracker)
 		if err != nil {
 			return nil, fmt.Errorf("parseRecord: error from calling parsingInCursor: %v", err)
 		}
+		// Once the cursor is (re)opened below, read the SQL text that follows the record.
+		defer startSQLText(r, wantSQL, curTracker)
 
 		if !newCursor {
-			if Debug { fmt.Printf("[%v] dbg> curTracker.cursors[getCursorID]=%v\n", time.Now().Format("2006-01-02 15:04:05"), curTracker.get(getCursorID)) }
-			openSQLID := curTracker.get(getCursorID).SQLID
+			if Debug { fmt.Printf("[%v] dbg> curTracker.cursors[getCursorID]=%v\n", time.Now().Format("2006-01-02 15:04:05"), curTracker.Get(getCursorID)) }
+			openSQLID := curTracker.Get(getCursorID).SQLID
 			if getSQLID == openSQLID {
-				if Debug { fmt.Printf("[%v] dbg> parseRecord: cursor# %v is already open for our SQLID=%v, BusTxName=%v. Skipping.. rec=%s\n", time.Now().Format("2006-01-02 15:04:05"), getCursorID, getSQLID, curTracker.get(getCursorID).BusinessTxName, rec)}
+				if Debug { fmt.Printf("[%v] dbg> parseRecord: cursor# %v is already open for our SQLID=%v, BusTxName=%v. Skipping.. rec=%s\n", time.Now().Format("2006-01-02 15:04:05"), getCursorID, getSQLID, curTracker.Get(getCursorID).BusinessTxName, rec)}
 				// TODO(bdali): enhance to count the number of parses.
 				return &parsedSummary{}, nil
 			}
 
 			// Close the old cursor (for a different SQL ID) and open a new one (for the right SQL ID).
 			// TODO(bdali): this may race; protect it similar to compareAndSet.
-			curTracker.delete(getCursorID)
-			cur, err := openCursor(rec, getCursorID, getSQLID, businessTxName, elaThreshold)
+			curTracker.Delete(getCursorID)
+			cur, err := openCursor(r, getCursorID, getSQLID, businessTxName, elaThreshold)
 			if err != nil {
 				return nil, err
 			}
-			curTracker.set(getCursorID, cur)
+			cur.ByContext = byContext
+			curTracker.Set(getCursorID, cur)
 			if Debug { fmt.Printf("[%v] dbg> parseRecord: cursor# %v is already open, but for a different SQL. Close and Reopen for SQLID=%v, BusTxName=%s (rec=%s)\n", time.Now().Format("2006-01-02 15:04:05"), getCursorID, getSQLID, businessTxName, rec)}
 		}
 
 	case traceRecordTypeParseExecFetch:
 		if Debug{ fmt.Printf("[%v] dbg> parse|exec|fetch record: %s\n", time.Now().Format("2006-01-02 15:04:05"), rec)}
-		isKnown, cursorID, cursorType, cpu, ela, err := parseExec(rec, curTracker)
+		children, err := rollUpRecursive(r, curTracker)
+		if err != nil {
+			return nil, err
+		}
+		isKnown, cursorID, cursorType, cpu, ela, io, err := parseExec(r, curTracker)
 		if err != nil {
 			return nil, err
 		}
@@ -420,37 +1126,84 @@ func parseRecord(rec string, wantSQL []MonitoredSQL, curTracker *CursorTrackerPr
 			return &parsedSummary{}, nil
 		}
 
-		curTemp := curTracker.get(cursorID)
+		curTemp := curTracker.Get(cursorID)
+		curTemp.Call(cursorType, cpu, ela, io)
+		curTemp.AddRecursive(children)
+		// By default an execution (its PARSE, EXEC and all the FETCHes) is judged as a whole
+		// once it ends, i.e. with the PARSE or EXEC that starts the next one (or with CLOSE).
+		var violation *parsedSummary
+		if done, ok := curTemp.Finished(); ok && curTemp.BusinessTxName != "" {
+			if violation, err = st.judgeExecution(wantSQL, curTemp, &done, curTemp.FinishedTopWaits(topWaitEvents), curTemp.FinishedBinds(), opts); err != nil {
+				return nil, err
+			}
+			p.burnTxName = curTemp.BusinessTxName
+			p.burnAlerts = st.endExecution(r, wantSQL, curTracker, curTemp, done, opts)
+		}
 
-		threshold := float64(curTemp.ELAThreshold)
-		elaF := float64(ela) / 1000
-		cpuF := float64(cpu) / 1000
-		if elaF < threshold {
-			fmt.Printf("[%v] info> %s [SQL_ID=%s] ran for %.3f [ms] (cpu=%.3f [ms]) during %s phase (threshold of %.3f [ms])\n", time.Now().Format("2006-01-02 15:04:05"), curTemp.BusinessTxName, curTemp.SQLID, elaF, cpuF, cursorType, threshold)
+		// A plan hash value of 0 means that there's no plan (yet), e.g. for a PARSE of a PL/SQL block.
+		// Traces prior to 11g have no plh= at all.
+		if planHash := r.Str("plh"); planHash != "" && planHash != "0" {
+			curTemp.SetPlanHash(planHash)
+		}
+		// The business tx of a SQL told by the application context is the one of the context of
+		// the call, whatever the context the cursor was parsed (or the previous call was made) under.
+		if curTemp.ByContext {
+			_, curTemp.BusinessTxName, curTemp.ELAThreshold = interestingContext(curTemp.SQLID, curTracker.Context(), wantSQL)
+		}
+		if curTemp.BusinessTxName == "" {
+			if Debug { fmt.Printf("[%v] dbg> parseRecord: the call of cursor# %v is made under an application context of no interest (rec=%v)\n", time.Now().Format("2006-01-02 15:04:05"), cursorID, rec)}
+			if violation != nil {
+				return violation, nil
+			}
 			return &parsedSummary{}, nil
 		}
-
-		// TODO(bdali): Printing is not logging. Need to look into a proper logging solution in the future:
-		fmt.Printf("[%v] warning> %s [SQL_ID=%s] ran for %.3f [ms] (cpu=%.3f [ms]) during %s phase (threshold of %.3f [ms]): \n", time.Now().Format("2006-01-02 15:04:05"), curTemp.BusinessTxName, curTemp.SQLID, elaF, cpuF, cursorType, threshold)
-
-		worstELA, lastELA, numViolations, err := setViolations(wantSQL, curTemp.BusinessTxName, threshold, curTemp.SQLID, elaF)
-
-		if err != nil {
-			fmt.Printf("[%v] error> could not set the violations: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
-			return nil, err
+		// A plan change with the record that ends a violation is only checked with the next call,
+		// so that the new plan of the next execution isn't mixed up with the violation.
+		var newPlan *parsedSummary
+		if planHash := curTemp.PlanHash(); violation == nil && curTemp.PlanChanged() && st.plans.isNew(curTemp.SQLID, planHash) {
+			fmt.Printf("[%v] warning> %s [SQL_ID=%s] started running with a new plan hash value %s\n", time.Now().Format("2006-01-02 15:04:05"), curTemp.BusinessTxName, curTemp.SQLID, planHash)
+			newPlan = &parsedSummary{
+				isNewPlan:      true,
+				businessTxName: curTemp.BusinessTxName,
+				threshold:      curTemp.ELAThreshold,
+				sqlID:          curTemp.SQLID,
+				planHash:       planHash,
+				sqlText:        truncateSQLText(curTemp.SQLText(), opts.SQLText),
+			}
+		}
+		noViolation := &parsedSummary{}
+		if newPlan != nil {
+			noViolation = newPlan
 		}
-		fmt.Printf("[%v] info> lastela:%.3f worstela:%.3f violations:%d\n", time.Now().Format("2006-01-02 15:04:05"), lastELA, worstELA, numViolations)
-		if Debug { fmt.Printf("[%v] dbg> parseRecord: wantSQL=%v\n", time.Now().Format("2006-01-02 15:04:05"), wantSQL)}
 
-		return &parsedSummary{
-			isViolation:    true,
-			businessTxName: curTemp.BusinessTxName,
-			threshold:      threshold,
-			sqlID:          curTemp.SQLID,
-			worstELA:       worstELA,
-			lastELA:        lastELA,
-			numViolations:  numViolations,
-		}, nil
+		switch opts.Evaluate {
+		case EvaluateTransaction:
+			// In the transaction mode the statements are only judged together once the transaction ends.
+			tim, err := r.Int("tim")
+			if err != nil {
+				return nil, fmt.Errorf("parseRecord: can't get tim of a call: %v (rec=%q)", err, rec)
+			}
+			curTracker.AddToTransaction(curTemp, tim-ela, ela)
+			return noViolation, nil
+		case EvaluateCall:
+			// In the call mode each call is judged on its own, the execution is only flagged for its counts.
+			execution := callExecution(cursorType, cpu, ela, io)
+			execution.Recursive = children
+			violation, err := st.judge(wantSQL, curTemp, execution, "during its "+cursorType+" call", curTemp.TopWaits(topWaitEvents), curTemp.Binds(), opts)
+			if err != nil {
+				return nil, err
+			}
+			if violation == nil {
+				return noViolation, nil
+			}
+			curTemp.MarkViolated()
+			violation.isNewPlan = newPlan != nil
+			return violation, nil
+		}
+		if violation == nil {
+			return noViolation, nil
+		}
+		return violation, nil
 
 	default:
 		return nil, fmt.Errorf("unknown trace record type: %v", recValidClassifier)
@@ -458,74 +1211,217 @@ func parseRecord(rec string, wantSQL []MonitoredSQL, curTracker *CursorTrackerPr
 	return &parsedSummary{}, nil
 }
 
-// parseSQLID parses a PARSE IN CURSOR record to extract a SQL ID and Cursor#.
-func parseSQLID(rec string) (string, string, error) {
-	words := strings.Fields(rec)
-	if len(words) <= 12 {
-		return "", "", fmt.Errorf("parseSQLID: expected number of words is 12. Got %d instead (words=%v)", len(words), words)
+// judgeExecution judges an ended execution of a cursor, unless the calls or the transactions are judged instead.
+func (st *State) judgeExecution(wantSQL []MonitoredSQL, cur *cursor.Cursor, e *cursor.Execution, topWaits []cursor.Wait, binds []cursor.Bind, opts Options) (*parsedSummary, error) {
+	if opts.Evaluate == EvaluateCall || opts.Evaluate == EvaluateTransaction {
+		return nil, nil
 	}
-	return strings.Replace(words[12][7:], "'", "", 2), words[3][1:], nil
+	violation, err := st.judge(wantSQL, cur, *e, "over its execution", topWaits, binds, opts)
+	if violation != nil {
+		e.MarkViolated()
+	}
+	return violation, err
 }
 
-type parsedOtherAttr struct {
-	hashValue string
-	length    int
-	depth     int
-	uID       int
-	lID       int
-	oct       int
+// judge checks an execution (or a call, as told by phase) of a cursor against its threshold and limits.
+func (st *State) judge(wantSQL []MonitoredSQL, cur *cursor.Cursor, execution cursor.Execution, phase string, topWaits []cursor.Wait, binds []cursor.Bind, opts Options) (*parsedSummary, error) {
+	// Once the baseline of the SQL ID is learned, it takes over from the static threshold.
+	threshold := cur.ELAThreshold
+	var learned *baseline.Learned
+	if opts.Baseline != nil {
+		if l, ok := opts.Baseline.Get(cur.SQLID); ok {
+			threshold, learned = l.Limit, &l
+		}
+	}
+	elaF := float64(execution.ELA()) / 1000
+	cpuF := float64(execution.CPU) / 1000
+	var breached []string
+	if elaF >= threshold {
+		breached = append(breached, dimensionELA)
+	}
+	breached = append(breached, phasesOf(wantSQL, cur.BusinessTxName, cur.SQLID).breached(execution)...)
+	breached = append(breached, limitsOf(wantSQL, cur.BusinessTxName).breached(execution.IO)...)
+	if len(breached) == 0 {
+		fmt.Printf("[%v] info> %s [SQL_ID=%s] ran for %.3f [ms] (cpu=%.3f [ms]) %s (threshold of %.3f [ms])\n", time.Now().Format("2006-01-02 15:04:05"), cur.BusinessTxName, cur.SQLID, elaF, cpuF, phase, threshold)
+		return nil, nil
+	}
+
+	// TODO(bdali): Printing is not logging. Need to look into a proper logging solution in the future:
+	fmt.Printf("[%v] warning> %s [SQL_ID=%s] ran for %.3f [ms] (cpu=%.3f [ms]) %s (threshold of %.3f [ms]): %s %s binds: %s plh: %s io: %s breached: %s baseline: %s\n", time.Now().Format("2006-01-02 15:04:05"), cur.BusinessTxName, cur.SQLID, elaF, cpuF, phase, threshold, formatPhases(execution), formatWaits(topWaits), formatBinds(binds), cur.PlanHash(), formatIO(execution.IO), strings.Join(breached, ","), formatBaseline(learned))
+
+	worstELA, lastELA, numViolations, err := setViolations(wantSQL, cur.BusinessTxName, threshold, cur.SQLID, elaF)
+	if err != nil {
+		fmt.Printf("[%v] error> could not set the violations: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+		return nil, err
+	}
+	counts := st.counts(cur.BusinessTxName)
+	fmt.Printf("[%v] info> lastela:%.3f worstela:%.3f violations:%d executions:%d violationratio:%.4f\n", time.Now().Format("2006-01-02 15:04:05"), lastELA, worstELA, numViolations, counts.Executions, counts.ViolationRatio())
+	if Debug { fmt.Printf("[%v] dbg> judge: wantSQL=%v\n", time.Now().Format("2006-01-02 15:04:05"), wantSQL)}
+
+	return &parsedSummary{
+		isViolation:    true,
+		businessTxName: cur.BusinessTxName,
+		threshold:      threshold,
+		sqlID:          cur.SQLID,
+		worstELA:       worstELA,
+		lastELA:        lastELA,
+		numViolations:  numViolations,
+		execution:      execution,
+		topWaits:       topWaits,
+		binds:          binds,
+		planHash:       cur.PlanHash(),
+		rowSource:      cur.RowSource(),
+		sqlText:        truncateSQLText(cur.SQLText(), opts.SQLText),
+		breached:       breached,
+		statements:     execution.Recursive,
+		baseline:       learned,
+	}, nil
 }
 
-// parseOtherAttr parses a PARSE IN CURSOR record to extract Other cursor attributes.
-func parseOtherAttr(rec string) (*parsedOtherAttr, error) {
-	words := strings.Fields(rec)
-	if Debug { fmt.Printf("[%v] dbg> parseOtherAttr: words=%v\n", time.Now().Format("2006-01-02 15:04:05"), words)}
-	if len(words) <= 10 {
-		return nil, fmt.Errorf("parseOtherAttr: expected number of words is 10. Got %d instead (words=%v)", len(words), words)
+// parseSQLID extracts a SQL ID and Cursor# of a PARSING IN CURSOR record.
+func parseSQLID(r *record.Record) (string, string, error) {
+	if !r.Has("sqlid") {
+		return "", "", fmt.Errorf("parseSQLID: no sqlid in the %s #%d record", r.Kind, r.Cursor)
 	}
-	if Debug { fmt.Printf("[%v] dbg> parseOtherAttr: len=%v, dep=%v, uid=%v, oct=%v, lid=%v, hashValue=%v\n", time.Now().Format("2006-01-02 15:04:05"), words[4][4:], words[5][4:], words[6][4:], words[7][4:], words[8][4:], words[10][3:])}
-	// is there a way to create an array and a loop here to avoid repetions?
-	length, err := strconv.Atoi(words[4][4:])
-	if err != nil {
-		return nil, fmt.Errorf("parseOtherAttr: can't parse length: %v", err)
+	return r.Str("sqlid"), strconv.FormatInt(r.Cursor, 10), nil
+}
+
+// contextMarkers are the names of the trace file markers of the application context.
+var contextMarkers = []string{cursor.ContextModule, cursor.ContextAction, cursor.ContextClientID, cursor.ContextService}
+
+// parseContext parses a "*** MODULE NAME:(value) timestamp" (or the like) marker into its name and value.
+func parseContext(rec string) (string, string, error) {
+	colon := strings.Index(rec, ":(")
+	end := strings.LastIndex(rec, ")")
+	if !strings.HasPrefix(rec, "*** ") || colon < 0 || end < colon+1 {
+		return "", "", fmt.Errorf("parseContext: can't find a context marker in %q", rec)
 	}
+	return rec[len("*** "):colon], rec[colon+2 : end], nil
+}
 
-	depth, err := strconv.Atoi(words[5][4:])
-	if err != nil {
-		return nil, fmt.Errorf("parseOtherAttr: can't parse depth: %v", err)
+// markerTimeLayouts are the layouts of the timestamps of "***" markers: up to 12.1 and ISO 8601 from 12.2 on.
+var markerTimeLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano}
+
+// parseMarkerTime parses the timestamp of a "***" marker, in the local time if it has no time zone.
+func parseMarkerTime(ts string) (time.Time, error) {
+	ts = strings.TrimSpace(ts)
+	for _, layout := range markerTimeLayouts {
+		if t, err := time.ParseInLocation(layout, ts, time.Local); err == nil {
+			return t, nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("parseMarkerTime: can't parse a timestamp out of %q", ts)
+}
 
-	uID, err := strconv.Atoi(words[6][4:])
-	if err != nil {
-		return nil, fmt.Errorf("parseOtherAttr: can't parse uID: %v", err)
+// hasFields reports whether the records of a type come as name=value fields (see record.Parse).
+func hasFields(recType int) bool {
+	switch recType {
+	case traceRecordTypeParsingInCursor, traceRecordTypeParseExecFetch, traceRecordTypeWait, traceRecordTypeBinds,
+		traceRecordTypeStat, traceRecordTypeClose, traceRecordTypeXctend, traceRecordTypeError:
+		return true
 	}
+	return false
+}
 
-	oct, err := strconv.Atoi(words[7][4:])
+// parseTim returns the tim= of a tokenized record, 0 if there's none.
+func parseTim(r *record.Record) int64 {
+	if r == nil {
+		return 0
+	}
+	tim, err := r.Int("tim")
 	if err != nil {
-		return nil, fmt.Errorf("parseOtherAttr: can't parse oct: %v", err)
+		return 0
 	}
+	return tim
+}
 
-	lID, err := strconv.Atoi(words[8][4:])
-	if err != nil {
-		return nil, fmt.Errorf("parseOtherAttr: can't parse lID: %v", err)
-	}
-	return &parsedOtherAttr{
-		hashValue: words[10][3:],
-		length:    length,
-		depth:     depth,
-		uID:       uID,
-		lID:       lID,
-		oct:       oct,
-	}, nil
+// recordTime returns the wall clock time of a record as told by the trace file, or the current time.
+func recordTime(r *record.Record, curTracker *cursor.Tracker) time.Time {
+	if tim := parseTim(r); tim > 0 {
+		if eventTime := curTracker.EventTime(tim); !eventTime.IsZero() {
+			return eventTime
+		}
+	}
+	return time.Now()
+}
+
+// parseSessionID parses a "*** SESSION ID:(sid.serial#) timestamp" record to extract a session ID.
+func parseSessionID(rec string) (string, error) {
+	start := strings.Index(rec, "(")
+	end := strings.Index(rec, ")")
+	if start < 0 || end <= start+1 {
+		return "", fmt.Errorf("parseSessionID: can't find a session ID in %q", rec)
+	}
+	return rec[start+1 : end], nil
+}
+
+type parsedOtherAttr struct {
+	hashValue string
+	length    int
+	depth     int
+	uID       int
+	lID       int
+	oct       int
+}
+
+// parseOtherAttr extracts Other cursor attributes of a PARSING IN CURSOR record.
+func parseOtherAttr(r *record.Record) (*parsedOtherAttr, error) {
+	if Debug { fmt.Printf("[%v] dbg> parseOtherAttr: fields=%v\n", time.Now().Format("2006-01-02 15:04:05"), r.Fields)}
+	attr := &parsedOtherAttr{hashValue: r.Str("hv")}
+	for _, a := range []struct {
+		name string
+		dst  *int
+	}{{"len", &attr.length}, {"dep", &attr.depth}, {"uid", &attr.uID}, {"oct", &attr.oct}, {"lid", &attr.lID}} {
+		n, err := r.Int(a.name)
+		if err != nil {
+			return nil, fmt.Errorf("parseOtherAttr: %v", err)
+		}
+		*a.dst = int(n)
+	}
+	return attr, nil
 }
 
 // Loop over the SQL to monitor to see if the SQL Id mined is the one we are interested in.
-func interestingSQL(getSQLID string, wantSQL []MonitoredSQL) (bool, string, int64) {
+func interestingSQL(getSQLID string, wantSQL []MonitoredSQL) (bool, string, float64) {
 	for _, sw := range wantSQL {
 		if Debug { fmt.Printf("[%v] dbg> BusinessTxName=%s, ELA Threshold=%v, SQLs=%v\n", time.Now().Format("2006-01-02 15:04:05"), sw.BusinessTxName, sw.ELAThreshold, sw.SQLID)}
 		for _, wantSQLID := range sw.SQLID {
 			// log.V(2).Infof("  SQL ID=%v", wantSQLID)
 			if getSQLID == wantSQLID {
+				return true, sw.BusinessTxName, sw.thresholdOf(getSQLID)
+			}
+		}
+	}
+	return false, "", -1
+}
+
+// interestingContext identifies a SQL of interest by the application context of the call.
+func interestingContext(getSQLID string, ctx cursor.Context, wantSQL []MonitoredSQL) (bool, string, float64) {
+	for _, sw := range wantSQL {
+		for _, rule := range sw.Context {
+			if rule.matches(getSQLID, ctx) {
+				return true, sw.BusinessTxName, sw.thresholdOf(getSQLID)
+			}
+		}
+	}
+	return false, "", -1
+}
+
+// hasContext reports whether or not any business tx is defined with application context rules.
+func hasContext(wantSQL []MonitoredSQL) bool {
+	for _, sw := range wantSQL {
+		if len(sw.Context) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// interestingSQLText identifies a SQL of interest by its text, for business txs that have SQL text patterns.
+func interestingSQLText(text string, wantSQL []MonitoredSQL) (bool, string, float64) {
+	for _, sw := range wantSQL {
+		for _, re := range sw.SQLText {
+			if re.MatchString(text) {
 				return true, sw.BusinessTxName, sw.ELAThreshold
 			}
 		}
@@ -533,6 +1429,72 @@ func interestingSQL(getSQLID string, wantSQL []MonitoredSQL) (bool, string, int6
 	return false, "", -1
 }
 
+// hasSQLText reports whether or not any business tx is defined with SQL text patterns.
+func hasSQLText(wantSQL []MonitoredSQL) bool {
+	for _, sw := range wantSQL {
+		if len(sw.SQLText) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// startSQLText sets up the reading of the SQL text that follows a PARSING IN CURSOR record.
+func startSQLText(r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker) {
+	sqlID, cursorString, err := parseSQLID(r)
+	if err != nil {
+		return
+	}
+	cursorInt, err := strconv.Atoi(cursorString)
+	if err != nil {
+		return
+	}
+	cursorID := int64(cursorInt)
+	if cur := curTracker.Get(cursorID); cur != nil && cur.SQLID == sqlID {
+		// A cursor tracked by the application context is still matched by its text.
+		curTracker.StartSQLText(&cursor.SQLTextBlock{Key: cursorID, Cur: cur, Pending: cur.ByContext && hasSQLText(wantSQL)})
+		return
+	}
+	if !hasSQLText(wantSQL) {
+		curTracker.StartSQLText(&cursor.SQLTextBlock{Key: cursorID})
+		return
+	}
+	cur, err := openCursor(r, cursorID, sqlID, "", -1)
+	if err != nil {
+		curTracker.StartSQLText(&cursor.SQLTextBlock{Key: cursorID})
+		return
+	}
+	curTracker.StartSQLText(&cursor.SQLTextBlock{Key: cursorID, Cur: cur, Pending: true})
+}
+
+// endSQLText deals with END OF STMT records, tracking a pending cursor whose SQL text matches a business tx.
+func endSQLText(block *cursor.SQLTextBlock, wantSQL []MonitoredSQL, curTracker *cursor.Tracker) {
+	if block == nil || block.Cur == nil || !block.Pending {
+		return
+	}
+	isInterestingSQL, businessTxName, elaThreshold := interestingSQLText(block.Cur.SQLText(), wantSQL)
+	if !isInterestingSQL {
+		return
+	}
+	block.Cur.BusinessTxName = businessTxName
+	block.Cur.ELAThreshold = elaThreshold
+	block.Cur.ByContext = false
+	curTracker.Set(block.Key, block.Cur)
+	fmt.Printf("[%v] info> interesting SQL found by its text: %s (BusinessTxName=%s, ELA Threshold=%v)\n", time.Now().Format("2006-01-02 15:04:05"), block.Cur.SQLID, businessTxName, elaThreshold)
+}
+
+// truncateSQLText shortens a SQL text to n characters (with its lines joined) for reporting.
+func truncateSQLText(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	r := []rune(strings.Join(strings.Fields(text), " "))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n]) + "..."
+}
+
 // setViolations sets the number of violations of the user set threshold for the SQL elapsed time,
 // returning the worst recorded elapsed time for a SQL statement in question, last elapsed time
 // and the total number of times the threshold has been crossed.
@@ -554,44 +1516,296 @@ func setViolations(wantSQL []MonitoredSQL, busTxName string, threshold float64,
 
 // parseExec deals with parsing PARSE|EXEC|FETCH records returning a boolean
 // flag of whether or not a cursor has already been parsed for this record,
-// and also a cursor#, cursor type, CPU time and Elapsed time.
-func parseExec(rec string, curTracker *CursorTrackerProtected) (bool, int64, string, int64, int64, error) {
-	words := strings.FieldsFunc(rec, func(r rune) bool {
-		switch r {
-		case '#', ':', ',', '=', ' ':
-			return true
+// and also a cursor#, cursor type, CPU time, Elapsed time and the I/O stats.
+func parseExec(r *record.Record, curTracker *cursor.Tracker) (bool, int64, string, int64, int64, cursor.IO, error) {
+	var io cursor.IO
+	if Debug { log.Printf("[%v] dbg> cursor#=%v, c=%v, e=%v\n", time.Now().Format("2006-01-02 15:04:05"), r.Cursor, r.Str("c"), r.Str("e"))}
+	if !curTracker.HasValue(r.Cursor) {
+		return false, 0, "", 0, 0, io, nil
+	}
+	cpu, err := r.Int("c")
+	if err != nil {
+		return false, 0, "", 0, 0, io, fmt.Errorf("parseExec: cannot get CPU: %v", err)
+	}
+	ela, err := r.Int("e")
+	if err != nil {
+		return false, 0, "", 0, 0, io, fmt.Errorf("parseExec: cannot get ELA: %v", err)
+	}
+	for _, f := range []struct {
+		name string
+		dst  *int64
+	}{{"cr", &io.CR}, {"cu", &io.CU}, {"p", &io.PhysReads}, {"r", &io.Rows}, {"mis", &io.Misses}} {
+		if !r.Has(f.name) {
+			continue
+		}
+		if *f.dst, err = r.Int(f.name); err != nil {
+			return false, 0, "", 0, 0, io, fmt.Errorf("parseExec: %v", err)
 		}
-		return false
-	})
-	if len(words) <= 5 {
-		return false, 0, "", 0, 0, fmt.Errorf("parseExec: expected number of words is least 5. Got %d instead: rec=%q, words=%v", len(words), rec, words)
 	}
-	if Debug { log.Printf("[%v] dbg> words=%q, cursor#=%v, c=%v, e=%v\n", time.Now().Format("2006-01-02 15:04:05"), words, words[1], words[3], words[5])}
-	cursorType := words[0]
-	cursorString := words[1]
-	cursorInt, err := strconv.Atoi(cursorString)
+	return true, r.Cursor, r.Kind, cpu, ela, io, nil
+}
+
+// parseWait deals with parsing WAIT records, returning whether the cursor is known, its cursor#, the wait event and its ela.
+func parseWait(r *record.Record, curTracker *cursor.Tracker) (bool, int64, string, int64, error) {
+	if !curTracker.HasValue(r.Cursor) {
+		return false, 0, "", 0, nil
+	}
+	if !r.Has("nam") {
+		return false, 0, "", 0, fmt.Errorf("parseWait: can't find a wait event name in WAIT #%d", r.Cursor)
+	}
+	ela, err := r.Int("ela")
+	if err != nil {
+		return false, 0, "", 0, fmt.Errorf("parseWait: cannot get ELA: %v", err)
+	}
+	return true, r.Cursor, r.Str("nam"), ela, nil
+}
+
+// setDepth records the depth of the cursor of a PARSING IN CURSOR record, returning its cursor# and depth.
+func setDepth(r *record.Record, curTracker *cursor.Tracker) (int64, int, error) {
+	if !r.Has("dep") {
+		return r.Cursor, 0, nil
+	}
+	depth, err := r.Int("dep")
+	if err != nil {
+		return 0, 0, fmt.Errorf("setDepth: %v", err)
+	}
+	curTracker.SetDepth(r.Cursor, r.Str("sqlid"), int(depth))
+	return r.Cursor, int(depth), nil
+}
+
+// rollUpRecursive accounts a dep=1 call to the next top-level call, returning the recursive calls of a top-level one.
+func rollUpRecursive(r *record.Record, curTracker *cursor.Tracker) ([]cursor.Statement, error) {
+	if !r.Has("dep") {
+		return nil, nil
+	}
+	depth, err := r.Int("dep")
+	if err != nil {
+		return nil, fmt.Errorf("rollUpRecursive: %v", err)
+	}
+	switch depth {
+	case 0:
+		return curTracker.EndTopLevelCall(), nil
+	case 1:
+		ela, err := r.Int("e")
+		if err != nil {
+			return nil, fmt.Errorf("rollUpRecursive: %v", err)
+		}
+		curTracker.AddRecursiveCall(r.Cursor, ela)
+	}
+	return nil, nil
+}
+
+// parseBind records the bind variable (or a mask of its value) of an indented line of a BINDS block.
+func parseBind(rec string, cur *cursor.Cursor, mask bool) error {
+	line := strings.TrimSpace(rec)
+	switch {
+	case strings.HasPrefix(line, "Bind#"):
+		position, err := strconv.Atoi(line[len("Bind#"):])
+		if err != nil {
+			return fmt.Errorf("parseBind: bind position doesn't appear to be a number: %q, err=%v", line, err)
+		}
+		cur.AddBind(position)
+	case strings.HasPrefix(line, "value="):
+		value := strings.Trim(line[len("value="):], "\"")
+		if mask {
+			value = maskedBindValue
+		}
+		cur.SetBindValue(value)
+	}
+	return nil
+}
+
+// callExecution returns the stats of a single call as if it was a whole execution (EvaluateCall).
+func callExecution(callType string, cpu, ela int64, io cursor.IO) cursor.Execution {
+	c := &cursor.Cursor{}
+	c.Call(callType, cpu, ela, io)
+	return c.Execution()
+}
+
+// parseError turns the ERROR and PARSE ERROR records of monitored SQLs into error events, unless expected.
+// The cursor# of a PARSE ERROR is stale: the SQL text that follows it tells the statement, see parseErrorText.
+func parseError(r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker, opts Options) (*parsedSummary, error) {
+	code, err := r.Int("err")
 	if err != nil {
-		return false, 0, "", 0, 0, fmt.Errorf("parseExec: cursor# doesn't appear to be a number: cursor#=%v, err=%v", cursorString, err)
+		return nil, fmt.Errorf("parseError: %v", err)
+	}
+	if r.Kind == "PARSE ERROR" {
+		block := &cursor.SQLTextBlock{Key: r.Cursor, ParseErr: code}
+		if hasSQLText(wantSQL) {
+			if cur, err := openCursor(r, r.Cursor, "", "", -1); err == nil {
+				block.Cur, block.Pending = cur, true
+			}
+		}
+		curTracker.StartSQLText(block)
+		return &parsedSummary{}, nil
 	}
+	cur := curTracker.Get(r.Cursor)
+	if cur == nil || cur.BusinessTxName == "" {
+		return &parsedSummary{}, nil
+	}
+	phase := cur.LastCall()
+	if isAllowedError(wantSQL, cur.BusinessTxName, code) {
+		fmt.Printf("[%v] info> %s [SQL_ID=%s] raised an expected ORA-%05d during its %s phase\n", time.Now().Format("2006-01-02 15:04:05"), cur.BusinessTxName, cur.SQLID, code, phase)
+		return &parsedSummary{}, nil
+	}
+	fmt.Printf("[%v] warning> %s [SQL_ID=%s] raised ORA-%05d during its %s phase\n", time.Now().Format("2006-01-02 15:04:05"), cur.BusinessTxName, cur.SQLID, code, phase)
+	return &parsedSummary{
+		isError:        true,
+		businessTxName: cur.BusinessTxName,
+		threshold:      cur.ELAThreshold,
+		sqlID:          cur.SQLID,
+		errorCode:      code,
+		errorPhase:     phase,
+		planHash:       cur.PlanHash(),
+		sqlText:        truncateSQLText(cur.SQLText(), opts.SQLText),
+	}, nil
+}
 
-	// isCursorOpen := cur.IsCursorOpen(int64(cursorInt))
-	if !curTracker.hasValue(int64(cursorInt)) {
-		return false, 0, "", 0, 0, nil
+// parseErrorText turns a PARSE ERROR into an error event of the business tx matching the SQL text that follows it.
+func parseErrorText(block *cursor.SQLTextBlock, wantSQL []MonitoredSQL, opts Options) *parsedSummary {
+	if block.Cur == nil {
+		return &parsedSummary{}
+	}
+	isInterestingSQL, businessTxName, elaThreshold := interestingSQLText(block.Cur.SQLText(), wantSQL)
+	if !isInterestingSQL {
+		return &parsedSummary{}
+	}
+	if isAllowedError(wantSQL, businessTxName, block.ParseErr) {
+		fmt.Printf("[%v] info> %s raised an expected ORA-%05d during its PARSE phase\n", time.Now().Format("2006-01-02 15:04:05"), businessTxName, block.ParseErr)
+		return &parsedSummary{}
 	}
+	fmt.Printf("[%v] warning> %s raised ORA-%05d during its PARSE phase\n", time.Now().Format("2006-01-02 15:04:05"), businessTxName, block.ParseErr)
+	return &parsedSummary{
+		isError:        true,
+		businessTxName: businessTxName,
+		threshold:      elaThreshold,
+		errorCode:      block.ParseErr,
+		errorPhase:     "PARSE",
+		sqlText:        truncateSQLText(block.Cur.SQLText(), opts.SQLText),
+	}
+}
 
-	cString := words[3]
-	cInt, err := strconv.Atoi(cString)
+// endTransaction deals with XCTEND records, judging the business transactions in progress in the current session.
+// Rolled back, read-only and idle (see cursor.MaxTransactionIdle) transactions are discarded.
+func (st *State) endTransaction(r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker) (*parsedSummary, error) {
+	txs := curTracker.EndTransactions()
+	if len(txs) == 0 {
+		return &parsedSummary{}, nil
+	}
+	if r.Str("rlbk") != "0" {
+		if Debug { fmt.Printf("[%v] dbg> endTransaction: %d business tx(s) rolled back, discarding them (fields=%v)\n", time.Now().Format("2006-01-02 15:04:05"), len(txs), r.Fields)}
+		return &parsedSummary{}, nil
+	}
+	// A read-only transaction ends with no commit to judge the business txs by.
+	if r.Str("rd_only") == "1" {
+		if Debug { fmt.Printf("[%v] dbg> endTransaction: %d business tx(s) only read, discarding them (fields=%v)\n", time.Now().Format("2006-01-02 15:04:05"), len(txs), r.Fields)}
+		return &parsedSummary{}, nil
+	}
+	tim, err := r.Int("tim")
 	if err != nil {
-		log.Fatal(err)
-		return false, 0, "", 0, 0, fmt.Errorf("parseExec: strconv.Atoi(eString), cannot get CPU: %v", err)
+		return nil, fmt.Errorf("endTransaction: can't get tim of XCTEND: %v", err)
 	}
-	eString := words[5]
-	eInt, err := strconv.Atoi(eString)
+
+	// Each business tx the commit ends is judged on its own, only one of them can be reported though:
+	// the first violation, if any.
+	var pr *parsedSummary
+	for _, tx := range txs {
+		if tx.IdleAt(tim) {
+			if Debug { fmt.Printf("[%v] dbg> endTransaction: %s has been idle for too long, discarding it\n", time.Now().Format("2006-01-02 15:04:05"), tx.BusinessTxName)}
+			continue
+		}
+		txPr, err := st.judgeTransaction(r, wantSQL, curTracker, tx, tim)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case pr == nil || (txPr.isViolation && !pr.isViolation):
+			pr = txPr
+		case txPr.isViolation:
+			fmt.Printf("[%v] warning> the violation of %s is not reported: the commit reports the one of %s\n", time.Now().Format("2006-01-02 15:04:05"), txPr.businessTxName, pr.businessTxName)
+		}
+	}
+	if pr == nil {
+		return &parsedSummary{}, nil
+	}
+	return pr, nil
+}
+
+// judgeTransaction judges a business transaction ended by a commit at the trace time tim.
+func (st *State) judgeTransaction(r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker, tx *cursor.Transaction, tim int64) (*parsedSummary, error) {
+	threshold := tx.ELAThreshold
+	elaF := float64(tim-tx.Start) / 1000
+	var sqlIDs []string
+	for _, st := range tx.Statements {
+		sqlIDs = append(sqlIDs, st.SQLID)
+	}
+	sqlID := strings.Join(sqlIDs, "+")
+	burnAlerts := st.recordSLO(r, wantSQL, curTracker, tx.BusinessTxName, elaF)
+	st.execs.Add(stats.Key{BusinessTxName: tx.BusinessTxName}, elaF, elaF >= threshold)
+	if elaF < threshold {
+		fmt.Printf("[%v] info> %s [SQL_ID=%s] transaction ran for %.3f [ms] (threshold of %.3f [ms])\n", time.Now().Format("2006-01-02 15:04:05"), tx.BusinessTxName, sqlID, elaF, threshold)
+		return &parsedSummary{businessTxName: tx.BusinessTxName, burnAlerts: burnAlerts}, nil
+	}
+	fmt.Printf("[%v] warning> %s [SQL_ID=%s] transaction ran for %.3f [ms] (threshold of %.3f [ms]): %s\n", time.Now().Format("2006-01-02 15:04:05"), tx.BusinessTxName, sqlID, elaF, threshold, formatStatements(tx.Statements, elaF))
+
+	worstELA, lastELA, numViolations, err := setViolations(wantSQL, tx.BusinessTxName, threshold, sqlID, elaF)
 	if err != nil {
-		log.Fatal(err)
-		return false, 0, "", 0, 0, fmt.Errorf("parseExec: strconv.Atoi(eString), cannot get ELA: %v", err)
+		fmt.Printf("[%v] error> could not set the violations: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+		return nil, err
+	}
+	counts := st.counts(tx.BusinessTxName)
+	fmt.Printf("[%v] info> lastela:%.3f worstela:%.3f violations:%d executions:%d violationratio:%.4f\n", time.Now().Format("2006-01-02 15:04:05"), lastELA, worstELA, numViolations, counts.Executions, counts.ViolationRatio())
+	return &parsedSummary{
+		isViolation:    true,
+		businessTxName: tx.BusinessTxName,
+		threshold:      threshold,
+		sqlID:          sqlID,
+		worstELA:       worstELA,
+		lastELA:        lastELA,
+		numViolations:  numViolations,
+		statements:     tx.Statements,
+		breached:       []string{dimensionELA},
+		burnAlerts:     burnAlerts,
+	}, nil
+}
+
+// parseStat deals with parsing STAT records, returning whether the cursor is known, its cursor# and the row source.
+func parseStat(r *record.Record, curTracker *cursor.Tracker) (bool, int64, *cursor.RowSource, error) {
+	if !r.Has("op") {
+		return false, 0, nil, fmt.Errorf("parseStat: can't find a row source operation in STAT #%d", r.Cursor)
+	}
+	if !curTracker.HasValue(r.Cursor) {
+		return false, 0, nil, nil
+	}
+
+	rs := &cursor.RowSource{}
+	id, _ := r.Int("id")
+	pid, _ := r.Int("pid")
+	rs.ID, rs.ParentID = int(id), int(pid)
+	rs.Rows, _ = r.Int("cnt")
+
+	op := r.Str("op")
+	if statsStart := strings.LastIndex(op, " ("); statsStart >= 0 {
+		for _, w := range strings.Fields(strings.Trim(op[statsStart:], " ()")) {
+			kv := strings.SplitN(w, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			n, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch kv[0] {
+			case "cr":
+				rs.CR = n
+			case "time":
+				rs.Time = n
+			}
+		}
+		op = op[:statsStart]
 	}
-	return true, int64(cursorInt), cursorType, int64(cInt), int64(eInt), nil
+	rs.Operation = op
+	return true, r.Cursor, rs, nil
 }
 
 // normalizeName normalizes a business tx name by converting it to lower case and replacing spaces and # with underscrores.
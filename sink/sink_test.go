@@ -18,9 +18,16 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 	"log"
+	"path/filepath"
 
+	"github.com/borisdali/rttanalyzer/baseline"
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/record"
 	"github.com/kylelemons/godebug/pretty"
 )
 
@@ -76,3 +83,1266 @@ func TestLoadSQL(t *testing.T) {
 
 	}
 }
+
+func TestParseRecordPerTrace(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "EBS/Month End Job",
+			ELAThreshold:   1,
+			SQLID:          []string{"acc988uzvjmmt"},
+		},
+		MonitoredSQL{
+			BusinessTxName: "EBS/Post GL",
+			ELAThreshold:   50,
+			SQLID:          []string{"5vx5qmyh3hj7v"},
+		},
+	}
+	trcA := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	trcB := cursor.NewTracker("CLOUD2_ora_5678.trc")
+
+	// Both traces use cursor #12, interleaving the records the way two concurrent miners would.
+	var testCases = []struct {
+		curTracker *cursor.Tracker
+		rec        string
+		want       *parsedSummary
+	}{
+		{curTracker: trcA,
+			rec:  "PARSING IN CURSOR #12 len=612 dep=0 uid=0 oct=47 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcB,
+			rec:  "PARSING IN CURSOR #12 len=100 dep=0 uid=0 oct=3 lid=0 tim=1409063809187200 hv=1234567890 ad='7cbeae9e0' sqlid='5vx5qmyh3hj7v'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "EXEC #12:c=1000,e=100015,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809287250\n",
+			want: &parsedSummary{}},
+		{curTracker: trcB,
+			rec:  "EXEC #12:c=1000,e=20000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809287300\n",
+			want: &parsedSummary{}},
+		{curTracker: trcB,
+			rec:  "FETCH #12:c=1000,e=70000,p=0,cr=3,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809287400\n",
+			want: &parsedSummary{}},
+		// The executions are judged as they end: with the next EXEC in trace A and the CLOSE in trace B.
+		{curTracker: trcA,
+			rec: "EXEC #12:c=0,e=500,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809287450\n",
+			want: &parsedSummary{
+				isViolation:    true,
+				breached:       []string{"ela"},
+				businessTxName: "EBS/Month End Job",
+				threshold:      1,
+				sqlID:          "acc988uzvjmmt",
+				worstELA:       100.015,
+				lastELA:        100.015,
+				numViolations:  1,
+				execution:      cursor.Execution{Exec: 100015, CPU: 1000, IO: cursor.IO{Rows: 1}},
+			}},
+		{curTracker: trcB,
+			rec: "CLOSE #12:c=0,e=1,dep=0,type=0,tim=1409063809287460\n",
+			want: &parsedSummary{
+				isViolation:    true,
+				breached:       []string{"ela"},
+				businessTxName: "EBS/Post GL",
+				threshold:      50,
+				sqlID:          "5vx5qmyh3hj7v",
+				worstELA:       90,
+				lastELA:        90,
+				numViolations:  1,
+				execution:      cursor.Execution{Exec: 20000, Fetch: 70000, Fetches: 1, CPU: 2000, IO: cursor.IO{CR: 3, Rows: 2}},
+			}},
+		// A new session within trace A reuses cursor #12 for a SQL that is not monitored.
+		{curTracker: trcA,
+			rec:  "*** SESSION ID:(200.3) 2017-01-30 16:43:20.123\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "PARSING IN CURSOR #12 len=50 dep=0 uid=0 oct=3 lid=0 tim=1409063809287500 hv=987654321 ad='7cbeae9f0' sqlid='g0jvz8csyrtcf'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "EXEC #12:c=1000,e=900000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063810187500\n",
+			want: &parsedSummary{}},
+	}
+
+	for i, ent := range testCases {
+		got, err := st.parseRecord(ent.rec, wantSQL, ent.curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%d: %q) failed: %v", i, ent.rec, err)
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("parseRecord(%d: %q): -> diff -got +want\n%s", i, ent.rec, pretty.Compare(got, ent.want))
+		}
+	}
+
+	if got := trcA.Get(12); got != nil {
+		t.Errorf("trcA.Get(12) in session 200.3 = %v, want no cursor", got)
+	}
+	trcA.SetSession("")
+	if got := trcA.Get(12); got == nil || got.SQLID != "acc988uzvjmmt" {
+		t.Errorf("trcA.Get(12) in the first session = %v, want a cursor for SQLID=acc988uzvjmmt", got)
+	}
+}
+
+func TestParseRecordWaits(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   2000,
+			SQLID:          []string{"acc988uzvjmmt"},
+		},
+	}
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	recs := []string{
+		"PARSING IN CURSOR #7 len=612 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"PARSE #7:c=1000,e=1500,p=0,cr=0,cu=0,mis=1,r=0,dep=0,og=1,plh=0,tim=1409063809188697\n",
+		"WAIT #7: nam='db file sequential read' ela= 900000 file#=4 block#=123 blocks=1 obj#=75 tim=1409063810088697\n",
+		"WAIT #7: nam='db file sequential read' ela= 1000000 file#=4 block#=124 blocks=1 obj#=75 tim=1409063811088697\n",
+		"EXEC #7:c=1000,e=1950000,p=2,cr=2,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063811138697\n",
+		"WAIT #7: nam='SQL*Net message to client' ela= 5 driver id=1650815232 #bytes=1 p3=0 obj#=75 tim=1409063811138702\n",
+		"WAIT #3: nam='db file scattered read' ela= 700000 file#=4 block#=200 blocks=8 obj#=76 tim=1409063811838702\n",
+		"WAIT #7: nam='db file scattered read' ela= 300000 file#=4 block#=125 blocks=8 obj#=75 tim=1409063812138702\n",
+		"FETCH #7:c=2000,e=350000,p=8,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063812138702\n",
+		"WAIT #7: nam='SQL*Net message from client' ela= 5000000 driver id=1650815232 #bytes=1 p3=0 obj#=75 tim=1409063817138702\n",
+		"FETCH #7:c=0,e=2300000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063819438702\n",
+		"CLOSE #7:c=0,e=2,dep=0,type=0,tim=1409063819438710\n",
+	}
+
+	var got *parsedSummary
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = pr
+		}
+	}
+
+	want := &parsedSummary{
+		isViolation:    true,
+		breached:       []string{"ela"},
+		businessTxName: "Order Entry",
+		threshold:      2000,
+		sqlID:          "acc988uzvjmmt",
+		worstELA:       4601.5,
+		lastELA:        4601.5,
+		numViolations:  1,
+		execution:      cursor.Execution{Parse: 1500, Exec: 1950000, Fetch: 2650000, Fetches: 2, CPU: 4000, IO: cursor.IO{CR: 12, PhysReads: 10, Rows: 1, Misses: 1}},
+		topWaits: []cursor.Wait{
+			{Name: "db file sequential read", ELA: 1900000, Count: 2},
+			{Name: "db file scattered read", ELA: 300000, Count: 1},
+			{Name: "SQL*Net message to client", ELA: 5, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord(): -> diff -got +want\n%s", pretty.Compare(got, want))
+	}
+	if got, want := formatWaits(want.topWaits), "db file sequential read=1900.000/2,db file scattered read=300.000/1,SQL*Net message to client=0.005/1"; got != want {
+		t.Errorf("formatWaits() = %q, want %q", got, want)
+	}
+}
+
+func TestParseRecordBinds(t *testing.T) {
+	st := NewState()
+	recs := []string{
+		"PARSING IN CURSOR #9 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"PARSE #9:c=1000,e=1500,p=0,cr=0,cu=0,mis=1,r=0,dep=0,og=1,plh=0,tim=1409063809188697\n",
+		"BINDS #9:\n",
+		" Bind#0\n",
+		"  oacdty=02 mxl=22(22) mxlc=00 mal=00 scl=00 pre=00\n",
+		"  oacflg=03 fl2=1000000 frm=00 csi=00 siz=56 off=0\n",
+		"  kxsbbbfp=7f6c1e2a1b30  bln=22  avl=02  flg=05\n",
+		"  value=42\n",
+		" Bind#1\n",
+		"  oacdty=01 mxl=32(05) mxlc=00 mal=00 scl=00 pre=00\n",
+		"  kxsbbbfp=7f6c1e2a1b48  bln=32  avl=05  flg=01\n",
+		"  value=\"SMITH\"\n",
+		"EXEC #9:c=1000,e=150000,p=0,cr=2,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809338697\n",
+		// The next execution binds other values: the violation of the first one, reported as it ends, keeps its own.
+		"BINDS #9:\n",
+		" Bind#0\n",
+		"  oacdty=02 mxl=22(22) mxlc=00 mal=00 scl=00 pre=00\n",
+		"  oacflg=03 fl2=1000000 frm=00 csi=00 siz=56 off=0\n",
+		"  kxsbbbfp=7f6c1e2a1b30  bln=22  avl=02  flg=05\n",
+		"  value=7\n",
+		" Bind#1\n",
+		"  oacdty=01 mxl=32(05) mxlc=00 mal=00 scl=00 pre=00\n",
+		"  kxsbbbfp=7f6c1e2a1b48  bln=32  avl=05  flg=01\n",
+		"  value=\"JONES\"\n",
+		"EXEC #9:c=1000,e=1000,p=0,cr=2,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809340697\n",
+	}
+
+	var testCases = []struct {
+		binds string
+		want  []cursor.Bind
+	}{
+		{binds: BindsCapture,
+			want: []cursor.Bind{{Position: 0, Value: "42"}, {Position: 1, Value: "SMITH"}}},
+		{binds: BindsMask,
+			want: []cursor.Bind{{Position: 0, Value: maskedBindValue}, {Position: 1, Value: maskedBindValue}}},
+		{binds: BindsOff,
+			want: nil},
+	}
+
+	for _, ent := range testCases {
+		wantSQL := []MonitoredSQL{
+			MonitoredSQL{
+				BusinessTxName: "Order Entry",
+				ELAThreshold:   100,
+				SQLID:          []string{"acc988uzvjmmt"},
+			},
+		}
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		var got *parsedSummary
+		for _, rec := range recs {
+			pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Binds: ent.binds})
+			if err != nil {
+				t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+			}
+			if pr.isViolation {
+				got = pr
+			}
+		}
+		if got == nil {
+			t.Errorf("parseRecord(binds=%s): no violation reported", ent.binds)
+			continue
+		}
+		if !reflect.DeepEqual(got.binds, ent.want) {
+			t.Errorf("parseRecord(binds=%s): -> diff -got +want\n%s", ent.binds, pretty.Compare(got.binds, ent.want))
+		}
+	}
+}
+
+func TestParseRecordPlans(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   100,
+			SQLID:          []string{"7zt3ax6mmtrbq"},
+		},
+	}
+	trcA := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	trcB := cursor.NewTracker("CLOUD2_ora_5678.trc")
+	rowSource := []cursor.RowSource{
+		{ID: 1, ParentID: 0, Operation: "SORT AGGREGATE", Rows: 1, CR: 7, Time: 123},
+		{ID: 2, ParentID: 1, Operation: "TABLE ACCESS FULL EMP", Rows: 14, CR: 7, Time: 100},
+	}
+
+	var testCases = []struct {
+		curTracker *cursor.Tracker
+		rec        string
+		want       *parsedSummary
+	}{
+		{curTracker: trcA,
+			rec:  "PARSING IN CURSOR #5 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='7zt3ax6mmtrbq'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "EXEC #5:c=0,e=50,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=2949544139,tim=1409063809187300\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "FETCH #5:c=0,e=500,p=0,cr=7,cu=0,mis=0,r=1,dep=0,og=1,plh=2949544139,tim=1409063809187800\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "STAT #5 id=1 cnt=1 pid=0 pos=1 obj=0 op='SORT AGGREGATE (cr=7 pr=0 pw=0 time=123 us)'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "STAT #5 id=2 cnt=14 pid=1 pos=1 obj=75 op='TABLE ACCESS FULL EMP (cr=7 pr=0 pw=0 str=1 time=100 us cost=3 size=532 card=14)'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcA,
+			rec:  "EXEC #5:c=0,e=150000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=2949544139,tim=1409063809337800\n",
+			want: &parsedSummary{}},
+		// The same SQL in another trace picks a plan never seen before.
+		{curTracker: trcB,
+			rec:  "PARSING IN CURSOR #5 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='7zt3ax6mmtrbq'\n",
+			want: &parsedSummary{}},
+		{curTracker: trcB,
+			rec: "EXEC #5:c=0,e=60,p=0,cr=0,cu=0,mis=1,r=0,dep=0,og=1,plh=1445457117,tim=1409063809387800\n",
+			want: &parsedSummary{
+				isNewPlan:      true,
+				businessTxName: "Order Entry",
+				threshold:      100,
+				sqlID:          "7zt3ax6mmtrbq",
+				planHash:       "1445457117",
+			}},
+		// The violation of the execution that ends with the record comes with its own plan.
+		{curTracker: trcA,
+			rec: "EXEC #5:c=0,e=170000,p=0,cr=0,cu=0,mis=1,r=0,dep=0,og=1,plh=1445457117,tim=1409063809557800\n",
+			want: &parsedSummary{
+				isViolation:    true,
+				breached:       []string{"ela"},
+				businessTxName: "Order Entry",
+				threshold:      100,
+				sqlID:          "7zt3ax6mmtrbq",
+				worstELA:       150,
+				lastELA:        150,
+				numViolations:  1,
+				execution:      cursor.Execution{Exec: 150000},
+				planHash:       "2949544139",
+				rowSource:      rowSource,
+			}},
+		// The row source tree dumped for the old plan no longer applies.
+		{curTracker: trcA,
+			rec: "CLOSE #5:c=0,e=3,dep=0,type=0,tim=1409063809557810\n",
+			want: &parsedSummary{
+				isViolation:    true,
+				breached:       []string{"ela"},
+				businessTxName: "Order Entry",
+				threshold:      100,
+				sqlID:          "7zt3ax6mmtrbq",
+				worstELA:       170,
+				lastELA:        170,
+				numViolations:  2,
+				execution:      cursor.Execution{Exec: 170000, IO: cursor.IO{Misses: 1}},
+				planHash:       "1445457117",
+			}},
+	}
+
+	for i, ent := range testCases {
+		got, err := st.parseRecord(ent.rec, wantSQL, ent.curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%d: %q) failed: %v", i, ent.rec, err)
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("parseRecord(%d: %q): -> diff -got +want\n%s", i, ent.rec, pretty.Compare(got, ent.want))
+		}
+	}
+}
+
+func TestParseRecordExecution(t *testing.T) {
+	st := NewState()
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809188197\n",
+	}
+	// 6 fetches of 10ms each: none of them crosses the threshold on its own, the execution
+	// as a whole does and is reported with all of them once it ends with CLOSE.
+	for i := 0; i < 6; i++ {
+		recs = append(recs, "FETCH #3:c=1000,e=10000,p=0,cr=10,cu=0,mis=0,r=100,dep=0,og=1,plh=0,tim=1409063809198197\n")
+	}
+	recs = append(recs,
+		"CLOSE #3:c=0,e=5,dep=0,type=1,tim=1409063809258197\n",
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809259197\n",
+		"FETCH #3:c=1000,e=10000,p=0,cr=10,cu=0,mis=0,r=100,dep=0,og=1,plh=0,tim=1409063809269197\n",
+	)
+
+	var testCases = []struct {
+		evaluate string
+		want     []*parsedSummary
+	}{
+		{evaluate: EvaluateExecution,
+			want: []*parsedSummary{{
+				isViolation:    true,
+				breached:       []string{"ela"},
+				businessTxName: "Order Entry",
+				threshold:      50,
+				sqlID:          "acc988uzvjmmt",
+				worstELA:       61,
+				lastELA:        61,
+				numViolations:  1,
+				execution:      cursor.Execution{Exec: 1000, Fetch: 60000, Fetches: 6, CPU: 6000, IO: cursor.IO{CR: 60, Rows: 600}},
+			}}},
+		{evaluate: EvaluateCall,
+			want: nil},
+	}
+
+	for _, ent := range testCases {
+		wantSQL := []MonitoredSQL{
+			MonitoredSQL{
+				BusinessTxName: "Order Entry",
+				ELAThreshold:   50,
+				SQLID:          []string{"acc988uzvjmmt"},
+			},
+		}
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		var got []*parsedSummary
+		for _, rec := range recs {
+			pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Evaluate: ent.evaluate})
+			if err != nil {
+				t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+			}
+			if pr.isViolation {
+				got = append(got, pr)
+			}
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("parseRecord(evaluate=%s): -> diff -got +want\n%s", ent.evaluate, pretty.Compare(got, ent.want))
+		}
+	}
+}
+
+func TestParseRecordTransaction(t *testing.T) {
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809188197\n",
+		"FETCH #3:c=1000,e=10000,p=0,cr=10,cu=0,mis=0,r=100,dep=0,og=1,plh=0,tim=1409063809198197\n",
+		"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=2 lid=0 tim=1409063809200197 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+		"EXEC #4:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809230197\n",
+	}
+	orderEntry := &parsedSummary{
+		isViolation:    true,
+		breached:       []string{"ela"},
+		businessTxName: "Order Entry",
+		threshold:      50,
+		sqlID:          "acc988uzvjmmt+7zt3ax6mmtrbq",
+		worstELA:       100,
+		lastELA:        100,
+		numViolations:  1,
+		statements: []cursor.Statement{
+			{SQLID: "acc988uzvjmmt", ELA: 11000, Calls: 2},
+			{SQLID: "7zt3ax6mmtrbq", ELA: 20000, Calls: 1},
+		},
+	}
+
+	var testCases = []struct {
+		name string
+		recs []string
+		want []*parsedSummary
+	}{
+		{name: "commit",
+			recs: append(recs[:len(recs):len(recs)], "XCTEND rlbk=0, rd_only=0, tim=1409063809287197\n"),
+			want: []*parsedSummary{orderEntry}},
+		// Rolled back transactions are not judged.
+		{name: "rollback",
+			recs: append(recs[:len(recs):len(recs)], "XCTEND rlbk=1, rd_only=0, tim=1409063809287197\n"),
+			want: nil},
+		// Nor are read-only ones.
+		{name: "read only",
+			recs: append(recs[:len(recs):len(recs)], "XCTEND rlbk=0, rd_only=1, tim=1409063809287197\n"),
+			want: nil},
+		// A statement of another business tx is judged with its own business tx.
+		{name: "another business tx",
+			recs: []string{
+				recs[0], recs[1], recs[2],
+				"PARSING IN CURSOR #5 len=80 dep=0 uid=0 oct=2 lid=0 tim=1409063809199197 hv=3670034439 ad='7cbeae9da' sqlid='bzscyq07w79ab'\n",
+				"EXEC #5:c=0,e=5000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809205197\n",
+				recs[3], recs[4],
+				"XCTEND rlbk=0, rd_only=0, tim=1409063809287197\n",
+			},
+			want: []*parsedSummary{orderEntry}},
+		// The first statement is followed by a long idle gap: the business tx starts anew after it.
+		{name: "idle gap",
+			recs: []string{
+				recs[0], recs[1], recs[2], recs[3],
+				"EXEC #4:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063929230197\n",
+				"XCTEND rlbk=0, rd_only=0, tim=1409063929287197\n",
+			},
+			want: []*parsedSummary{{
+				isViolation:    true,
+				breached:       []string{"ela"},
+				businessTxName: "Order Entry",
+				threshold:      50,
+				sqlID:          "7zt3ax6mmtrbq",
+				worstELA:       77,
+				lastELA:        77,
+				numViolations:  1,
+				statements:     []cursor.Statement{{SQLID: "7zt3ax6mmtrbq", ELA: 20000, Calls: 1}},
+			}}},
+		// The trace moves on to another session before the commit.
+		{name: "session change",
+			recs: append(append([]string{"*** SESSION ID:(100.1) 2017-01-30 16:43:20.123\n"}, recs...),
+				"*** SESSION ID:(200.3) 2017-01-30 16:43:20.124\n",
+				"*** SESSION ID:(100.1) 2017-01-30 16:43:20.125\n",
+				"XCTEND rlbk=0, rd_only=0, tim=1409063809287197\n"),
+			want: nil},
+	}
+
+	for _, ent := range testCases {
+		wantSQL := []MonitoredSQL{
+			MonitoredSQL{
+				BusinessTxName: "Order Entry",
+				ELAThreshold:   50,
+				SQLID:          []string{"acc988uzvjmmt", "7zt3ax6mmtrbq"},
+			},
+			MonitoredSQL{
+				BusinessTxName: "Billing",
+				ELAThreshold:   500,
+				SQLID:          []string{"bzscyq07w79ab"},
+			},
+		}
+		st := NewState()
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		var got []*parsedSummary
+		for _, rec := range ent.recs {
+			pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Evaluate: EvaluateTransaction})
+			if err != nil {
+				t.Fatalf("%s: parseRecord(%q) failed: %v", ent.name, rec, err)
+			}
+			if pr.isViolation {
+				got = append(got, pr)
+			}
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("%s: parseRecord(): -> diff -got +want\n%s", ent.name, pretty.Compare(got, ent.want))
+		}
+	}
+
+	if got, want := formatStatements([]cursor.Statement{{SQLID: "acc988uzvjmmt", ELA: 11000, Calls: 2}, {SQLID: "7zt3ax6mmtrbq", ELA: 20000, Calls: 1}}, 100), "acc988uzvjmmt=11.000/11.0%,7zt3ax6mmtrbq=20.000/20.0%"; got != want {
+		t.Errorf("formatStatements() = %q, want %q", got, want)
+	}
+}
+
+func TestParseRecordCursorRetirement(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   50,
+			SQLID:          []string{"acc988uzvjmmt", "7zt3ax6mmtrbq", "1hp4nrrczm5nn"},
+		},
+	}
+
+	var testCases = []struct {
+		name        string
+		maxCursors  int
+		recs        []string
+		wantCursors []int64
+		wantClosed  int64
+		wantEvicted int64
+	}{
+		{name: "close retires a cursor",
+			maxCursors: 1000,
+			recs: []string{
+				"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+				"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187297 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+				"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809188197\n",
+				"CLOSE #3:c=0,e=5,dep=0,type=1,tim=1409063809258197\n",
+				// Not tracked: nothing to retire.
+				"CLOSE #5:c=0,e=5,dep=0,type=0,tim=1409063809258297\n",
+			},
+			wantCursors: []int64{4},
+			wantClosed:  1},
+		{name: "cursor# reused for a SQL not of interest",
+			maxCursors: 1000,
+			recs: []string{
+				"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+				"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187297 hv=3670034439 ad='7cbeae9da' sqlid='5ms6rbzdnq16t'\n",
+				"EXEC #3:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809288197\n",
+			},
+			wantCursors: []int64{}},
+		{name: "least recently used cursor is evicted",
+			maxCursors: 2,
+			recs: []string{
+				"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+				"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187297 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+				"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809188197\n",
+				"PARSING IN CURSOR #5 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809189197 hv=3670034440 ad='7cbeae9db' sqlid='1hp4nrrczm5nn'\n",
+			},
+			wantCursors: []int64{3, 5},
+			wantEvicted: 1},
+	}
+
+	defer func(max int) { cursor.MaxCursorsPerSession = max }(cursor.MaxCursorsPerSession)
+	for _, ent := range testCases {
+		cursor.MaxCursorsPerSession = ent.maxCursors
+		before := cursor.Counts()
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		for _, rec := range ent.recs {
+			pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+			if err != nil {
+				t.Fatalf("%s: parseRecord(%q) failed: %v", ent.name, rec, err)
+			}
+			if pr.isViolation {
+				t.Errorf("%s: parseRecord(%q) reported a violation: %+v", ent.name, rec, pr)
+			}
+		}
+
+		gotCursors := []int64{}
+		for _, id := range []int64{3, 4, 5} {
+			if curTracker.HasValue(id) {
+				gotCursors = append(gotCursors, id)
+			}
+		}
+		if !reflect.DeepEqual(gotCursors, ent.wantCursors) {
+			t.Errorf("%s: tracked cursors = %v, want %v", ent.name, gotCursors, ent.wantCursors)
+		}
+		if got := curTracker.Len(); got != len(ent.wantCursors) {
+			t.Errorf("%s: curTracker.Len() = %d, want %d", ent.name, got, len(ent.wantCursors))
+		}
+		after := cursor.Counts()
+		if got := after.Tracked - before.Tracked; got != int64(len(ent.wantCursors)) {
+			t.Errorf("%s: tracked counter grew by %d, want %d", ent.name, got, len(ent.wantCursors))
+		}
+		if got := after.Closed - before.Closed; got != ent.wantClosed {
+			t.Errorf("%s: closed counter grew by %d, want %d", ent.name, got, ent.wantClosed)
+		}
+		if got := after.Evicted - before.Evicted; got != ent.wantEvicted {
+			t.Errorf("%s: evicted counter grew by %d, want %d", ent.name, got, ent.wantEvicted)
+		}
+
+		curTracker.Release()
+		if got := cursor.Counts().Tracked; got != before.Tracked {
+			t.Errorf("%s: tracked counter after Release() = %d, want %d", ent.name, got, before.Tracked)
+		}
+	}
+}
+
+func TestLoadSQLText(t *testing.T) {
+	fh, err := ioutil.TempFile("", "TestLoadSQLText")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile() failed: couldn't open tmp file: %v", err)
+	}
+	defer func() {
+		fh.Close()
+		os.Remove(fh.Name())
+	}()
+	if _, err := fh.WriteString(`Order Entry, 50, acc988uzvjmmt, "text:^INSERT INTO OE\.ORDERS", text:(?i)from oe\.order_items` + "\n"); err != nil {
+		t.Fatalf("file.Write() failed: couldn't write to tmp file: %v", err)
+	}
+
+	sql, err := loadSQL(fh.Name())
+	if err != nil {
+		t.Fatalf("loadSQL() failed: %v", err)
+	}
+	if len(sql) != 1 {
+		t.Fatalf("loadSQL() returned %d business txs, want 1", len(sql))
+	}
+	if got, want := sql[0].SQLID, []string{"acc988uzvjmmt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadSQL() SQLID = %v, want %v", got, want)
+	}
+	var got []string
+	for _, re := range sql[0].SQLText {
+		got = append(got, re.String())
+	}
+	if want := []string{`^INSERT INTO OE\.ORDERS`, `(?i)from oe\.order_items`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadSQL() SQLText = %q, want %q", got, want)
+	}
+}
+
+func TestParseRecordSQLText(t *testing.T) {
+	st := NewState()
+	recs := []string{
+		// Monitored by its SQL ID. The indented line is part of the text, not a bind.
+		"PARSING IN CURSOR #3 len=24 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"SELECT *\n",
+		"  FROM oe.orders\n",
+		"END OF STMT\n",
+		"EXEC #3:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809288197\n",
+		"EXEC #3:c=0,e=500,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809288697\n",
+		// Monitored by its SQL text.
+		"PARSING IN CURSOR #4 len=33 dep=0 uid=0 oct=2 lid=0 tim=1409063809289197 hv=3670034438 ad='7cbeae9d9' sqlid='9babjv8yq8ru3'\n",
+		"INSERT INTO OE.ORDERS VALUES (:1)\n",
+		"END OF STMT\n",
+		"EXEC #4:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809390197\n",
+		"CLOSE #4:c=0,e=2,dep=0,type=0,tim=1409063809390200\n",
+		// Not monitored.
+		"PARSING IN CURSOR #5 len=24 dep=0 uid=0 oct=7 lid=0 tim=1409063809391197 hv=3670034439 ad='7cbeae9da' sqlid='5ms6rbzdnq16t'\n",
+		"DELETE FROM oe.orders\n",
+		"END OF STMT\n",
+		"EXEC #5:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809492197\n",
+	}
+	want := []*parsedSummary{
+		{
+			isViolation:    true,
+			breached:       []string{"ela"},
+			businessTxName: "Order Entry",
+			threshold:      50,
+			sqlID:          "acc988uzvjmmt",
+			worstELA:       100,
+			lastELA:        100,
+			numViolations:  1,
+			execution:      cursor.Execution{Exec: 100000},
+			sqlText:        "SELECT * FROM oe.orde...",
+		},
+		{
+			isViolation:    true,
+			breached:       []string{"ela"},
+			businessTxName: "Order Entry",
+			threshold:      50,
+			sqlID:          "9babjv8yq8ru3",
+			worstELA:       100,
+			lastELA:        100,
+			numViolations:  2,
+			execution:      cursor.Execution{Exec: 100000, IO: cursor.IO{Rows: 1}},
+			sqlText:        "INSERT INTO OE.ORDERS...",
+		},
+	}
+
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   50,
+			SQLID:          []string{"acc988uzvjmmt"},
+			SQLText:        []*regexp.Regexp{regexp.MustCompile(`^INSERT INTO OE\.ORDERS`)},
+		},
+	}
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []*parsedSummary
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{SQLText: 21})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = append(got, pr)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord(): -> diff -got +want\n%s", pretty.Compare(got, want))
+	}
+	if got, want := curTracker.Get(3).SQLText(), "SELECT *\n  FROM oe.orders"; got != want {
+		t.Errorf("SQLText() = %q, want %q", got, want)
+	}
+}
+
+func TestParseContextRule(t *testing.T) {
+	var testCases = []struct {
+		field   string
+		want    ContextRule
+		wantErr bool
+	}{
+		{field: "module=OE_ENTRY action=SUBMIT",
+			want: ContextRule{Module: "OE_ENTRY", Action: "SUBMIT"}},
+		{field: " module='JDBC Thin Client'  sqlid=acc988uzvjmmt ",
+			want: ContextRule{Module: "JDBC Thin Client", SQLID: "acc988uzvjmmt"}},
+		{field: "clientid=scott service=OE",
+			want: ContextRule{ClientID: "scott", Service: "OE"}},
+		{field: "program=sqlplus", wantErr: true},
+		{field: "module='OE_ENTRY", wantErr: true},
+		{field: "module=OE_ENTRY action", wantErr: true},
+	}
+
+	for _, ent := range testCases {
+		got, err := parseContextRule(ent.field)
+		if (err != nil) != ent.wantErr {
+			t.Errorf("parseContextRule(%q) error = %v, want error: %v", ent.field, err, ent.wantErr)
+			continue
+		}
+		if !ent.wantErr && got != ent.want {
+			t.Errorf("parseContextRule(%q) = %+v, want %+v", ent.field, got, ent.want)
+		}
+	}
+}
+
+func TestParseRecordContext(t *testing.T) {
+	st := NewState()
+	recs := []string{
+		"*** SESSION ID:(2157.11227) 2017-01-30 16:43:20.123\n",
+		"*** CLIENT ID:() 2017-01-30 16:43:20.123\n",
+		"*** SERVICE NAME:(OE) 2017-01-30 16:43:20.123\n",
+		"*** MODULE NAME:(OE_ENTRY) 2017-01-30 16:43:20.123\n",
+		"*** ACTION NAME:(SUBMIT) 2017-01-30 16:43:20.123\n",
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809288197\n",
+		"CLOSE #3:c=0,e=0,dep=0,type=0,tim=1409063809288197\n",
+		"*** ACTION NAME:(BROWSE) 2017-01-30 16:43:21.123\n",
+		"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809289197 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+		"EXEC #4:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809390197\n",
+		"*** SESSION ID:(17.3) 2017-01-30 16:43:22.123\n",
+		"*** MODULE NAME:(sqlplus@dbhost (TNS V1-V3)) 2017-01-30 16:43:22.123\n",
+		"PARSING IN CURSOR #5 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809391197 hv=3670034439 ad='7cbeae9da' sqlid='5ms6rbzdnq16t'\n",
+		"EXEC #5:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809492197\n",
+		"CLOSE #5:c=0,e=0,dep=0,type=0,tim=1409063809492197\n",
+		// Same module, but not the SQL ID of the rule.
+		"PARSING IN CURSOR #6 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809493197 hv=3670034440 ad='7cbeae9db' sqlid='1hp4nrrczm5nn'\n",
+		"EXEC #6:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809594197\n",
+		// Parsed under another action, executed under the one of the rule.
+		"*** SESSION ID:(2157.11227) 2017-01-30 16:43:23.123\n",
+		"PARSING IN CURSOR #7 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809600197 hv=3670034441 ad='7cbeae9dc' sqlid='9babjv8yq8ru3'\n",
+		"*** ACTION NAME:(SUBMIT) 2017-01-30 16:43:23.123\n",
+		"EXEC #7:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809701197\n",
+		"CLOSE #7:c=0,e=0,dep=0,type=0,tim=1409063809701197\n",
+		// Parsed under the action of the rule, executed under another one.
+		"PARSING IN CURSOR #8 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809702197 hv=3670034442 ad='7cbeae9dd' sqlid='2kmrhkbqz4ksq'\n",
+		"*** ACTION NAME:(BROWSE) 2017-01-30 16:43:23.124\n",
+		"EXEC #8:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809803197\n",
+		"CLOSE #8:c=0,e=0,dep=0,type=0,tim=1409063809803197\n",
+	}
+	want := []*parsedSummary{
+		{
+			isViolation:    true,
+			breached:       []string{"ela"},
+			businessTxName: "Order Entry",
+			threshold:      50,
+			sqlID:          "acc988uzvjmmt",
+			worstELA:       100,
+			lastELA:        100,
+			numViolations:  1,
+			execution:      cursor.Execution{Exec: 100000},
+			eventTime:      localTime(t, "2017-01-30 16:43:20.224"),
+		},
+		{
+			isViolation:    true,
+			breached:       []string{"ela"},
+			businessTxName: "Ad Hoc Lookup",
+			threshold:      50,
+			sqlID:          "5ms6rbzdnq16t",
+			worstELA:       100,
+			lastELA:        100,
+			numViolations:  1,
+			execution:      cursor.Execution{Exec: 100000},
+			eventTime:      localTime(t, "2017-01-30 16:43:22.224"),
+		},
+		{
+			isViolation:    true,
+			breached:       []string{"ela"},
+			businessTxName: "Order Entry",
+			threshold:      50,
+			sqlID:          "9babjv8yq8ru3",
+			worstELA:       100,
+			lastELA:        100,
+			numViolations:  2,
+			execution:      cursor.Execution{Exec: 100000},
+			eventTime:      localTime(t, "2017-01-30 16:43:23.224"),
+		},
+	}
+
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   50,
+			Context:        []ContextRule{{Module: "OE_ENTRY", Action: "SUBMIT"}},
+		},
+		MonitoredSQL{
+			BusinessTxName: "Ad Hoc Lookup",
+			ELAThreshold:   50,
+			Context:        []ContextRule{{Module: "sqlplus@dbhost (TNS V1-V3)", SQLID: "5ms6rbzdnq16t"}},
+		},
+	}
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []*parsedSummary
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = append(got, pr)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord(): -> diff -got +want\n%s", pretty.Compare(got, want))
+	}
+
+	curTracker.SetSession("2157.11227")
+	if got, want := curTracker.Context(), (cursor.Context{Module: "OE_ENTRY", Action: "BROWSE", Service: "OE"}); got != want {
+		t.Errorf("Context() = %+v, want %+v", got, want)
+	}
+	curTracker.Release()
+	if got := curTracker.Context(); got != (cursor.Context{}) {
+		t.Errorf("Context() after Release() = %+v, want none", got)
+	}
+}
+
+// localTime parses a "2006-01-02 15:04:05.000" timestamp in the local time.
+func localTime(t *testing.T, ts string) time.Time {
+	wall, err := time.ParseInLocation("2006-01-02 15:04:05.000", ts, time.Local)
+	if err != nil {
+		t.Fatalf("time.ParseInLocation(%q) failed: %v", ts, err)
+	}
+	return wall
+}
+
+func TestParseRecordEventTime(t *testing.T) {
+	st := NewState()
+	var testCases = []struct {
+		name   string
+		marker string
+		want   time.Time
+	}{
+		{name: "11g marker",
+			marker: "*** 2017-01-30 16:43:20.123\n",
+			want:   localTime(t, "2017-01-30 16:43:20.224")},
+		{name: "12.2 marker",
+			marker: "*** 2017-01-30T16:43:20.123456+02:00\n",
+			want:   time.Date(2017, 1, 30, 16, 43, 20, 224456000, time.FixedZone("", 2*60*60))},
+		// Without a marker the trace doesn't tell the time: the sinks use the current one.
+		{name: "no marker",
+			marker: "\n"},
+	}
+
+	for _, ent := range testCases {
+		recs := []string{
+			ent.marker,
+			"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+			"WAIT #3: nam='db file sequential read' ela= 50000 file#=4 block#=1234 blocks=1 obj#=75 tim=1409063809237197\n",
+			"EXEC #3:c=0,e=100000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809288197\n",
+			"CLOSE #3:c=0,e=0,dep=0,type=0,tim=1409063809288197\n",
+		}
+		wantSQL := []MonitoredSQL{
+			MonitoredSQL{
+				BusinessTxName: "Order Entry",
+				ELAThreshold:   50,
+				SQLID:          []string{"acc988uzvjmmt"},
+			},
+		}
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		var got []time.Time
+		for _, rec := range recs {
+			pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+			if err != nil {
+				t.Fatalf("%s: parseRecord(%q) failed: %v", ent.name, rec, err)
+			}
+			if pr.isViolation {
+				got = append(got, pr.eventTime)
+			}
+		}
+		if len(got) != 1 || !got[0].Equal(ent.want) {
+			t.Errorf("%s: event times = %v, want [%v]", ent.name, got, ent.want)
+		}
+	}
+}
+
+func TestParseTim(t *testing.T) {
+	var testCases = []struct {
+		rec  string
+		want int64
+	}{
+		{rec: "EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809188197\n", want: 1409063809188197},
+		{rec: "PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n", want: 1409063809187197},
+		{rec: "WAIT #3: nam='latch: cache buffers chains' ela= 5 address=1 number=2 tries=0 obj#=75 tim=1409063809237197\n", want: 1409063809237197},
+		{rec: "XCTEND rlbk=0, rd_only=0, tim=1409063809287300\n", want: 1409063809287300},
+		{rec: "STAT #3 id=1 cnt=1 pid=0 pos=1 obj=0 op='SORT AGGREGATE (cr=3 pr=0 pw=0 time=152 us)'\n", want: 0},
+		{rec: "SELECT systim=1 FROM dual\n", want: 0},
+	}
+	for _, ent := range testCases {
+		// The SQL text is no trace record: there's no record to tell its tim.
+		r, _ := record.Parse(ent.rec)
+		if got := parseTim(r); got != ent.want {
+			t.Errorf("parseTim(%q) = %d, want %d", ent.rec, got, ent.want)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	var testCases = []struct {
+		field   string
+		want    Limits
+		wantErr bool
+	}{
+		{field: "gets=50000 r=10000", want: Limits{Gets: 50000, Rows: 10000}},
+		{field: " cr=1  cu=2 p=3 mis=4 ", want: Limits{CR: 1, CU: 2, PhysReads: 3, Misses: 4}},
+		{field: "", want: Limits{}},
+		{field: "gets", wantErr: true},
+		{field: "gets=0", wantErr: true},
+		{field: "gets=lots", wantErr: true},
+		{field: "ela=100", wantErr: true},
+	}
+
+	for _, ent := range testCases {
+		got, err := parseLimits(ent.field)
+		if (err != nil) != ent.wantErr {
+			t.Errorf("parseLimits(%q) error = %v, want an error: %v", ent.field, err, ent.wantErr)
+			continue
+		}
+		if !ent.wantErr && got != ent.want {
+			t.Errorf("parseLimits(%q) = %+v, want %+v", ent.field, got, ent.want)
+		}
+	}
+}
+
+func TestParseRecordLimits(t *testing.T) {
+	st := NewState()
+	fh, err := ioutil.TempFile("", "TestParseRecordLimits")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile() failed: couldn't open tmp file: %v", err)
+	}
+	defer func() {
+		fh.Close()
+		os.Remove(fh.Name())
+	}()
+	if _, err := fh.WriteString(`Order Entry, 50, acc988uzvjmmt, limit:gets=50000 r=1000` + "\n"); err != nil {
+		t.Fatalf("file.Write() failed: couldn't write to tmp file: %v", err)
+	}
+	wantSQL, err := loadSQL(fh.Name())
+	if err != nil {
+		t.Fatalf("loadSQL() failed: %v", err)
+	}
+	if got, want := wantSQL[0].Limits, (Limits{Gets: 50000, Rows: 1000}); got != want {
+		t.Fatalf("loadSQL() Limits = %+v, want %+v", got, want)
+	}
+
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		// Fast and cheap.
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809188197\n",
+		"FETCH #3:c=1000,e=10000,p=0,cr=100,cu=0,mis=0,r=10,dep=0,og=1,plh=0,tim=1409063809198197\n",
+		// Fast, but reads too much and returns too many rows.
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809199197\n",
+		"FETCH #3:c=1000,e=10000,p=200,cr=45000,cu=6000,mis=0,r=1500,dep=0,og=1,plh=0,tim=1409063809209197\n",
+		// Slow and reads too much.
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809210197\n",
+		"FETCH #3:c=1000,e=60000,p=0,cr=60000,cu=0,mis=0,r=10,dep=0,og=1,plh=0,tim=1409063809270197\n",
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809271197\n",
+	}
+	var got [][]string
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = append(got, pr.breached)
+		}
+	}
+	if want := [][]string{{"gets", "r"}, {"ela", "gets"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord() breached = %q, want %q", got, want)
+	}
+}
+
+func TestParseRecordRecursive(t *testing.T) {
+	st := NewState()
+	recs := []string{
+		"PARSING IN CURSOR #10 len=24 dep=0 uid=0 oct=47 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='9babjv8yq8ru3'\n",
+		"PARSING IN CURSOR #11 len=80 dep=1 uid=0 oct=3 lid=0 tim=1409063809188197 hv=3670034438 ad='7cbeae9d9' sqlid='acc988uzvjmmt'\n",
+		// The SQL text of a recursive SQL is skipped, rolled up or not: its lines are no trace records.
+		"SELECT LINE_ID\n",
+		"FROM GL_JE_LINES\n",
+		"WHERE STATUS = 'U' AND ROWNUM < 10\n",
+		"END OF STMT\n",
+		"EXEC #11:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=1,og=1,plh=0,tim=1409063809208197\n",
+		"FETCH #11:c=0,e=10000,p=0,cr=10,cu=0,mis=0,r=1,dep=1,og=1,plh=0,tim=1409063809218197\n",
+		"CLOSE #11:c=0,e=1,dep=1,type=3,tim=1409063809218198\n",
+		// Already accounted to its dep=1 parent.
+		"PARSING IN CURSOR #12 len=80 dep=2 uid=0 oct=3 lid=0 tim=1409063809219197 hv=3670034439 ad='7cbeae9da' sqlid='5ms6rbzdnq16t'\n",
+		"EXEC #12:c=0,e=5000,p=0,cr=0,cu=0,mis=0,r=0,dep=2,og=1,plh=0,tim=1409063809224197\n",
+		"PARSING IN CURSOR #13 len=80 dep=1 uid=0 oct=2 lid=0 tim=1409063809225197 hv=3670034440 ad='7cbeae9db' sqlid='7zt3ax6mmtrbq'\n",
+		"EXEC #13:c=0,e=30000,p=0,cr=0,cu=3,mis=0,r=1,dep=1,og=1,plh=0,tim=1409063809255197\n",
+		"EXEC #10:c=0,e=70000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809257197\n",
+		// Next top-level call: no recursive SQL.
+		"EXEC #10:c=0,e=60000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809317197\n",
+		"CLOSE #10:c=0,e=1,dep=0,type=0,tim=1409063809317198\n",
+	}
+	type violation struct {
+		sqlID      string
+		statements []cursor.Statement
+	}
+	var testCases = []struct {
+		recursive string
+		want      []violation
+	}{
+		{recursive: RecursiveSeparate,
+			want: []violation{
+				{sqlID: "acc988uzvjmmt"},
+				{sqlID: "9babjv8yq8ru3", statements: []cursor.Statement{{SQLID: "acc988uzvjmmt", ELA: 30000, Calls: 2}, {SQLID: "7zt3ax6mmtrbq", ELA: 30000, Calls: 1}}},
+				{sqlID: "9babjv8yq8ru3"},
+			}},
+		{recursive: RecursiveRollup,
+			want: []violation{
+				{sqlID: "9babjv8yq8ru3", statements: []cursor.Statement{{SQLID: "acc988uzvjmmt", ELA: 30000, Calls: 2}, {SQLID: "7zt3ax6mmtrbq", ELA: 30000, Calls: 1}}},
+				{sqlID: "9babjv8yq8ru3"},
+			}},
+	}
+
+	for _, ent := range testCases {
+		wantSQL := []MonitoredSQL{
+			MonitoredSQL{
+				BusinessTxName: "EBS/Post GL",
+				ELAThreshold:   50,
+				SQLID:          []string{"9babjv8yq8ru3"},
+			},
+			MonitoredSQL{
+				BusinessTxName: "EBS/Post GL Lines",
+				ELAThreshold:   20,
+				SQLID:          []string{"acc988uzvjmmt"},
+			},
+		}
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		var got []violation
+		for _, rec := range recs {
+			pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Recursive: ent.recursive})
+			if err != nil {
+				t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+			}
+			if strings.HasPrefix(rec, "PARSING IN CURSOR #11 ") && !curTracker.InSQLText() {
+				t.Errorf("parseRecord(recursive=%s, %q) didn't start skipping the SQL text", ent.recursive, rec)
+			}
+			if pr.isViolation {
+				got = append(got, violation{sqlID: pr.sqlID, statements: pr.statements})
+			}
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("parseRecord(recursive=%s): -> diff -got +want\n%s", ent.recursive, pretty.Compare(got, ent.want))
+		}
+	}
+}
+
+func TestParseRecordRecursiveEvict(t *testing.T) {
+	st := NewState()
+	recs := []string{
+		"PARSING IN CURSOR #10 len=24 dep=0 uid=0 oct=47 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='9babjv8yq8ru3'\n",
+		"PARSING IN CURSOR #11 len=80 dep=1 uid=0 oct=3 lid=0 tim=1409063809188197 hv=3670034438 ad='7cbeae9d9' sqlid='acc988uzvjmmt'\n",
+		"END OF STMT\n",
+		"PARSING IN CURSOR #13 len=80 dep=1 uid=0 oct=2 lid=0 tim=1409063809189197 hv=3670034440 ad='7cbeae9db' sqlid='7zt3ax6mmtrbq'\n",
+		"END OF STMT\n",
+		"EXEC #11:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=1,og=1,plh=0,tim=1409063809209197\n",
+		// At the cap, #13 is the least recently used recursive cursor: #11 just ran.
+		"PARSING IN CURSOR #14 len=80 dep=1 uid=0 oct=3 lid=0 tim=1409063809210197 hv=3670034439 ad='7cbeae9da' sqlid='5ms6rbzdnq16t'\n",
+		"END OF STMT\n",
+		"EXEC #13:c=0,e=30000,p=0,cr=0,cu=3,mis=0,r=1,dep=1,og=1,plh=0,tim=1409063809240197\n",
+		"EXEC #14:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=1,og=1,plh=0,tim=1409063809250197\n",
+		"EXEC #11:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=1,og=1,plh=0,tim=1409063809260197\n",
+		"EXEC #10:c=0,e=70000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809262197\n",
+		"CLOSE #10:c=0,e=1,dep=0,type=0,tim=1409063809262198\n",
+	}
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "EBS/Post GL",
+			ELAThreshold:   50,
+			SQLID:          []string{"9babjv8yq8ru3"},
+		},
+	}
+	want := []cursor.Statement{{SQLID: "acc988uzvjmmt", ELA: 30000, Calls: 2}, {SQLID: "5ms6rbzdnq16t", ELA: 10000, Calls: 1}}
+
+	defer func(max int) { cursor.MaxCursorsPerSession = max }(cursor.MaxCursorsPerSession)
+	cursor.MaxCursorsPerSession = 2
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []cursor.Statement
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Recursive: RecursiveRollup})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = pr.statements
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord() of the recursive cursors over the cap: -> diff -got +want\n%s", pretty.Compare(got, want))
+	}
+}
+
+func TestParseAllowedErrors(t *testing.T) {
+	var testCases = []struct {
+		field   string
+		want    []int64
+		wantErr bool
+	}{
+		{field: "1403 ORA-00001 ora-1555", want: []int64{1403, 1, 1555}},
+		{field: "", want: nil},
+		{field: "ORA-", wantErr: true},
+		{field: "1403,1", wantErr: true},
+	}
+
+	for _, ent := range testCases {
+		got, err := parseAllowedErrors(ent.field)
+		if (err != nil) != ent.wantErr {
+			t.Errorf("parseAllowedErrors(%q) error = %v, want an error: %v", ent.field, err, ent.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("parseAllowedErrors(%q) = %v, want %v", ent.field, got, ent.want)
+		}
+	}
+}
+
+func TestParseRecordError(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   50,
+			SQLID:          []string{"acc988uzvjmmt", "7zt3ax6mmtrbq"},
+			SQLText:        []*regexp.Regexp{regexp.MustCompile(`(?s)^SELECT .*FROM OE\.ORDER_ITEMS`)},
+			AllowedErrors:  []int64{1},
+		},
+	}
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=2949544139,tim=1409063809188197\n",
+		"FETCH #3:c=1000,e=10000,p=0,cr=10,cu=0,mis=0,r=100,dep=0,og=1,plh=2949544139,tim=1409063809198197\n",
+		"ERROR #3:err=1555 tim=1409063809198297\n",
+		// Expected of the business tx.
+		"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=2 lid=0 tim=1409063809200197 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+		"EXEC #4:c=0,e=2000,p=0,cr=0,cu=3,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809202197\n",
+		"ERROR #4:err=1 tim=1409063809202297\n",
+		// Told by its SQL text rather than by the cursor# last used by the INSERT: no SQL ID.
+		"PARSE ERROR #4:len=52 dep=0 uid=0 oct=3 lid=0 tim=1409063809203197 err=942\n",
+		"SELECT ITEM_ID, QTY\n",
+		"FROM OE.ORDER_ITEMS WHERE ORDER_ID = :1\n",
+		"CLOSE #4:c=0,e=1,dep=0,type=0,tim=1409063809203297\n",
+		// Not monitored, its SQL text neither.
+		"PARSE ERROR #4:len=30 dep=0 uid=0 oct=3 lid=0 tim=1409063809203397 err=942\n",
+		"SELECT * FROM NO_SUCH_TABLE\n",
+		"EXEC #4:c=0,e=2000,p=0,cr=0,cu=3,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809203497\n",
+		// Not monitored.
+		"PARSING IN CURSOR #5 len=24 dep=0 uid=0 oct=7 lid=0 tim=1409063809204197 hv=3670034439 ad='7cbeae9da' sqlid='5ms6rbzdnq16t'\n",
+		"EXEC #5:c=0,e=1000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809205197\n",
+		"ERROR #5:err=1555 tim=1409063809205297\n",
+	}
+	want := []*parsedSummary{
+		{
+			isError:        true,
+			businessTxName: "Order Entry",
+			threshold:      50,
+			sqlID:          "acc988uzvjmmt",
+			errorCode:      1555,
+			errorPhase:     "FETCH",
+			planHash:       "2949544139",
+		},
+		{
+			isError:        true,
+			businessTxName: "Order Entry",
+			threshold:      50,
+			errorCode:      942,
+			errorPhase:     "PARSE",
+		},
+	}
+
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []*parsedSummary
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			t.Errorf("parseRecord(%q) reported a violation: %+v", rec, pr)
+		}
+		if pr.isError {
+			got = append(got, pr)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecord(): -> diff -got +want\n%s", pretty.Compare(got, want))
+	}
+}
+
+func TestParseRecordBaseline(t *testing.T) {
+	st := NewState()
+	dir, err := ioutil.TempDir("", "TestParseRecordBaseline")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := baseline.Load(filepath.Join(dir, "rtta.baseline"), baseline.Config{MinExecutions: 3, Factor: 2, Percentile: 99})
+	if err != nil {
+		t.Fatalf("baseline.Load() failed: %v", err)
+	}
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   100,
+			SQLID:          []string{"acc988uzvjmmt"},
+		},
+	}
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809207197\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		// Ends the third execution: the baseline is learned, within 5% of p99=10 ms.
+		"EXEC #3:c=0,e=15000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809232197\n",
+		// Way within the static threshold, but over twice the baseline.
+		"FETCH #3:c=0,e=15000,p=0,cr=0,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809247197\n",
+		"CLOSE #3:c=0,e=1,dep=0,type=0,tim=1409063809247198\n",
+	}
+
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []*parsedSummary
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Baseline: b})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			got = append(got, pr)
+		}
+	}
+	if len(got) != 1 {
+		t.Fatalf("parseRecord() reported %d violations, want 1", len(got))
+	}
+	l := got[0].baseline
+	if l == nil || l.PTarget < 10 || l.PTarget > 10.5 || l.Executions != 3 || got[0].threshold != l.PTarget*2 || got[0].lastELA != 30 {
+		t.Errorf("parseRecord() reported a violation of %.3f [ms] over %.3f [ms] with a baseline of %+v, want 30 [ms] over twice a p99 of 10 [ms]", got[0].lastELA, got[0].threshold, l)
+	}
+}
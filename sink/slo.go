@@ -0,0 +1,142 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/record"
+	"github.com/borisdali/rttanalyzer/slo"
+)
+
+// sloPrefix marks the Service Level Objective of a business tx among its SQL IDs in the
+// SQL input file, e.g. slo:target=99.9 threshold=2s window=28d
+const sloPrefix = "slo:"
+
+// defaultSLOWindow is the compliance period of an SLO that doesn't set its own.
+const defaultSLOWindow = 28 * 24 * time.Hour
+
+// newObjective validates the SLO of a business tx: target is the percentage of the executions
+// expected to be good, threshold (in milliseconds) defaults to the one of the business tx and
+// window (e.g. 28d or 72h) to defaultSLOWindow.
+func newObjective(target, threshold float64, window string, txThreshold float64) (*slo.Objective, error) {
+	if target <= 0 || target >= 100 {
+		return nil, fmt.Errorf("newObjective: the target is expected to be a percentage between 0 and 100 (exclusive), got %v", target)
+	}
+	if threshold == 0 {
+		threshold = txThreshold
+	}
+	if threshold <= 0 {
+		return nil, fmt.Errorf("newObjective: the threshold is expected to be positive, got %v", threshold)
+	}
+	o := &slo.Objective{Target: target / 100, Threshold: threshold, Window: defaultSLOWindow}
+	if window == "" {
+		return o, nil
+	}
+	if days := strings.TrimSuffix(window, "d"); days != window {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return nil, fmt.Errorf("newObjective: bad window %q: %v", window, err)
+		}
+		o.Window = time.Duration(n * float64(24*time.Hour))
+	} else {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("newObjective: bad window %q: %v", window, err)
+		}
+		o.Window = d
+	}
+	if o.Window < time.Hour {
+		return nil, fmt.Errorf("newObjective: the window is expected to be an hour or more, got %q", window)
+	}
+	return o, nil
+}
+
+// parseSLO parses a space separated list of the target, threshold and window of an SLO,
+// e.g. target=99.9 threshold=2s window=28d, of a business tx with a threshold.
+func parseSLO(field string, txThreshold float64) (*slo.Objective, error) {
+	var target, threshold float64
+	var window string
+	var err error
+	for _, kv := range strings.Fields(field) {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("parseSLO: expected a name=value pair, got %q", kv)
+		}
+		switch strings.ToLower(parts[0]) {
+		case "target":
+			if target, err = strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64); err != nil {
+				return nil, fmt.Errorf("parseSLO: bad target %q: %v", parts[1], err)
+			}
+		case "threshold":
+			if threshold, err = parseThreshold(parts[1]); err != nil {
+				return nil, fmt.Errorf("parseSLO: %v", err)
+			}
+		case "window":
+			window = parts[1]
+		default:
+			return nil, fmt.Errorf("parseSLO: expected one of target, threshold or window, got %q", parts[0])
+		}
+	}
+	if target == 0 {
+		return nil, fmt.Errorf("parseSLO: no target in %q", field)
+	}
+	return newObjective(target, threshold, window, txThreshold)
+}
+
+// sloRegistry keeps the rolling counts of the executions of the business txs with an SLO
+// across all the trace files.
+type sloRegistry struct {
+	sync.Mutex
+	trackers map[string]*slo.Tracker
+}
+
+// tracker returns the tracker of a business tx, starting it afresh if there's none yet
+// or if the objective has changed since.
+func (r *sloRegistry) tracker(busTxName string, o slo.Objective) *slo.Tracker {
+	r.Lock()
+	defer r.Unlock()
+	t, ok := r.trackers[busTxName]
+	if !ok || t.Objective != o {
+		t = slo.NewTracker(o)
+		r.trackers[busTxName] = t
+	}
+	return t
+}
+
+// recordSLO accounts an execution (or a transaction in the transaction mode) of a business tx
+// that ended with a trace record against the SLO of the business tx (if any), returning the
+// burn rate alerts it sets off.  ela is in milliseconds.
+func (st *State) recordSLO(r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker, busTxName string, ela float64) []slo.Alert {
+	sw := findTx(wantSQL, busTxName)
+	if sw == nil || sw.SLO == nil {
+		return nil
+	}
+	alerts := st.slos.tracker(busTxName, *sw.SLO).Record(recordTime(r, curTracker), ela)
+	for _, a := range alerts {
+		fmt.Printf("[%v] warning> %s is burning its error budget: %s\n", time.Now().Format("2006-01-02 15:04:05"), busTxName, formatBurnAlert(a))
+	}
+	return alerts
+}
+
+// formatBurnAlert renders a burn rate alert.
+func formatBurnAlert(a slo.Alert) string {
+	return fmt.Sprintf("%s burn of %.1fx over %v and %.1fx over %v (objective of %g%% under %.3f [ms] over %v: %d good, %d bad, %.1f%% of the error budget left)",
+		a.Name, a.LongRate, a.Long, a.ShortRate, a.Short, a.Objective.Target*100, a.Objective.Threshold, a.Objective.Window, a.Good, a.Bad, a.BudgetLeft*100)
+}
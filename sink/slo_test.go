@@ -0,0 +1,150 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Slo_test runs unit tests on the SLOs of the business txs.
+package sink
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/slo"
+)
+
+// percent converts a percentage the same way (and with the same rounding) as an SLO target is.
+func percent(pct float64) float64 {
+	return pct / 100
+}
+
+func TestParseSLO(t *testing.T) {
+	var testCases = []struct {
+		field   string
+		want    *slo.Objective
+		wantErr bool
+	}{
+		{field: "target=99.9 threshold=2s window=28d", want: &slo.Objective{Target: percent(99.9), Threshold: 2000, Window: 28 * 24 * time.Hour}},
+		{field: "target=99%", want: &slo.Objective{Target: 0.99, Threshold: 150, Window: defaultSLOWindow}},
+		{field: " window=72h  target=95 ", want: &slo.Objective{Target: 0.95, Threshold: 150, Window: 72 * time.Hour}},
+		{field: "threshold=2s", wantErr: true},
+		{field: "target=100", wantErr: true},
+		{field: "target=high", wantErr: true},
+		{field: "target=99 threshold=0", wantErr: true},
+		{field: "target=99 window=10m", wantErr: true},
+		{field: "target=99 window=4w", wantErr: true},
+		{field: "target=99 p=99", wantErr: true},
+		{field: "target", wantErr: true},
+	}
+
+	for _, ent := range testCases {
+		got, err := parseSLO(ent.field, 150)
+		if (err != nil) != ent.wantErr {
+			t.Errorf("parseSLO(%q) error = %v, want an error: %v", ent.field, err, ent.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, ent.want) {
+			t.Errorf("parseSLO(%q) = %+v, want %+v", ent.field, got, ent.want)
+		}
+	}
+}
+
+func TestParseRecordSLO(t *testing.T) {
+	st := NewState()
+	objective := &slo.Objective{Target: 0.99, Threshold: 50, Window: 28 * 24 * time.Hour}
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   100,
+			SQLID:          []string{"acc988uzvjmmt"},
+			SLO:            objective,
+		},
+	}
+	recs := []string{
+		"*** 2017-01-30 16:43:20.123\n",
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+		// Ends the first execution, a good one.
+		"EXEC #3:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		"FETCH #3:c=0,e=40000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809257197\n",
+		// Ends the second execution, a bad one for the SLO (but not a violation): 1 out of 2
+		// is 50 times the error budget.
+		"CLOSE #3:c=0,e=1,dep=0,type=0,tim=1409063809267197\n",
+	}
+
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []slo.Alert
+	for i, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		if pr.isViolation {
+			t.Errorf("parseRecord(%q) reported a violation, want none", rec)
+		}
+		if len(pr.burnAlerts) == 0 {
+			continue
+		}
+		if i != len(recs)-1 {
+			t.Errorf("parseRecord(%q) raised %+v, want no burn rate alerts before the CLOSE", rec, pr.burnAlerts)
+		}
+		if pr.businessTxName != "Order Entry" || !pr.eventTime.Equal(localTime(t, "2017-01-30 16:43:20.203")) {
+			t.Errorf("parseRecord(%q) raised burn rate alerts of %q at %v, want Order Entry at 16:43:20.203", rec, pr.businessTxName, pr.eventTime)
+		}
+		got = append(got, pr.burnAlerts...)
+	}
+
+	if len(got) != 2 || got[0].Name != "fast" || got[1].Name != "slow" {
+		t.Fatalf("parseRecord() raised %+v, want a fast and a slow burn", got)
+	}
+	for _, a := range got {
+		if a.Objective != *objective || a.Good != 1 || a.Bad != 1 || math.Abs(a.LongRate-50) > 1e-9 || math.Abs(a.ShortRate-50) > 1e-9 || math.Abs(a.BudgetLeft+49) > 1e-9 {
+			t.Errorf("parseRecord() raised %+v, want a burn rate of 50 with 1 good and 1 bad execution", a)
+		}
+	}
+}
+
+func TestParseRecordSLOTransaction(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   100,
+			SQLID:          []string{"acc988uzvjmmt"},
+			SLO:            &slo.Objective{Target: 0.9, Threshold: 50, Window: 24 * time.Hour},
+		},
+	}
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=30000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		"EXEC #3:c=0,e=30000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809247197\n",
+		// 60 ms from the beginning of the first statement to the commit: within the threshold
+		// of the business tx, but bad for the SLO.
+		"XCTEND rlbk=0, rd_only=0, tim=1409063809247197\n",
+	}
+
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	var got []slo.Alert
+	for _, rec := range recs {
+		pr, err := st.parseRecord(rec, wantSQL, curTracker, Options{Evaluate: EvaluateTransaction})
+		if err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+		got = append(got, pr.burnAlerts...)
+	}
+	// The budget of 10% can't be burnt faster than 10 times, short of a fast burn.
+	if len(got) != 1 || got[0].Name != "slow" || got[0].Good != 0 || got[0].Bad != 1 {
+		t.Errorf("parseRecord() raised %+v, want a slow burn of 1 bad transaction", got)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"sync/atomic"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/record"
+	"github.com/borisdali/rttanalyzer/slo"
+	"github.com/borisdali/rttanalyzer/stats"
+)
+
+// State is what a Dumper learns off all the trace files it mines: the plan hash values each
+// monitored SQL ran with, the counts of the executions of the business txs and of their SQL
+// IDs and the rolling counts of the business txs with an SLO.
+type State struct {
+	plans *planRegistry
+	execs *stats.Registry
+	slos  *sloRegistry
+}
+
+// NewState returns a State that has learned nothing yet.
+func NewState() *State {
+	return &State{
+		plans: &planRegistry{plans: make(map[string]map[string]bool)},
+		execs: stats.NewRegistry(),
+		slos:  &sloRegistry{trackers: make(map[string]*slo.Tracker)},
+	}
+}
+
+// Stats returns the counts of the executions of the business txs and of their SQL IDs so far.
+// Executions are counted once they end (on the next PARSE or EXEC of their cursor or on its
+// CLOSE), the ones of a business tx judged by its transactions once they commit.
+func (st *State) Stats() []stats.Entry {
+	return st.execs.Snapshot()
+}
+
+// counts returns the counts of the executions of a business tx so far.
+func (st *State) counts(busTxName string) stats.Counts {
+	return st.execs.Get(stats.Key{BusinessTxName: busTxName})
+}
+
+// state returns the State of a Dumper, a fresh one if it was not given one.
+func (g *Generic) state() *State {
+	g.stateOnce.Do(func() {
+		if g.State == nil {
+			g.State = NewState()
+		}
+	})
+	return g.State
+}
+
+// share makes a Dumper use a State learned by another one, e.g. the output media of a Fanout
+// the State of the Fanout.
+func (g *Generic) share(st *State) {
+	g.State = st
+}
+
+// parseErrors counts the trace records that couldn't be parsed.
+var parseErrors int64
+
+// ParseErrors returns the number of the trace records that couldn't be parsed so far.
+func ParseErrors() int64 {
+	return atomic.LoadInt64(&parseErrors)
+}
+
+// endExecution accounts an execution of a cursor that ended with a trace record to the counts
+// and the baseline (if being learned) of its SQL ID and, unless the business txs are judged by their transactions, to the counts
+// and the SLO of its business tx, returning the burn rate alerts it sets off.
+func (st *State) endExecution(r *record.Record, wantSQL []MonitoredSQL, curTracker *cursor.Tracker, cur *cursor.Cursor, e cursor.Execution, opts Options) []slo.Alert {
+	ela := float64(e.ELA()) / 1000
+	st.execs.Add(stats.Key{BusinessTxName: cur.BusinessTxName, SQLID: cur.SQLID}, ela, e.Violated())
+	if opts.Baseline != nil {
+		opts.Baseline.Learn(cur.SQLID, recordTime(r, curTracker), ela)
+	}
+	if opts.Evaluate == EvaluateTransaction {
+		return nil
+	}
+	st.execs.Add(stats.Key{BusinessTxName: cur.BusinessTxName}, ela, e.Violated())
+	return st.recordSLO(r, wantSQL, curTracker, cur.BusinessTxName, ela)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Stats_test runs unit tests on the counts of all the executions.
+package sink
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/borisdali/rttanalyzer/cursor"
+	"github.com/borisdali/rttanalyzer/stats"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseRecordCounts(t *testing.T) {
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   50,
+			SQLID:          []string{"acc988uzvjmmt", "7zt3ax6mmtrbq"},
+		},
+	}
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=10000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809197197\n",
+		"FETCH #3:c=0,e=20000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		// Ends the first execution (30 ms), then a violation reported on its FETCH.
+		"EXEC #3:c=0,e=20000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809237197\n",
+		"FETCH #3:c=0,e=50000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809287197\n",
+		"FETCH #3:c=0,e=10000,p=0,cr=10,cu=0,mis=0,r=1,dep=0,og=1,plh=0,tim=1409063809297197\n",
+		// Ends the second execution (80 ms).
+		"CLOSE #3:c=0,e=1,dep=0,type=0,tim=1409063809297198\n",
+		"PARSING IN CURSOR #4 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809290197 hv=3670034438 ad='7cbeae9d9' sqlid='7zt3ax6mmtrbq'\n",
+		"EXEC #4:c=0,e=2000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809292197\n",
+		// Ends the third execution (2 ms).
+		"CLOSE #4:c=0,e=1,dep=0,type=0,tim=1409063809292198\n",
+		// Still in progress: not counted.
+		"PARSING IN CURSOR #5 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809300197 hv=3670034437 ad='7cbeae9da' sqlid='acc988uzvjmmt'\n",
+		"EXEC #5:c=0,e=2000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809302197\n",
+	}
+	histogram := func(counts map[int]int64) []int64 {
+		h := make([]int64, len(stats.Buckets)+1)
+		for bucket, n := range counts {
+			h[bucket] = n
+		}
+		return h
+	}
+	want := []stats.Entry{
+		{Key: stats.Key{BusinessTxName: "Order Entry"}, Counts: stats.Counts{Executions: 3, Violations: 1, TotalELA: 112, LastELA: 2, WorstELA: 80, Histogram: histogram(map[int]int64{1: 1, 5: 1, 6: 1})}},
+		{Key: stats.Key{BusinessTxName: "Order Entry", SQLID: "7zt3ax6mmtrbq"}, Counts: stats.Counts{Executions: 1, TotalELA: 2, LastELA: 2, WorstELA: 2, Histogram: histogram(map[int]int64{1: 1})}},
+		{Key: stats.Key{BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt"}, Counts: stats.Counts{Executions: 2, Violations: 1, TotalELA: 110, LastELA: 80, WorstELA: 80, Histogram: histogram(map[int]int64{5: 1, 6: 1})}},
+	}
+
+	for _, opts := range []Options{Options{}, Options{Evaluate: EvaluateCall}} {
+		st := NewState()
+		curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+		for _, rec := range recs {
+			if _, err := st.parseRecord(rec, wantSQL, curTracker, opts); err != nil {
+				t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+			}
+		}
+		// In the call mode the violation is the FETCH of 50 ms: still one execution.
+		if got := st.Stats(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Stats(evaluate=%s): -> diff -got +want\n%s", opts.Evaluate, pretty.Compare(got, want))
+		}
+	}
+}
+
+func TestParseRecordCountsTransaction(t *testing.T) {
+	st := NewState()
+	wantSQL := []MonitoredSQL{
+		MonitoredSQL{
+			BusinessTxName: "Order Entry",
+			ELAThreshold:   50,
+			SQLID:          []string{"acc988uzvjmmt"},
+		},
+	}
+	recs := []string{
+		"PARSING IN CURSOR #3 len=80 dep=0 uid=0 oct=3 lid=0 tim=1409063809187197 hv=3670034437 ad='7cbeae9d8' sqlid='acc988uzvjmmt'\n",
+		"EXEC #3:c=0,e=30000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809217197\n",
+		"EXEC #3:c=0,e=30000,p=0,cr=0,cu=0,mis=0,r=0,dep=0,og=1,plh=0,tim=1409063809247197\n",
+		"XCTEND rlbk=0, rd_only=0, tim=1409063809247197\n",
+	}
+
+	curTracker := cursor.NewTracker("CLOUD2_ora_1234.trc")
+	for _, rec := range recs {
+		if _, err := st.parseRecord(rec, wantSQL, curTracker, Options{Evaluate: EvaluateTransaction}); err != nil {
+			t.Fatalf("parseRecord(%q) failed: %v", rec, err)
+		}
+	}
+	// The business tx counts its transactions (a violation of 60 ms), the SQL ID its executions.
+	if got := st.execs.Get(stats.Key{BusinessTxName: "Order Entry"}); got.Executions != 1 || got.Violations != 1 || got.TotalELA != 60 {
+		t.Errorf("Get(Order Entry) = %+v, want 1 violation of 60 ms", got)
+	}
+	if got := st.execs.Get(stats.Key{BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt"}); got.Executions != 1 || got.Violations != 0 || got.TotalELA != 30 {
+		t.Errorf("Get(Order Entry/acc988uzvjmmt) = %+v, want 1 execution of 30 ms", got)
+	}
+}
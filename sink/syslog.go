@@ -0,0 +1,260 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// Transports of the syslog messages.
+const (
+	SyslogUDP  = "udp"  // One message per datagram
+	SyslogTCP  = "tcp"  // Octet counted messages (RFC 6587)
+	SyslogUnix = "unix" // One message per datagram on a unix socket, e.g. /dev/log
+)
+
+// Defaults of the syslog messages.
+const (
+	DefaultSyslogFacility = "local0"
+	defaultSyslogAddr     = "localhost:514"
+	defaultSyslogSocket   = "/dev/log"
+)
+
+// syslogAppName is the APP-NAME of the syslog messages.
+const syslogAppName = "rttanalyzer"
+
+// syslogSDID is the SD-ID of the structured data of the syslog messages (32473 is the
+// enterprise number reserved for documentation, RFC 5612).
+const syslogSDID = "rtta@32473"
+
+// syslogTimeout caps the connection to and a write of a message to a syslog server.
+const syslogTimeout = 5 * time.Second
+
+// syslogFacilities are the syslog facility codes by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities are the syslog severity codes by name.
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// defaultSyslogSeverities map the kinds of the events to syslog severities.
+var defaultSyslogSeverities = map[string]string{
+	EventBurn:      "crit",
+	EventError:     "err",
+	EventViolation: "warning",
+	EventNewPlan:   "notice",
+}
+
+// SyslogOptions are the user tunables (set in rtta.conf) of the syslog output media.
+type SyslogOptions struct {
+	Network    string // One of SyslogUDP (the default), SyslogTCP or SyslogUnix
+	Addr       string // host:port, or the path of the socket for SyslogUnix
+	Facility   string // Name of the facility, DefaultSyslogFacility if not set
+	Severities string // Comma separated kind:severity pairs overriding defaultSyslogSeverities, e.g. violation:err,newplan:info
+}
+
+// Syslog provides specific implementation of the Dumper interface for syslog: each event (a
+// violation, a new plan, an error or a burn rate alert) is sent as an RFC 5424 message with
+// the kind of the event as its MSGID and its details as structured data.  The severity of a
+// message is the one of the business tx if it's a syslog severity name, the one of the kind
+// of the event otherwise.
+// It is safe to use from multiple goroutines simultaneously.
+type Syslog struct {
+	Generic
+	sync.Mutex
+	network    string
+	addr       string
+	facility   int
+	severities map[string]int // By the kind of the event
+	hostname   string
+	conn       net.Conn // Connected on the first message, reconnected after an error
+}
+
+// Init validates the syslog options.
+func (sl *Syslog) Init() error {
+	o := sl.Options.Syslog
+	sl.network, sl.addr = o.Network, o.Addr
+	switch sl.network {
+	case "":
+		sl.network = SyslogUDP
+		fallthrough
+	case SyslogUDP, SyslogTCP:
+		if sl.addr == "" {
+			sl.addr = defaultSyslogAddr
+		}
+	case SyslogUnix:
+		if sl.addr == "" {
+			sl.addr = defaultSyslogSocket
+		}
+	default:
+		return fmt.Errorf("Syslog.Init: network can be one of %s, %s or %s, got %q", SyslogUDP, SyslogTCP, SyslogUnix, o.Network)
+	}
+	facility := o.Facility
+	if facility == "" {
+		facility = DefaultSyslogFacility
+	}
+	var ok bool
+	if sl.facility, ok = syslogFacilities[facility]; !ok {
+		return fmt.Errorf("Syslog.Init: unknown facility %q", facility)
+	}
+	sl.severities = make(map[string]int)
+	for kind, severity := range defaultSyslogSeverities {
+		sl.severities[kind] = syslogSeverities[severity]
+	}
+	for _, pair := range strings.Split(o.Severities, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Syslog.Init: expected a kind:severity pair, got %q", pair)
+		}
+		kind, severity := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if _, ok := defaultSyslogSeverities[kind]; !ok {
+			return fmt.Errorf("Syslog.Init: the kind can be one of %s, %s, %s or %s, got %q", EventViolation, EventNewPlan, EventError, EventBurn, kind)
+		}
+		code, ok := syslogSeverities[severity]
+		if !ok {
+			return fmt.Errorf("Syslog.Init: unknown severity %q", severity)
+		}
+		sl.severities[kind] = code
+	}
+	if sl.hostname, _ = os.Hostname(); sl.hostname == "" {
+		sl.hostname = "-"
+	}
+	return nil
+}
+
+// Dump method specific to Syslog target.
+func (sl *Syslog) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := sl.state().parseRecord(traceRec, sl.MonitoredSQLs, curTracker, sl.Options)
+	if err != nil {
+		return err
+	}
+	return sl.deliver(ctx, client, pr)
+}
+
+// deliver sends the events of a parsed record.
+func (sl *Syslog) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	for _, p := range sl.payloads(pr) {
+		if err := sl.send(sl.format(p, time.Now(), os.Getpid())); err != nil {
+			fmt.Printf("[%v] error> could not send an event of %s to syslog: %v\n", time.Now().Format("2006-01-02 15:04:05"), p.BusinessTxName, err)
+		}
+	}
+	return nil
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (sl *Syslog) LoadSQL() error {
+	sql, err := mustLoadSQL(sl.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> syslog.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	sl.MonitoredSQLs = sql
+	return nil
+}
+
+// format renders an event as an RFC 5424 message sent at a time by a process.
+func (sl *Syslog) format(p *rttpubsub.PayloadSummary, now time.Time, pid int) []byte {
+	kind := eventKind(p)
+	severity, ok := syslogSeverities[p.Severity]
+	if !ok {
+		severity = sl.severities[kind]
+	}
+	type param struct{ name, value string }
+	params := []param{
+		{"db", p.DB},
+		{"businesstx", p.BusinessTxName},
+		{"sqlid", p.SQLID},
+		{"threshold", fmt.Sprintf("%.3f", p.Threshold)},
+		{"planhash", p.PlanHash},
+		{"eventtime", p.EventTime.Format(time.RFC3339Nano)},
+		{"owner", p.Owner},
+	}
+	switch kind {
+	case EventBurn:
+		params = append(params, param{"burnrate", p.BurnRate}, param{"budgetleft", fmt.Sprintf("%.4f", p.BudgetLeft)})
+	case EventError:
+		params = append(params, param{"error", fmt.Sprintf("ORA-%05d", p.ErrorCode)})
+	default:
+		params = append(params, param{"ela", fmt.Sprintf("%.3f", p.LastELA)})
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s [%s", sl.facility*8+severity, now.Format("2006-01-02T15:04:05.000000Z07:00"), sl.hostname, syslogAppName, pid, kind, syslogSDID)
+	for _, param := range params {
+		if param.value != "" {
+			fmt.Fprintf(&b, " %s=\"%s\"", param.name, syslogEscaper.Replace(param.value))
+		}
+	}
+	fmt.Fprintf(&b, "] %s", summary(p))
+	return b.Bytes()
+}
+
+// syslogEscaper escapes the characters that can't appear as is in a structured data param value.
+var syslogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// send sends a message, connecting to the syslog server if not connected yet.  A message that
+// fails on an existing connection is retried once on a new one.
+func (sl *Syslog) send(msg []byte) error {
+	sl.Lock()
+	defer sl.Unlock()
+	if sl.network == SyslogTCP {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		reconnect := sl.conn != nil
+		if sl.conn == nil {
+			network := sl.network
+			if network == SyslogUnix {
+				network = "unixgram"
+			}
+			if sl.conn, err = net.DialTimeout(network, sl.addr, syslogTimeout); err != nil {
+				sl.conn = nil
+				return fmt.Errorf("syslog.send: %v", err)
+			}
+		}
+		sl.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = sl.conn.Write(msg); err == nil {
+			return nil
+		}
+		sl.conn.Close()
+		sl.conn = nil
+		if !reconnect {
+			break
+		}
+	}
+	return fmt.Errorf("syslog.send: %v", err)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Syslog_test runs unit tests on the events sent to syslog.
+package sink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	now := time.Date(2017, 1, 30, 16, 43, 20, 123456789, time.UTC)
+	var testCases = []struct {
+		opts SyslogOptions
+		p    *rttpubsub.PayloadSummary
+		want string
+	}{
+		{
+			p:    &rttpubsub.PayloadSummary{DB: "CLOUD2", IsViolation: true, BusinessTxName: `Order "Entry"]`, SQLID: "acc988uzvjmmt", LastELA: 70, Threshold: 50, PlanHash: "2949544139", EventTime: now},
+			want: `<132>1 2017-01-30T16:43:20.123456Z dbhost rttanalyzer 1234 violation [rtta@32473 db="CLOUD2" businesstx="Order \"Entry\"\]" sqlid="acc988uzvjmmt" threshold="50.000" planhash="2949544139" eventtime="2017-01-30T16:43:20.123456789Z" ela="70.000"] CLOUD2: Order "Entry"] [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])`,
+		},
+		{
+			opts: SyslogOptions{Facility: "daemon", Severities: "error:alert"},
+			p:    &rttpubsub.PayloadSummary{DB: "CLOUD2", IsError: true, ErrorCode: 1555, ErrorPhase: "FETCH", BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt", Threshold: 50, EventTime: now, Owner: "oe-team"},
+			want: `<25>1 2017-01-30T16:43:20.123456Z dbhost rttanalyzer 1234 error [rtta@32473 db="CLOUD2" businesstx="Order Entry" sqlid="acc988uzvjmmt" threshold="50.000" eventtime="2017-01-30T16:43:20.123456789Z" owner="oe-team" error="ORA-01555"] CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] raised ORA-01555 in its FETCH`,
+		},
+		{
+			// The severity of the business tx takes precedence.
+			p:    &rttpubsub.PayloadSummary{DB: "CLOUD2", IsBurnAlert: true, BusinessTxName: "Order Entry", Threshold: 2000, EventTime: now, Severity: "emerg", BurnRate: "fast", LongBurnRate: 15.49, BudgetLeft: -9.8911},
+			want: `<128>1 2017-01-30T16:43:20.123456Z dbhost rttanalyzer 1234 burn [rtta@32473 db="CLOUD2" businesstx="Order Entry" threshold="2000.000" eventtime="2017-01-30T16:43:20.123456789Z" burnrate="fast" budgetleft="-9.8911"] CLOUD2: Order Entry is burning its error budget: fast burn of 15.5x (-989.1% of the budget left)`,
+		},
+	}
+
+	for _, ent := range testCases {
+		sl := &Syslog{Generic: Generic{Options: Options{Syslog: ent.opts}}}
+		if err := sl.Init(); err != nil {
+			t.Fatalf("Init(%+v) failed: %v", ent.opts, err)
+		}
+		sl.hostname = "dbhost"
+		if got := string(sl.format(ent.p, now, 1234)); got != ent.want {
+			t.Errorf("format(%+v):\ngot  %s\nwant %s", ent.p, got, ent.want)
+		}
+	}
+}
+
+// dumpSyslog feeds the records ending with a violation to a syslog sending to a server.
+func dumpSyslog(t *testing.T, network, addr string) {
+	sl := &Syslog{Generic: testGeneric(Options{Syslog: SyslogOptions{Network: network, Addr: addr}})}
+	if err := sl.Init(); err != nil {
+		t.Fatalf("Init(%s, %s) failed: %v", network, addr, err)
+	}
+	dumpRecs(t, sl, violationRecs)
+}
+
+// checkSyslogMessage checks that a message is the one of the violation.
+func checkSyslogMessage(t *testing.T, network, msg string) {
+	wantPrefix := "<132>1 " // local0.warning
+	wantSD := fmt.Sprintf(` rttanalyzer %d violation [rtta@32473 db="CLOUD2" businesstx="Order Entry" sqlid="acc988uzvjmmt" threshold="50.000" `, os.Getpid())
+	if !strings.HasPrefix(msg, wantPrefix) || !strings.Contains(msg, wantSD) || !strings.HasSuffix(msg, ` ela="70.000"] CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])`) {
+		t.Errorf("syslog over %s got %q, want the violation of Order Entry", network, msg)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket failed: %v", err)
+	}
+	defer server.Close()
+	dumpSyslog(t, SyslogUDP, server.LocalAddr().String())
+
+	buf := make([]byte, 4096)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading the syslog message failed: %v", err)
+	}
+	checkSyslogMessage(t, SyslogUDP, string(buf[:n]))
+}
+
+func TestSyslogTCP(t *testing.T) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %v", err)
+	}
+	defer server.Close()
+	msgs := make(chan string, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Octet counting: the length of the message, a space and the message.
+		r := bufio.NewReader(conn)
+		var n int
+		if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+			msgs <- fmt.Sprintf("no octet count: %v", err)
+			return
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			msgs <- fmt.Sprintf("no message: %v", err)
+			return
+		}
+		msgs <- string(buf)
+	}()
+	dumpSyslog(t, SyslogTCP, server.Addr().String())
+
+	select {
+	case msg := <-msgs:
+		checkSyslogMessage(t, SyslogTCP, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("syslog over tcp got no message")
+	}
+}
+
+func TestSyslogUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyslogUnix")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "log")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("net.ListenUnixgram failed: %v", err)
+	}
+	defer server.Close()
+	dumpSyslog(t, SyslogUnix, socket)
+
+	buf := make([]byte, 4096)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatalf("reading the syslog message failed: %v", err)
+	}
+	checkSyslogMessage(t, SyslogUnix, string(buf[:n]))
+}
+
+func TestSyslogInit(t *testing.T) {
+	var testCases = []SyslogOptions{
+		{Network: "udp6"},
+		{Facility: "local8"},
+		{Severities: "violation"},
+		{Severities: "timeout:err"},
+		{Severities: "violation:warn"},
+	}
+
+	for _, o := range testCases {
+		sl := &Syslog{Generic: Generic{Options: Options{Syslog: o}}}
+		if err := sl.Init(); err == nil {
+			t.Errorf("Init(%+v) succeeded, want an error", o)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/borisdali/rttanalyzer/cursor"
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+	"golang.org/x/net/context"
+	bqgen "google.golang.org/api/bigquery/v2"
+)
+
+// Presets of the body of the webhook requests.
+const (
+	WebhookGeneric   = "generic"   // The event as JSON, see rttpubsub.PayloadSummary
+	WebhookSlack     = "slack"     // A message of a Slack incoming webhook
+	WebhookPagerDuty = "pagerduty" // A trigger event of PagerDuty Events API v2
+)
+
+// Defaults of the webhook requests.
+const (
+	DefaultWebhookTimeout = 10 * time.Second
+	DefaultWebhookRetries = 3
+)
+
+// webhookBackoff is the delay before the first retry of a webhook request, doubled on each
+// retry that follows.
+var webhookBackoff = time.Second
+
+// webhookConcurrency caps the events being posted at a time.
+var webhookConcurrency = 4
+
+// webhookPresets are the body templates of the presets.
+var webhookPresets = map[string]string{
+	WebhookGeneric: `{{json .}}`,
+	WebhookSlack:   `{"text": {{json (summary .)}}}`,
+	WebhookPagerDuty: `{"routing_key": {{json routingKey}}, "event_action": "trigger", "dedup_key": {{json (printf "%s/%s/%s" .DB .BusinessTxName .SQLID)}}, ` +
+		`"payload": {"summary": {{json (summary .)}}, "source": {{json .DB}}, "severity": {{json (pagerDutySeverity .)}}, "timestamp": {{json .EventTime}}, "component": {{json .BusinessTxName}}, "custom_details": {{json .}}}}`,
+}
+
+// WebhookOptions are the user tunables (set in rtta.conf) of the webhook output media.
+type WebhookOptions struct {
+	URLs       []string      // Each event is posted to all of them
+	Preset     string        // One of WebhookGeneric, WebhookSlack or WebhookPagerDuty
+	Template   string        // text/template of the body overriding the preset, if set
+	RoutingKey string        // Integration key of the PagerDuty service, for WebhookPagerDuty
+	Timeout    time.Duration // Of a request
+	Retries    int           // Of a request that failed with a network error, a 429 or a 5xx
+}
+
+// Webhook provides specific implementation of the Dumper interface for webhooks: each event
+// (a violation, a new plan, an error or a burn rate alert) is posted to the configured URLs.
+// The body is rendered by a text/template with the PayloadSummary of the event as its data,
+// along with the json, summary, routingKey and pagerDutySeverity functions.
+// The events are posted in the background (along with their retries), Dump only waits for
+// one to be posted when webhookConcurrency of them are being posted already.
+type Webhook struct {
+	Generic
+	client  *http.Client
+	body    *template.Template
+	slots   chan struct{}  // A token per event being posted
+	running sync.WaitGroup // The events being posted
+}
+
+// Init validates the webhook options and prepares the body template.
+func (wh *Webhook) Init() error {
+	o := wh.Options.Webhook
+	if len(o.URLs) == 0 {
+		return fmt.Errorf("Webhook.Init: no URL to post the events to")
+	}
+	text := o.Template
+	if text == "" {
+		preset := o.Preset
+		if preset == "" {
+			preset = WebhookGeneric
+		}
+		var ok bool
+		if text, ok = webhookPresets[preset]; !ok {
+			return fmt.Errorf("Webhook.Init: preset can be one of %s, %s or %s, got %q", WebhookGeneric, WebhookSlack, WebhookPagerDuty, preset)
+		}
+		if preset == WebhookPagerDuty && o.RoutingKey == "" {
+			return fmt.Errorf("Webhook.Init: the %s preset needs a routing key", WebhookPagerDuty)
+		}
+	}
+	body, err := template.New("webhook").Funcs(template.FuncMap{
+		"json":              webhookJSON,
+		"summary":           summary,
+		"routingKey":        func() string { return o.RoutingKey },
+		"pagerDutySeverity": pagerDutySeverity,
+	}).Parse(text)
+	if err != nil {
+		return fmt.Errorf("Webhook.Init: bad template: %v", err)
+	}
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	wh.body = body
+	wh.client = &http.Client{Timeout: timeout}
+	wh.slots = make(chan struct{}, webhookConcurrency)
+	return nil
+}
+
+// Dump method specific to Webhook target.
+func (wh *Webhook) Dump(ctx context.Context, client *pubsub.Client, service *bqgen.Service, curTracker *cursor.Tracker, traceRec string) error {
+	pr, err := wh.state().parseRecord(traceRec, wh.MonitoredSQLs, curTracker, wh.Options)
+	if err != nil {
+		return err
+	}
+	return wh.deliver(ctx, client, pr)
+}
+
+// deliver posts the events of a parsed record in the background.
+func (wh *Webhook) deliver(ctx context.Context, client *pubsub.Client, pr *parsedSummary) error {
+	for _, p := range wh.payloads(pr) {
+		var body bytes.Buffer
+		if err := wh.body.Execute(&body, p); err != nil {
+			fmt.Printf("[%v] error> could not render the webhook body of %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), p.BusinessTxName, err)
+			continue
+		}
+		select {
+		case wh.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		wh.running.Add(1)
+		go func(p *rttpubsub.PayloadSummary, body []byte) {
+			defer wh.running.Done()
+			defer func() { <-wh.slots }()
+			for _, url := range wh.Options.Webhook.URLs {
+				if err := wh.post(ctx, url, body); err != nil {
+					fmt.Printf("[%v] error> could not post an event of %s to the webhook: %v\n", time.Now().Format("2006-01-02 15:04:05"), p.BusinessTxName, err)
+				}
+			}
+		}(p, body.Bytes())
+	}
+	return nil
+}
+
+// Close waits for the events being posted (along with their retries) to be done with.
+func (wh *Webhook) Close() error {
+	wh.running.Wait()
+	return nil
+}
+
+// LoadSQL uploads user-provided mapping of business transactions to SQL statements.
+func (wh *Webhook) LoadSQL() error {
+	sql, err := mustLoadSQL(wh.FileSQL)
+	if err != nil {
+		return err
+	}
+	if Debug {
+		fmt.Printf("[%v] dbg> webhook.LoadSQL: BusTx / SQL statements of interest: %v\n", time.Now().Format("2006-01-02 15:04:05"), sql)
+	}
+	wh.MonitoredSQLs = sql
+	return nil
+}
+
+// post posts a body to a URL, retrying with an exponential backoff on a network error, a 429
+// or a 5xx response.
+func (wh *Webhook) post(ctx context.Context, url string, body []byte) error {
+	backoff := webhookBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = wh.postOnce(ctx, url, body); err == nil {
+			return nil
+		}
+		if !retry || attempt >= wh.Options.Webhook.Retries {
+			return err
+		}
+		if Debug {
+			fmt.Printf("[%v] dbg> webhook.post: attempt %d failed, retrying in %v: %v\n", time.Now().Format("2006-01-02 15:04:05"), attempt+1, backoff, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postOnce posts a body to a URL, reporting whether the request is worth retrying if it failed.
+func (wh *Webhook) postOnce(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("webhook.post: %v", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("webhook.post: %v", err)
+	}
+	defer resp.Body.Close()
+	out, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+	return retry, fmt.Errorf("webhook.post: %s responded with %s: %q", url, resp.Status, out)
+}
+
+// webhookJSON renders a value as JSON, e.g. a string with its quotes and escapes.
+func webhookJSON(v interface{}) (string, error) {
+	out, err := json.Marshal(v)
+	return string(out), err
+}
+
+// summary renders an event in a line.
+func summary(p *rttpubsub.PayloadSummary) string {
+	switch eventKind(p) {
+	case EventBurn:
+		return fmt.Sprintf("%s: %s is burning its error budget: %s burn of %.1fx (%.1f%% of the budget left)", p.DB, p.BusinessTxName, p.BurnRate, p.LongBurnRate, p.BudgetLeft*100)
+	case EventError:
+		// A statement that failed to parse has no SQL ID.
+		if p.SQLID == "" {
+			return fmt.Sprintf("%s: %s raised ORA-%05d in its %s", p.DB, p.BusinessTxName, p.ErrorCode, p.ErrorPhase)
+		}
+		return fmt.Sprintf("%s: %s [SQL_ID=%s] raised ORA-%05d in its %s", p.DB, p.BusinessTxName, p.SQLID, p.ErrorCode, p.ErrorPhase)
+	case EventViolation:
+		return fmt.Sprintf("%s: %s [SQL_ID=%s] ran for %.3f [ms] (threshold of %.3f [ms])", p.DB, p.BusinessTxName, p.SQLID, p.LastELA, p.Threshold)
+	}
+	return fmt.Sprintf("%s: %s [SQL_ID=%s] runs with a new plan %s", p.DB, p.BusinessTxName, p.SQLID, p.PlanHash)
+}
+
+// pagerDutySeverities are the severities of PagerDuty Events API v2.
+var pagerDutySeverities = map[string]bool{"critical": true, "error": true, "warning": true, "info": true}
+
+// pagerDutySeverity returns the PagerDuty severity of an event: the one of its business tx if it's
+// one of the PagerDuty ones, error otherwise (warning for a new plan).
+func pagerDutySeverity(p *rttpubsub.PayloadSummary) string {
+	if pagerDutySeverities[p.Severity] {
+		return p.Severity
+	}
+	if eventKind(p) == EventNewPlan {
+		return "warning"
+	}
+	return "error"
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Webhook_test runs unit tests on the events posted to the webhooks.
+package sink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	rttpubsub "github.com/borisdali/rttanalyzer/pubsub"
+)
+
+// webhookReceiver records the bodies posted to it, responding with the statuses in turn
+// (and with 200 once they run out).
+type webhookReceiver struct {
+	sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.Lock()
+	defer wr.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	wr.bodies = append(wr.bodies, body)
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if len(wr.statuses) > 0 {
+		w.WriteHeader(wr.statuses[0])
+		wr.statuses = wr.statuses[1:]
+	}
+}
+
+// dumpWebhook feeds the records to a webhook posting to the receivers, and waits for the
+// events to be posted.
+func dumpWebhook(t *testing.T, o WebhookOptions, receivers ...*webhookReceiver) {
+	for _, wr := range receivers {
+		server := httptest.NewServer(wr)
+		defer server.Close()
+		o.URLs = append(o.URLs, server.URL)
+	}
+	wh := &Webhook{Generic: testGeneric(Options{Webhook: o})}
+	wh.MonitoredSQLs[0].Severity = "critical"
+	if err := wh.Init(); err != nil {
+		t.Fatalf("Init(%+v) failed: %v", o, err)
+	}
+	dumpRecs(t, wh, violationRecs)
+}
+
+func TestWebhookGeneric(t *testing.T) {
+	first, second := &webhookReceiver{}, &webhookReceiver{}
+	dumpWebhook(t, WebhookOptions{}, first, second)
+
+	for _, wr := range []*webhookReceiver{first, second} {
+		if len(wr.bodies) != 1 {
+			t.Fatalf("webhook got %d requests, want the violation posted to each URL", len(wr.bodies))
+		}
+		var got rttpubsub.PayloadSummary
+		if err := json.Unmarshal(wr.bodies[0], &got); err != nil {
+			t.Fatalf("webhook got %s, want a PayloadSummary: %v", wr.bodies[0], err)
+		}
+		if !got.IsViolation || got.DB != "CLOUD2" || got.BusinessTxName != "Order Entry" || got.SQLID != "acc988uzvjmmt" || got.LastELA != 70 || got.Threshold != 50 {
+			t.Errorf("webhook got %+v, want the violation of Order Entry", got)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(b time.Duration) { webhookBackoff = b }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var testCases = []struct {
+		statuses  []int
+		retries   int
+		wantPosts int
+	}{
+		{statuses: []int{503, 429}, retries: 3, wantPosts: 3},
+		{statuses: []int{500, 502, 503, 504}, retries: 3, wantPosts: 4},
+		{statuses: []int{500, 502}, retries: 0, wantPosts: 1},
+		// Client errors are not retried.
+		{statuses: []int{400}, retries: 3, wantPosts: 1},
+	}
+
+	for _, ent := range testCases {
+		wr := &webhookReceiver{statuses: append([]int(nil), ent.statuses...)}
+		dumpWebhook(t, WebhookOptions{Retries: ent.retries}, wr)
+		if len(wr.bodies) != ent.wantPosts {
+			t.Errorf("webhook responding with %v got %d requests with %d retries, want %d", ent.statuses, len(wr.bodies), ent.retries, ent.wantPosts)
+		}
+	}
+}
+
+func TestWebhookPresets(t *testing.T) {
+	slack := &webhookReceiver{}
+	dumpWebhook(t, WebhookOptions{Preset: WebhookSlack}, slack)
+	var message struct{ Text string }
+	if len(slack.bodies) != 1 || json.Unmarshal(slack.bodies[0], &message) != nil {
+		t.Fatalf("Slack got %q, want a message", slack.bodies)
+	}
+	if want := "CLOUD2: Order Entry [SQL_ID=acc988uzvjmmt] ran for 70.000 [ms] (threshold of 50.000 [ms])"; message.Text != want {
+		t.Errorf("Slack got %q, want %q", message.Text, want)
+	}
+
+	pagerDuty := &webhookReceiver{}
+	dumpWebhook(t, WebhookOptions{Preset: WebhookPagerDuty, RoutingKey: "R0UT1NGK3Y"}, pagerDuty)
+	var event struct {
+		RoutingKey  string `json:"routing_key"`
+		EventAction string `json:"event_action"`
+		DedupKey    string `json:"dedup_key"`
+		Payload     struct {
+			Summary       string
+			Source        string
+			Severity      string
+			Timestamp     time.Time
+			Component     string
+			CustomDetails rttpubsub.PayloadSummary `json:"custom_details"`
+		}
+	}
+	if len(pagerDuty.bodies) != 1 {
+		t.Fatalf("PagerDuty got %d requests, want 1", len(pagerDuty.bodies))
+	}
+	if err := json.Unmarshal(pagerDuty.bodies[0], &event); err != nil {
+		t.Fatalf("PagerDuty got %s, want an event: %v", pagerDuty.bodies[0], err)
+	}
+	if event.RoutingKey != "R0UT1NGK3Y" || event.EventAction != "trigger" || event.DedupKey != "CLOUD2/Order Entry/acc988uzvjmmt" {
+		t.Errorf("PagerDuty got %+v, want a trigger of R0UT1NGK3Y deduplicated by the SQL ID", event)
+	}
+	if p := event.Payload; p.Source != "CLOUD2" || p.Severity != "critical" || p.Component != "Order Entry" || p.Timestamp.IsZero() || !p.CustomDetails.IsViolation {
+		t.Errorf("PagerDuty got the payload %+v, want the critical violation of Order Entry", p)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	wr := &webhookReceiver{}
+	dumpWebhook(t, WebhookOptions{Template: `{"tx": {{json .BusinessTxName}}, "ela": {{.LastELA}}}`}, wr)
+	if want := `{"tx": "Order Entry", "ela": 70}`; len(wr.bodies) != 1 || string(wr.bodies[0]) != want {
+		t.Errorf("webhook got %q, want %s", wr.bodies, want)
+	}
+}
+
+func TestWebhookInit(t *testing.T) {
+	var testCases = []WebhookOptions{
+		{},
+		{URLs: []string{"http://localhost/hook"}, Preset: "teams"},
+		{URLs: []string{"http://localhost/hook"}, Preset: WebhookPagerDuty},
+		{URLs: []string{"http://localhost/hook"}, Template: "{{.NoSuchFunc | oops}}"},
+	}
+
+	for _, o := range testCases {
+		wh := &Webhook{Generic: Generic{Options: Options{Webhook: o}}}
+		if err := wh.Init(); err == nil {
+			t.Errorf("Init(%+v) succeeded, want an error", o)
+		}
+	}
+}
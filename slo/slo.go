@@ -0,0 +1,197 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package slo keeps the rolling counts of the good and bad executions of a business
+// transaction against its Service Level Objective (e.g. 99% of the executions under 2s
+// over 28 days) and raises multi-window burn rate alerts on its error budget.
+package slo
+
+import (
+	"sync"
+	"time"
+)
+
+// Objective is the Service Level Objective of a business transaction.
+type Objective struct {
+	Target    float64       // Share of the executions expected to be good, e.g. 0.99
+	Threshold float64       // Elapsed time (in milliseconds) under which an execution is good
+	Window    time.Duration // Compliance period, e.g. 28 days
+}
+
+// BurnRate is a multi-window burn rate alert.  It fires once the error budget is being
+// burnt Factor times faster than the objective allows over both the Long and the Short
+// windows: the Long one makes it significant, the Short one makes it current.
+type BurnRate struct {
+	Name   string
+	Long   time.Duration
+	Short  time.Duration
+	Factor float64
+}
+
+// BurnRates are the alerts raised on the error budget: a fast burn spending 2% of a
+// 30 days budget in an hour and a slow burn spending 5% of it in 6 hours.
+var BurnRates = []BurnRate{
+	{Name: "fast", Long: time.Hour, Short: 5 * time.Minute, Factor: 14.4},
+	{Name: "slow", Long: 6 * time.Hour, Short: 30 * time.Minute, Factor: 6},
+}
+
+// Alert is a burn rate alert.  Good and Bad are the executions over the objective's
+// window and BudgetLeft is the share of the error budget left over it (negative once
+// overspent).
+type Alert struct {
+	BurnRate
+	Objective  Objective
+	LongRate   float64
+	ShortRate  float64
+	Good       int64
+	Bad        int64
+	BudgetLeft float64
+	At         time.Time
+}
+
+// Tracker keeps the rolling counts of the executions of a business transaction.
+// It is safe to use from multiple goroutines simultaneously.
+type Tracker struct {
+	sync.Mutex
+	Objective Objective
+	fine      *ring           // Per minute, over the longest window of the burn rates
+	coarse    *ring           // Per hour, over the objective's window
+	firing    map[string]bool // Burn rate alerts in effect
+}
+
+// NewTracker returns a tracker with no executions for an objective.
+func NewTracker(o Objective) *Tracker {
+	var longest time.Duration
+	for _, br := range BurnRates {
+		if br.Long > longest {
+			longest = br.Long
+		}
+	}
+	return &Tracker{
+		Objective: o,
+		fine:      newRing(time.Minute, longest),
+		coarse:    newRing(time.Hour, o.Window),
+		firing:    make(map[string]bool),
+	}
+}
+
+// Record accounts an execution that ended at a time with an elapsed time (in milliseconds),
+// returning the burn rate alerts it sets off.  An alert is raised once when its condition
+// starts to hold and not again until the condition has ceased to hold.
+func (t *Tracker) Record(at time.Time, ela float64) []Alert {
+	t.Lock()
+	defer t.Unlock()
+	good := ela < t.Objective.Threshold
+	t.fine.add(at, good)
+	t.coarse.add(at, good)
+
+	var alerts []Alert
+	for _, br := range BurnRates {
+		longRate := t.burnRate(t.fine.sum(at, br.Long))
+		shortRate := t.burnRate(t.fine.sum(at, br.Short))
+		if longRate < br.Factor || shortRate < br.Factor {
+			t.firing[br.Name] = false
+			continue
+		}
+		if t.firing[br.Name] {
+			continue
+		}
+		t.firing[br.Name] = true
+		goodW, badW := t.coarse.sum(at, t.Objective.Window)
+		alerts = append(alerts, Alert{
+			BurnRate:   br,
+			Objective:  t.Objective,
+			LongRate:   longRate,
+			ShortRate:  shortRate,
+			Good:       goodW,
+			Bad:        badW,
+			BudgetLeft: 1 - t.burnRate(goodW, badW),
+			At:         at,
+		})
+	}
+	return alerts
+}
+
+// Counts returns the good and bad executions over the objective's window up to a time.
+func (t *Tracker) Counts(at time.Time) (good, bad int64) {
+	t.Lock()
+	defer t.Unlock()
+	return t.coarse.sum(at, t.Objective.Window)
+}
+
+// burnRate returns how many times faster than the objective allows the error budget
+// is burnt given the good and bad executions over a window.
+func (t *Tracker) burnRate(good, bad int64) float64 {
+	if good+bad == 0 || t.Objective.Target >= 1 {
+		return 0
+	}
+	return float64(bad) / float64(good+bad) / (1 - t.Objective.Target)
+}
+
+// bucket counts the executions of a time slot.
+type bucket struct {
+	slot int64
+	good int64
+	bad  int64
+}
+
+// ring is a circular buffer of time slots of a resolution, covering a span of time.
+type ring struct {
+	res     time.Duration
+	buckets []bucket
+}
+
+// newRing returns an empty ring.
+func newRing(res, span time.Duration) *ring {
+	n := int(span/res) + 1
+	if n < 2 {
+		n = 2
+	}
+	return &ring{res: res, buckets: make([]bucket, n)}
+}
+
+// slot returns the time slot of a time.
+func (r *ring) slot(at time.Time) int64 {
+	return at.UnixNano() / int64(r.res)
+}
+
+// add accounts an execution to its time slot.  Executions older than the span of the
+// ring are dropped.
+func (r *ring) add(at time.Time, good bool) {
+	slot := r.slot(at)
+	b := &r.buckets[int(slot%int64(len(r.buckets)))]
+	switch {
+	case b.slot > slot:
+		return
+	case b.slot < slot:
+		*b = bucket{slot: slot}
+	}
+	if good {
+		b.good++
+	} else {
+		b.bad++
+	}
+}
+
+// sum returns the good and bad executions of the time slots over a span up to a time.
+func (r *ring) sum(at time.Time, span time.Duration) (good, bad int64) {
+	last := r.slot(at)
+	first := last - int64(span/r.res) + 1
+	for _, b := range r.buckets {
+		if b.slot >= first && b.slot <= last {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return good, bad
+}
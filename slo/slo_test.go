@@ -0,0 +1,138 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Slo_test runs unit tests on the rolling counts and the burn rate alerts.
+package slo
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	start     = time.Date(2017, 1, 30, 16, 0, 0, 0, time.UTC)
+	objective = Objective{Target: 0.99, Threshold: 2000, Window: 28 * 24 * time.Hour}
+)
+
+// run records n executions of an elapsed time, one every interval from a time on,
+// returning the names of the alerts raised and the time of the last execution.
+func run(tr *Tracker, from time.Time, n int, interval time.Duration, ela float64) ([]string, time.Time) {
+	var names []string
+	at := from
+	for i := 0; i < n; i++ {
+		at = from.Add(time.Duration(i) * interval)
+		for _, a := range tr.Record(at, ela) {
+			names = append(names, a.Name)
+		}
+	}
+	return names, at
+}
+
+func TestRecord(t *testing.T) {
+	tr := NewTracker(objective)
+
+	// 6 hours of good executions, one a minute.
+	names, at := run(tr, start, 360, time.Minute, 100)
+	if len(names) != 0 {
+		t.Fatalf("good executions raised %v, want none", names)
+	}
+
+	// 11 minutes of bad executions: the 1h error rate gets to 8/60 (13.3x the budget)
+	// on the 8th one and to 9/60 (15x) on the 9th.  It takes 22 for a slow burn.
+	names, at = run(tr, at.Add(time.Minute), 11, time.Minute, 2500)
+	if want := []string{"fast"}; len(names) != 1 || names[0] != want[0] {
+		t.Fatalf("a burst of bad executions raised %v, want %v", names, want)
+	}
+
+	// Still burning: not raised again.
+	names, at = run(tr, at.Add(time.Minute), 5, time.Minute, 2500)
+	if len(names) != 0 {
+		t.Errorf("an ongoing burn raised %v again, want none", names)
+	}
+
+	// Back to normal for a day, then another burst.
+	run(tr, at.Add(time.Minute), 24*60, time.Minute, 100)
+	names, _ = run(tr, at.Add(24*time.Hour+time.Minute), 11, time.Minute, 2500)
+	if want := []string{"fast"}; len(names) != 1 || names[0] != want[0] {
+		t.Errorf("a second burst raised %v, want %v", names, want)
+	}
+}
+
+func TestRecordSlowBurn(t *testing.T) {
+	tr := NewTracker(objective)
+
+	// One in 8 executions is bad: 12.5x the budget, which is a slow burn only.
+	var names []string
+	at := start
+	for i := 0; i < 6*60; i++ {
+		at = start.Add(time.Duration(i) * time.Minute)
+		ela := 100.0
+		if i%8 == 7 {
+			ela = 2500
+		}
+		for _, a := range tr.Record(at, ela) {
+			names = append(names, a.Name)
+		}
+	}
+	if want := []string{"slow"}; len(names) != 1 || names[0] != want[0] {
+		t.Errorf("a steady 12.5%% of bad executions raised %v, want %v", names, want)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	tr := NewTracker(objective)
+	_, at := run(tr, start, 90, time.Minute, 100)
+	// 6 bad executions out of 96 over 6 hours is a slow burn (6.25x the budget), 11 out
+	// of 71 over the last hour is a fast one (15.5x).
+	var alerts []Alert
+	for i := 0; i < 12; i++ {
+		at = at.Add(time.Second)
+		alerts = append(alerts, tr.Record(at, 2000)...)
+		if i == 10 {
+			if len(alerts) != 2 || alerts[1].At != at {
+				t.Fatalf("Record() raised %+v by the 11th bad execution, want a slow and a fast burn, the latter at %v", alerts, at)
+			}
+		}
+	}
+	if len(alerts) != 2 || alerts[0].Name != "slow" || alerts[1].Name != "fast" {
+		t.Fatalf("Record() raised %+v, want a slow and a fast burn", alerts)
+	}
+	a := alerts[1]
+	if a.Good != 90 || a.Bad != 11 || a.Objective != objective {
+		t.Errorf("Record() raised %+v, want a fast burn with 90 good and 11 bad executions", a)
+	}
+	if a.LongRate < 15.49 || a.LongRate > 15.5 || a.ShortRate < 68.7 || a.ShortRate > 68.8 {
+		t.Errorf("Record() raised %+v, want burn rates of 15.49 (11/71) and 68.75 (11/16)", a)
+	}
+	// 11 bad executions out of 101 spend 10.9 times the budget of 1%.
+	if a.BudgetLeft > -9.89 || a.BudgetLeft < -9.9 {
+		t.Errorf("Record() raised %+v, want BudgetLeft of -9.89", a)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	tr := NewTracker(Objective{Target: 0.9, Threshold: 100, Window: 2 * time.Hour})
+	tr.Record(start, 50)
+	tr.Record(start.Add(time.Minute), 150)
+	tr.Record(start.Add(3*time.Hour), 50)
+
+	// The first two are out of the window by then.
+	if good, bad := tr.Counts(start.Add(3 * time.Hour)); good != 1 || bad != 0 {
+		t.Errorf("Counts() = %d, %d, want 1, 0", good, bad)
+	}
+	// Too old to be accounted.
+	tr.Record(start.Add(time.Minute), 150)
+	if good, bad := tr.Counts(start.Add(3 * time.Hour)); good != 1 || bad != 0 {
+		t.Errorf("Counts() after an out of the window execution = %d, %d, want 1, 0", good, bad)
+	}
+}
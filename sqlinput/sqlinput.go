@@ -47,9 +47,27 @@ const boilerPlate = `#
 # - One line per business transaction that users care about most
 # - Each non-commented line must consist of at least 3 comma-delimited fields:
 #      "Business Tx Name", "Monitoring/Alerting Threshold", list (but at least one of) SQL IDs.
+# - The threshold is on the elapsed time in milliseconds, possibly fractional (e.g. 0.5), unless
+#   its unit is explicit, e.g. 2s or 150ms.
+# - A SQL ID may be replaced by a SQL text pattern (a regular expression) prefixed with text:
+#   for the SQLs whose SQL IDs are not stable, e.g. "text:^INSERT INTO OE\.ORDERS"
+# - A SQL ID may also be replaced by an application context rule: space delimited module=,
+#   action=, clientid=, service= and (optionally) sqlid= pairs, e.g. module=OE_ENTRY action=SUBMIT
+#   Values with spaces are enclosed in single quotes, e.g. module='JDBC Thin Client'
+# - Optionally, a field prefixed with limit: caps the I/O and rows of an execution on top of the
+#   threshold: space delimited gets=, cr=, cu=, p= (physical reads), r= (rows) and mis= pairs,
+#   e.g. limit:gets=50000 r=10000
+# - Optionally, a field prefixed with allowerrors: lists the ORA- errors expected of the
+#   business transaction that are not to be reported, e.g. "allowerrors:1403 ORA-00001"
+# - Optionally, a field prefixed with slo: sets the Service Level Objective of the business
+#   transaction: the percentage of its executions under a threshold (the one of the business
+#   transaction by default) over a rolling window (28d by default),
+#   e.g. "slo:target=99.9 threshold=2s window=28d"
 #
 # Each field may be optionally enclosed in double quotes.  Blank lines are ignored.
 #
+# Alternatively, the file may be in the structured (YAML or JSON) format, see README.md.
+#
 
 `
 
@@ -89,7 +107,8 @@ func PersistSQLInput(expSQL []ExpensiveSQL) error {
 		if v.elaPerExec > 1 {
 			threshold = math.Floor(v.elaPerExec + v.elaPerExec*bumpUpPercent + .5)
 		}
-		expSQLAll += fmt.Sprintf("ExpensiveSQL#%d, %.0f, %s\n", i+1, threshold, v.sqlid)
+		// AWR reports the elapsed time in seconds, whereas a plain threshold is taken as milliseconds.
+		expSQLAll += fmt.Sprintf("ExpensiveSQL#%d, %.0fs, %s\n", i+1, threshold, v.sqlid)
 		expSQLAllComment += "#   ela=" + v.ela + ", execs=" + v.execs + ", elaPerExec=" +
 			v.elaPerExecString + ", % Total=" + v.percentTotal + ", % CPU=" + v.percentCPU + ",% I/O=" +
 			v.percentIO + ", SQLid=" + v.sqlid + "\n"
@@ -0,0 +1,133 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stats counts all the executions of the business transactions and of their SQL IDs,
+// the good ones as well as the violations, along with a histogram of their elapsed times.
+package stats
+
+import (
+	"sort"
+	"sync"
+)
+
+// Buckets are the upper bounds (inclusive, in milliseconds) of the buckets of the elapsed time
+// histogram.  The last bucket of a histogram counts the executions over the last bound.
+var Buckets = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// Key identifies the counts of a business tx (with no SQLID) or of a SQL ID of a business tx.
+type Key struct {
+	BusinessTxName string
+	SQLID          string
+}
+
+// Counts are the executions of a business tx or of a SQL ID.
+type Counts struct {
+	Executions int64
+	Violations int64
+	TotalELA   float64 // Milliseconds
+	LastELA    float64 // Milliseconds, of the execution added last
+	WorstELA   float64 // Milliseconds, of the longest execution
+	Histogram  []int64 // Executions per bucket: len(Buckets)+1, not cumulative
+}
+
+// AvgELA returns the average elapsed time of the executions in milliseconds.
+func (c Counts) AvgELA() float64 {
+	if c.Executions == 0 {
+		return 0
+	}
+	return c.TotalELA / float64(c.Executions)
+}
+
+// ViolationRatio returns the share of the executions that were violations.
+func (c Counts) ViolationRatio() float64 {
+	if c.Executions == 0 {
+		return 0
+	}
+	return float64(c.Violations) / float64(c.Executions)
+}
+
+// Entry is a snapshot of the counts of a key.
+type Entry struct {
+	Key
+	Counts
+}
+
+// Registry keeps the counts of all the keys.
+// It is safe to use from multiple goroutines simultaneously.
+type Registry struct {
+	sync.Mutex
+	counts map[Key]*Counts
+}
+
+// NewRegistry returns a registry with no executions.
+func NewRegistry() *Registry {
+	return &Registry{counts: make(map[Key]*Counts)}
+}
+
+// Add accounts an execution of a key with an elapsed time (in milliseconds) and whether or
+// not it was a violation.
+func (r *Registry) Add(k Key, ela float64, violation bool) {
+	r.Lock()
+	defer r.Unlock()
+	c, ok := r.counts[k]
+	if !ok {
+		c = &Counts{Histogram: make([]int64, len(Buckets)+1)}
+		r.counts[k] = c
+	}
+	c.Executions++
+	if violation {
+		c.Violations++
+	}
+	c.TotalELA += ela
+	c.LastELA = ela
+	if ela > c.WorstELA {
+		c.WorstELA = ela
+	}
+	c.Histogram[sort.SearchFloat64s(Buckets, ela)]++
+}
+
+// Get returns the counts of a key, zero ones (with no histogram) if the key has no executions.
+func (r *Registry) Get(k Key) Counts {
+	r.Lock()
+	defer r.Unlock()
+	c, ok := r.counts[k]
+	if !ok {
+		return Counts{}
+	}
+	return c.copy()
+}
+
+// Snapshot returns the counts of all the keys, ordered by business tx and SQL ID (the
+// counts of a business tx come first).
+func (r *Registry) Snapshot() []Entry {
+	r.Lock()
+	defer r.Unlock()
+	entries := make([]Entry, 0, len(r.counts))
+	for k, c := range r.counts {
+		entries = append(entries, Entry{Key: k, Counts: c.copy()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].BusinessTxName != entries[j].BusinessTxName {
+			return entries[i].BusinessTxName < entries[j].BusinessTxName
+		}
+		return entries[i].SQLID < entries[j].SQLID
+	})
+	return entries
+}
+
+// copy returns a copy of the counts that doesn't share the histogram.
+func (c *Counts) copy() Counts {
+	cp := *c
+	cp.Histogram = append([]int64(nil), c.Histogram...)
+	return cp
+}
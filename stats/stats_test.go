@@ -0,0 +1,66 @@
+/*
+Copyright 2016 Google Inc. All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Stats_test runs unit tests on the execution counts.
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestAdd(t *testing.T) {
+	r := NewRegistry()
+	tx := Key{BusinessTxName: "Order Entry"}
+	sql := Key{BusinessTxName: "Order Entry", SQLID: "acc988uzvjmmt"}
+	for _, ela := range []float64{0.5, 1, 7, 99.9, 120000} {
+		r.Add(tx, ela, ela > 100)
+	}
+	r.Add(sql, 250, true)
+
+	histogram := make([]int64, len(Buckets)+1)
+	histogram[0] = 2  // 0.5 and 1: up to 1 inclusive
+	histogram[3] = 1  // 7: up to 10
+	histogram[6] = 1  // 99.9: up to 100
+	histogram[15] = 1 // 120000: over 60000
+	want := Counts{Executions: 5, Violations: 1, TotalELA: 120108.4, LastELA: 120000, WorstELA: 120000, Histogram: histogram}
+	got := r.Get(tx)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get(%v): -> diff -got +want\n%s", tx, pretty.Compare(got, want))
+	}
+	if avg := got.AvgELA(); avg < 24021.67 || avg > 24021.69 {
+		t.Errorf("AvgELA() = %v, want 24021.68", avg)
+	}
+	if ratio := got.ViolationRatio(); ratio != 0.2 {
+		t.Errorf("ViolationRatio() = %v, want 0.2", ratio)
+	}
+
+	// Snapshots don't change along with the registry.
+	snapshot := r.Snapshot()
+	r.Add(sql, 1, false)
+	if len(snapshot) != 2 || snapshot[0].Key != tx || snapshot[1].Key != sql || snapshot[1].Executions != 1 || snapshot[1].Histogram[7] != 1 {
+		t.Errorf("Snapshot() = %+v, want the counts of %v then of %v", snapshot, tx, sql)
+	}
+	if got := r.Get(sql); got.Executions != 2 || got.Histogram[0] != 1 || got.LastELA != 1 || got.WorstELA != 250 {
+		t.Errorf("Get(%v) = %+v, want 2 executions, the last of 1 and the worst of 250", sql, got)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	got := NewRegistry().Get(Key{BusinessTxName: "Order Entry"})
+	if got.Executions != 0 || got.AvgELA() != 0 || got.ViolationRatio() != 0 || got.Histogram != nil {
+		t.Errorf("Get() of a key with no executions = %+v, want none", got)
+	}
+}
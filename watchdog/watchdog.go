@@ -19,6 +19,7 @@ package watchdog
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path"
@@ -28,8 +29,8 @@ import (
 	"time"
 	"log"
 
-	"github.com/borisdali/rttanalyzer/cursor"
 	"github.com/borisdali/rttanalyzer/miner"
+	"github.com/borisdali/rttanalyzer/otlp"
 	"github.com/borisdali/rttanalyzer/rttanalyzer"
 	"github.com/borisdali/rttanalyzer/sink"
 	"github.com/howeyc/fsnotify"
@@ -43,47 +44,136 @@ const varzDir = "/opt/mg-agent-xp/data.d"
 
 var Debug bool
 
-// output returns an instantiated object of the output media: a Varz, Streamz or Pub/Sub.
-// outputType can be one of varz, pubsub (with streamz not implemented yet).
-func output(dbName string, outputType string, sqlFile string, client *pubsub.Client) (miner.Dumper, error) {
-	cur := &sink.CursorTrackerProtected{Cursors: make(map[int64]*cursor.Cursor)}
+// sqlLoader is implemented by all the output media: LoadSQL reads the SQL statements of
+// interest the trace records are parsed with.
+type sqlLoader interface {
+	LoadSQL() error
+}
 
+// outputs returns an instantiated object of the output media of a comma separated list of
+// outputtypes, e.g. varz, webhook, prometheus: the one of output for a single outputtype, a
+// Fanout delivering each event to all of them otherwise.
+func outputs(ctx context.Context, dbName string, outputTypes string, sqlFile string, client *pubsub.Client, opts sink.Options) (miner.Dumper, error) {
+	var types []string
+	seen := make(map[string]bool)
+	for _, outputType := range strings.Split(outputTypes, ",") {
+		if outputType = strings.TrimSpace(outputType); outputType == "" {
+			continue
+		}
+		if seen[outputType] {
+			return nil, fmt.Errorf("output error: outputtype %v is listed more than once", outputType)
+		}
+		seen[outputType] = true
+		types = append(types, outputType)
+	}
+	if len(types) == 1 {
+		dumper, err := output(ctx, dbName, types[0], sqlFile, client, opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := dumper.(sqlLoader).LoadSQL(); err != nil {
+			log.Fatalf("%s LoadSQL: error reading SQL statements input file: %v. Aborting.", types[0], err)
+		}
+		return dumper, nil
+	}
+
+	fmt.Printf("[%v] info> the output media requested for RTTAnalyzer are %s, each event is delivered to all of them.\n", time.Now().Format("2006-01-02 15:04:05"), strings.Join(types, ", "))
+	fDump := &sink.Fanout{
+		Generic: sink.Generic{
+			DBName:  dbName,
+			FileSQL: sqlFile,
+			Client:  client,
+			Options: opts,
+		},
+	}
+	for _, outputType := range types {
+		dumper, err := output(ctx, dbName, outputType, sqlFile, client, opts)
+		if err != nil {
+			return nil, err
+		}
+		fDump.Sinks = append(fDump.Sinks, dumper)
+	}
+	// The Fanout parses the records for all of its output media: they need no SQL statements of their own.
+	if err := fDump.LoadSQL(); err != nil {
+		log.Fatalf("fanout LoadSQL: error reading SQL statements input file: %v. Aborting.", err)
+	}
+	if err := fDump.Init(); err != nil {
+		return nil, fmt.Errorf("fanout Init: %v", err)
+	}
+	return fDump, nil
+}
+
+// output returns an instantiated object of the output media: a Varz, Pub/Sub, Prometheus, OTLP, Webhook, Syslog, Email or Exec.
+// outputType can be one of varz, pubsub, prometheus, otlp, webhook, syslog, email or exec.
+// The SQL statements of interest are left for the caller to load, unless the output media is
+// wrapped by a Fanout that parses the records for it.
+func output(ctx context.Context, dbName string, outputType string, sqlFile string, client *pubsub.Client, opts sink.Options) (miner.Dumper, error) {
+	// Generic holds a sync.Once: each output media gets a Generic of its own rather than a copy.
+	generic := func() sink.Generic {
+		return sink.Generic{
+			DBName:  dbName,
+			FileSQL: sqlFile,
+			Client:  client,
+			Options: opts,
+		}
+	}
 	switch outputType {
 	case "varz":
-                fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is an ASCII file (referred to as VarZ).\n", time.Now().Format("2006-01-02 15:04:05"))
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is an ASCII file (referred to as VarZ).\n", time.Now().Format("2006-01-02 15:04:05"))
 		vzDump := &sink.Varz{
-			Generic: sink.Generic{
-				DBName:        dbName,
-				FileSQL:       sqlFile,
-				Client:        client,
-				CursorTracker: cur,
-			},
+			Generic:       generic(),
 			Dir:           varzDir,
 			FilePrefix:    "rttanalyzer",
 			FileExtension: ".varz",
 		}
-		if err := vzDump.LoadSQL(); err != nil {
-			log.Fatalf("varz LoadSQL: error reading SQL statements input file: %v. Aborting.", err)
-			return nil, fmt.Errorf("varz LoadSQL: error reading SQL statements input file: %v. Aborting", err)
-		}
 		return vzDump, nil
 	case "pubsub":
-                fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is Pub/Sub.\n", time.Now().Format("2006-01-02 15:04:05"))
-		psDump := &sink.PubSub{
-			Generic: sink.Generic{
-				DBName:        dbName,
-				FileSQL:       sqlFile,
-				Client:        client,
-				CursorTracker: cur,
-			},
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is Pub/Sub.\n", time.Now().Format("2006-01-02 15:04:05"))
+		psDump := &sink.PubSub{Generic: generic()}
+		return psDump, nil
+	case "prometheus":
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is Prometheus.\n", time.Now().Format("2006-01-02 15:04:05"))
+		pDump := &sink.Prometheus{Generic: generic()}
+		if err := pDump.Serve(); err != nil {
+			return nil, fmt.Errorf("prometheus Serve: %v", err)
 		}
-		if err := psDump.LoadSQL(); err != nil {
-			log.Fatalf("pubsub LoadSQL: error reading SQL statements input file: %v. Aborting.", err)
-			return nil, fmt.Errorf("pubsub LoadSQL: error reading SQL statements input file: %v. Aborting", err)
+		return pDump, nil
+	case "otlp":
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is an OpenTelemetry collector (OTLP).\n", time.Now().Format("2006-01-02 15:04:05"))
+		oDump := &sink.OTLP{Generic: generic()}
+		oDump.Start(ctx)
+		return oDump, nil
+	case "webhook":
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is a webhook.\n", time.Now().Format("2006-01-02 15:04:05"))
+		whDump := &sink.Webhook{Generic: generic()}
+		if err := whDump.Init(); err != nil {
+			return nil, fmt.Errorf("webhook Init: %v", err)
 		}
-		return psDump, nil
+		return whDump, nil
+	case "syslog":
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is syslog.\n", time.Now().Format("2006-01-02 15:04:05"))
+		slDump := &sink.Syslog{Generic: generic()}
+		if err := slDump.Init(); err != nil {
+			return nil, fmt.Errorf("syslog Init: %v", err)
+		}
+		return slDump, nil
+	case "email":
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is email.\n", time.Now().Format("2006-01-02 15:04:05"))
+		emDump := &sink.Email{Generic: generic()}
+		if err := emDump.Init(); err != nil {
+			return nil, fmt.Errorf("email Init: %v", err)
+		}
+		emDump.Start(ctx)
+		return emDump, nil
+	case "exec":
+		fmt.Printf("[%v] info> the output media requested for RTTAnalyzer is a command.\n", time.Now().Format("2006-01-02 15:04:05"))
+		exDump := &sink.Exec{Generic: generic()}
+		if err := exDump.Init(); err != nil {
+			return nil, fmt.Errorf("exec Init: %v", err)
+		}
+		return exDump, nil
 	}
-	errStr := fmt.Sprintf("outputtype can be one of varz, pubsub (with streamz not implemented yet). Got %v instead.", outputType)
+	errStr := fmt.Sprintf("outputtype can be one of varz, pubsub, prometheus, otlp, webhook, syslog, email or exec. Got %v instead.", outputType)
 	return nil, fmt.Errorf("output error: %s", errStr)
 }
 
@@ -157,15 +247,17 @@ func checkFile(ctx context.Context, client *pubsub.Client, service *bqgen.Servic
 	if Debug { fmt.Printf("[%v] dbg> active traces/miners:active channels=%v (ch=%v)\n", time.Now().Format("2006-01-02 15:04:05"), s.traces, ch)}
 }
 
-// Run calls output to initialize a dumper and sets up a watcher on a directory of choice.
-func Run(ctx context.Context, client *pubsub.Client, service *bqgen.Service, dbName string, dirName string, sqlInput string, mode string, outputType string, projectName string) error {
+// Run calls outputs to initialize a dumper and sets up a watcher on a directory of choice.
+// opts carries the rtta.conf tunables that are common to all the output media.
+func Run(ctx context.Context, client *pubsub.Client, service *bqgen.Service, dbName string, dirName string, sqlInput string, mode string, outputType string, projectName string, opts sink.Options) error {
 
 	if Debug {
 		sink.Debug = Debug
 		miner.Debug = Debug
+		otlp.Debug = Debug
 	}
 
-	dumper, err := output(dbName, outputType, sqlInput, client)
+	dumper, err := outputs(ctx, dbName, outputType, sqlInput, client, opts)
 	if err != nil {
 		return fmt.Errorf("watchdog: output error: %v", err)
 	}
@@ -208,6 +300,18 @@ func Run(ctx context.Context, client *pubsub.Client, service *bqgen.Service, dbN
 	for {
 		select {
 		case <-cntrlc:
+			// The output media that deliver in the background get to send out what they hold.
+			if c, ok := dumper.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					fmt.Printf("[%v] error> could not close the output media: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+				}
+			}
+			// The baselines being learned keep what they learned since they were last saved.
+			if opts.Baseline != nil {
+				if err := opts.Baseline.Save(); err != nil {
+					fmt.Printf("[%v] error> could not save the baselines: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+				}
+			}
 			fmt.Printf("[%v] Cntrl-C is pressed and so returning from the Watchdog back to RTTA.", time.Now().Format("2006-01-02 15:04:05"))
 			return nil
 		case event := <-watcher.Event: